@@ -20,8 +20,8 @@ func work() {
 			return
 		}
 		stop.Unlock()
-		if config.Agent.ServerAddress == "" || config.Agent.AuthToken == "" || config.Agent.MachineName == "" {
-			log.Error("missing mandatory configuration, please run deepsentinel config server-address, auth-token, and machine-name")
+		if config.Agent.ServerAddress == "" || (config.Agent.AuthToken == "" && config.Agent.TLS.CertFile == "") || config.Agent.MachineName == "" {
+			log.Error("missing mandatory configuration, please run deepsentinel config server-address, auth-token (unless a client certificate is set), and machine-name")
 			stop.Lock()
 			stop.val = true
 			stop.Unlock()
@@ -0,0 +1,41 @@
+package agent
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/equals215/deepsentinel/config"
+	"github.com/equals215/deepsentinel/tlsconfig"
+)
+
+// client is the HTTP client shared by the requests to the server
+// It is rebuilt when the TLS configuration changes, the client certificate is reloaded once rotated
+var client = struct {
+	sync.Mutex
+	tls    config.AgentTLSConfig
+	client *http.Client
+}{}
+
+// serverClient returns the HTTP client to the server, caller must hold the agent config lock
+func serverClient() (*http.Client, error) {
+	client.Lock()
+	defer client.Unlock()
+
+	tlsConfig := config.Agent.TLS
+	if client.client != nil && client.tls == tlsConfig {
+		return client.client, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig.CAFile != "" || tlsConfig.CertFile != "" {
+		files, err := tlsconfig.Load(tlsConfig.CertFile, tlsConfig.KeyFile, tlsConfig.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading TLS files: %v", err)
+		}
+		transport.TLSClientConfig = tlsconfig.Client(files)
+	}
+	client.client = &http.Client{Transport: transport}
+	client.tls = tlsConfig
+	return client.client, nil
+}
@@ -55,6 +55,10 @@ func Cmd(rootCmd *cobra.Command) {
 	agentCmd.Flags().StringVarP(&serverAddress, "server-address", "u", "", "Server address\nEnvironment variable: DEEPSENTINEL_SERVER_ADDRESS\n\b")
 	agentCmd.Flags().StringVarP(&authToken, "auth-token", "t", "", "Auth token\nEnvironment variable: DEEPSENTINEL_AUTH_TOKEN\n\b")
 	agentCmd.Flags().StringVarP(&machineName, "machine-name", "m", "", "Machine name\nEnvironment variable: DEEPSENTINEL_MACHINE_NAME\n\b")
+	agentCmd.Flags().String("tls.ca-file", "", "CA bundle verifying the server certificate, defaults to the system roots\nEnvironment variable: DEEPSENTINEL_TLS_CA_FILE\n\b")
+	agentCmd.Flags().String("tls.cert-file", "", "Client certificate presented to the server\nEnvironment variable: DEEPSENTINEL_TLS_CERT_FILE\n\b")
+	agentCmd.Flags().String("tls.key-file", "", "Key of the client certificate\nEnvironment variable: DEEPSENTINEL_TLS_KEY_FILE\n\b")
+	agentCmd.Flags().String("signing-secret", "", "Secret signing the reports, must be the signed-reports.secret of the server\nEnvironment variable: DEEPSENTINEL_SIGNING_SECRET\n\b")
 	agentCmd.Flags().StringVarP(&loggingLevel, "logging-level", "l", "info", "Logging level\nEnvironment variable: DEEPSENTINEL_LOGGING_LEVEL\n\b")
 
 	config.BindFlags(agentCmd.Flags())
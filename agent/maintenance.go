@@ -0,0 +1,141 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/equals215/deepsentinel/config"
+	"github.com/spf13/cobra"
+)
+
+// MaintenanceCmd provides maintenance cli command
+func MaintenanceCmd(rootCmd *cobra.Command) {
+	var duration time.Duration
+	var service string
+	var reason string
+
+	maintenanceCmd := &cobra.Command{
+		Use:   "maintenance",
+		Short: "Silence this machine on the server during a maintenance",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+
+	startCmd := &cobra.Command{
+		Use:   "start",
+		Short: "Start a maintenance, lasts until stopped unless --for is set",
+		Args:  cobra.ExactArgs(0),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			config.CraftAgentConfig()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if duration < 0 {
+				return fmt.Errorf("--for must be a positive duration")
+			}
+			if err := reportSilence(duration, service, reason); err != nil {
+				return err
+			}
+			target := "machine"
+			if service != "" {
+				target = "service " + service
+			}
+			if duration > 0 {
+				fmt.Printf("Maintenance started, %s silenced for %s\n", target, duration)
+			} else {
+				fmt.Printf("Maintenance started, %s silenced until stopped\n", target)
+			}
+			return nil
+		},
+	}
+	startCmd.Flags().DurationVar(&duration, "for", 0, "Duration of the maintenance, e.g. 2h")
+	startCmd.Flags().StringVar(&service, "service", "", "Only silence this service")
+	startCmd.Flags().StringVar(&reason, "reason", "", "Reason of the maintenance")
+
+	stopCmd := &cobra.Command{
+		Use:   "stop",
+		Short: "Stop a maintenance",
+		Args:  cobra.ExactArgs(0),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			config.CraftAgentConfig()
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := reportClearSilence(service); err != nil {
+				return err
+			}
+			fmt.Println("Maintenance stopped")
+			return nil
+		},
+	}
+	stopCmd.Flags().StringVar(&service, "service", "", "Stop the maintenance of this service")
+
+	maintenanceCmd.AddCommand(startCmd, stopCmd)
+	rootCmd.AddCommand(maintenanceCmd)
+}
+
+func reportSilence(duration time.Duration, service, reason string) error {
+	request := map[string]string{
+		"service": service,
+		"reason":  reason,
+	}
+	if duration > 0 {
+		request["for"] = duration.String()
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("error marshalling silence: %v", err)
+	}
+
+	return sendSilenceRequest("POST", "", body, http.StatusCreated)
+}
+
+func reportClearSilence(service string) error {
+	query := ""
+	if service != "" {
+		query = "?service=" + url.QueryEscape(service)
+	}
+	return sendSilenceRequest("DELETE", query, nil, http.StatusOK)
+}
+
+func sendSilenceRequest(method, query string, body []byte, expectedStatus int) error {
+	config.Agent.Lock()
+	defer config.Agent.Unlock()
+
+	if config.Agent.MachineName == "" {
+		return fmt.Errorf("machine name not set")
+	}
+	rawURL := fmt.Sprintf("%s/probe/%s/silence%s", config.Agent.ServerAddress, url.PathEscape(config.Agent.MachineName), query)
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("error parsing server address: %v", err)
+	}
+
+	req, err := http.NewRequest(method, parsedURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating %s request: %v", method, err)
+	}
+	req.Header.Set("Authorization", config.Agent.AuthToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	client, err := serverClient()
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error sending %s request: %v", method, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("server doesn't know this machine or its silence")
+	}
+	if resp.StatusCode != expectedStatus {
+		return fmt.Errorf("unexpected response status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -2,14 +2,41 @@ package agent
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/equals215/deepsentinel/config"
+	"github.com/equals215/deepsentinel/signing"
 )
 
+// reportPayload is the body of a report sent to the server
+type reportPayload struct {
+	MachineStatus string                   `json:"machineStatus"`
+	Services      map[string]string        `json:"services,omitempty"`
+	Thresholds    *config.ThresholdsConfig `json:"thresholds,omitempty"`
+	Labels        map[string]string        `json:"labels,omitempty"`
+}
+
+// lastSequence is the sequence of the last signed report, guarded by the agent config lock
+// It starts from the clock so that it keeps increasing across restarts
+var lastSequence uint64
+
+// signReport sets the signature headers of a report of machine, caller must hold the agent config lock
+func signReport(req *http.Request, machine string, body []byte) {
+	now := time.Now()
+	lastSequence = max(lastSequence+1, uint64(now.UnixNano()))
+	req.Header.Set(signing.HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(signing.HeaderSequence, strconv.FormatUint(lastSequence, 10))
+	req.Header.Set(signing.HeaderSignature, signing.Sign(config.Agent.SigningSecret, machine, now.Unix(), lastSequence, body))
+}
+
 func reportPanic() {}
 
 func reportWatcherDied() {}
@@ -38,7 +65,10 @@ func reportUnregisterAgent() error {
 	req.Header.Set("Authorization", config.Agent.AuthToken)
 
 	// Send the request
-	client := &http.Client{}
+	client, err := serverClient()
+	if err != nil {
+		return err
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("error sending DELETE request: %v", err)
@@ -54,6 +84,28 @@ func reportUnregisterAgent() error {
 }
 
 func reportAlive() error {
+	// Service checks can take a while so they run without holding the config lock
+	config.Agent.Lock()
+	services := config.Agent.Services
+	machineChecks := config.Agent.MachineChecks
+	thresholds := config.Agent.Thresholds
+	labels := maps.Clone(config.Agent.Labels)
+	config.Agent.Unlock()
+
+	payload := &reportPayload{
+		MachineStatus: worstStatus(runServiceChecks(machineChecks)),
+		Services:      runServiceChecks(services),
+		Labels:        labels,
+	}
+	if !thresholds.IsZero() {
+		payload.Thresholds = &thresholds
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error marshalling report: %v", err)
+	}
+
 	config.Agent.Lock()
 	defer config.Agent.Unlock()
 
@@ -72,11 +124,16 @@ func reportAlive() error {
 	}
 
 	req.Header.Set("Authorization", config.Agent.AuthToken)
-	body := []byte(`{"machineStatus":"pass"}`)
 	req.Body = io.NopCloser(bytes.NewBuffer(body))
 	req.Header.Set("Content-Type", "application/json")
+	if config.Agent.SigningSecret != "" {
+		signReport(req, strings.TrimSpace(config.Agent.MachineName), body)
+	}
 
-	client := &http.Client{}
+	client, err := serverClient()
+	if err != nil {
+		return err
+	}
 	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("error sending POST request: %v", err)
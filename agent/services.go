@@ -0,0 +1,167 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/equals215/deepsentinel/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultServiceCheckTimeout = 5 * time.Second
+
+// serviceCheck checks the given target and returns either "pass", "warn" or "fail"
+type serviceCheck func(ctx context.Context, target string) string
+
+var serviceChecks = map[string]serviceCheck{
+	"systemd": checkSystemd,
+	"process": checkProcess,
+	"tcp":     checkTCP,
+	"http":    checkHTTP,
+	"command": checkCommand,
+}
+
+// runServiceChecks runs every configured service check concurrently
+// and returns the status of each service indexed by its name
+func runServiceChecks(services []config.ServiceConfig) map[string]string {
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	results := make(map[string]string, len(services))
+
+	for _, service := range services {
+		if service.Name == "" {
+			log.WithField("type", service.Type).Error("Service check has no name, skipping")
+			continue
+		}
+
+		check, ok := serviceChecks[service.Type]
+		if !ok {
+			log.WithFields(log.Fields{
+				"service": service.Name,
+				"type":    service.Type,
+			}).Error("Unknown service check type, reporting fail")
+			results[service.Name] = "fail"
+			continue
+		}
+
+		timeout := defaultServiceCheckTimeout
+		if service.Timeout != "" {
+			parsed, err := time.ParseDuration(service.Timeout)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"service": service.Name,
+					"timeout": service.Timeout,
+				}).Warn("Invalid service check timeout, using default")
+			} else {
+				timeout = parsed
+			}
+		}
+
+		wg.Add(1)
+		go func(name, target string, check serviceCheck, timeout time.Duration) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+
+			status := check(ctx, target)
+			log.WithFields(log.Fields{
+				"service": name,
+				"status":  status,
+			}).Trace("Service checked")
+
+			mu.Lock()
+			results[name] = status
+			mu.Unlock()
+		}(service.Name, service.Target, check, timeout)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// worstStatus returns the worst of the given check results, pass when there is none
+func worstStatus(results map[string]string) string {
+	worst := "pass"
+	for _, status := range results {
+		switch status {
+		case "fail":
+			return "fail"
+		case "warn":
+			worst = "warn"
+		}
+	}
+	return worst
+}
+
+// checkSystemd passes if the systemd unit is active and warns if it is transitioning
+func checkSystemd(ctx context.Context, unit string) string {
+	output, _ := exec.CommandContext(ctx, "systemctl", "is-active", unit).Output()
+	switch strings.TrimSpace(string(output)) {
+	case "active":
+		return "pass"
+	case "activating", "reloading", "deactivating":
+		return "warn"
+	default:
+		return "fail"
+	}
+}
+
+// checkProcess passes if at least one process matches the given name exactly
+func checkProcess(ctx context.Context, name string) string {
+	err := exec.CommandContext(ctx, "pgrep", "-x", name).Run()
+	if err != nil {
+		return "fail"
+	}
+	return "pass"
+}
+
+// checkTCP passes if a TCP connection can be opened to the given host:port
+func checkTCP(ctx context.Context, address string) string {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return "fail"
+	}
+	conn.Close()
+	return "pass"
+}
+
+// checkHTTP passes if a GET on the given URL answers with a 2xx status code
+func checkHTTP(ctx context.Context, url string) string {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "fail"
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "fail"
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "fail"
+	}
+	return "pass"
+}
+
+// checkCommand runs the given shell command and maps its exit code
+// like monitoring plugins do: 0 is pass, 1 is warn and anything else is fail
+func checkCommand(ctx context.Context, command string) string {
+	err := exec.CommandContext(ctx, "sh", "-c", command).Run()
+	if err == nil {
+		return "pass"
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return "warn"
+	}
+	return "fail"
+}
@@ -0,0 +1,67 @@
+package agent
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/equals215/deepsentinel/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunServiceChecks(t *testing.T) {
+	okServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer okServer.Close()
+
+	koServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer koServer.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err, "Failed to open TCP listener")
+	defer listener.Close()
+
+	closedListener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err, "Failed to open TCP listener")
+	closedAddress := closedListener.Addr().String()
+	closedListener.Close()
+
+	services := []config.ServiceConfig{
+		{Name: "http-ok", Type: "http", Target: okServer.URL},
+		{Name: "http-ko", Type: "http", Target: koServer.URL},
+		{Name: "tcp-open", Type: "tcp", Target: listener.Addr().String()},
+		{Name: "tcp-closed", Type: "tcp", Target: closedAddress},
+		{Name: "command-pass", Type: "command", Target: "exit 0"},
+		{Name: "command-warn", Type: "command", Target: "exit 1"},
+		{Name: "command-fail", Type: "command", Target: "exit 2"},
+		{Name: "command-timeout", Type: "command", Target: "sleep 5", Timeout: "100ms"},
+		{Name: "unknown", Type: "unknown", Target: "whatever"},
+		{Name: "", Type: "tcp", Target: listener.Addr().String()},
+	}
+
+	results := runServiceChecks(services)
+	assert.Equal(t, map[string]string{
+		"http-ok":         "pass",
+		"http-ko":         "fail",
+		"tcp-open":        "pass",
+		"tcp-closed":      "fail",
+		"command-pass":    "pass",
+		"command-warn":    "warn",
+		"command-fail":    "fail",
+		"command-timeout": "fail",
+		"unknown":         "fail",
+	}, results)
+}
+
+func TestWorstStatus(t *testing.T) {
+	// Test case 1: no machine check reports pass
+	assert.Equal(t, "pass", worstStatus(map[string]string{}))
+
+	// Test case 2: the worst result wins
+	assert.Equal(t, "warn", worstStatus(map[string]string{"disk": "pass", "ntp": "warn"}))
+	assert.Equal(t, "fail", worstStatus(map[string]string{"disk": "fail", "ntp": "warn", "self": "pass"}))
+}
@@ -0,0 +1,87 @@
+// Package alert defines the alerts handed over to the alert providers.
+package alert
+
+import (
+	"fmt"
+	"time"
+)
+
+// Reasons of the service alerts
+const (
+	// ReasonFail is set on alerts raised by consecutive fail reports
+	ReasonFail = "fail"
+	// ReasonWarn is set on alerts raised by consecutive warn reports
+	ReasonWarn = "warn"
+	// ReasonFlapping is set on alerts raised by a service changing status too often
+	ReasonFlapping = "flapping"
+	// ReasonMissed is set on alerts raised by a job that didn't check in on schedule
+	ReasonMissed = "missed"
+	// ReasonTimeout is set on alerts raised by a job running longer than allowed
+	ReasonTimeout = "timeout"
+)
+
+// Alert is an alert about a component sent to, or resolved on, the alert providers
+// Reason is empty for alerts that aren't raised by service reports
+type Alert struct {
+	Category   string
+	Component  string
+	Severity   string
+	Reason     string
+	Timestamp  time.Time
+	LastNormal time.Time
+}
+
+// New creates an alert timestamped now
+func New(category, component, severity string) *Alert {
+	return &Alert{
+		Category:  category,
+		Component: component,
+		Severity:  severity,
+		Timestamp: time.Now(),
+	}
+}
+
+// WithLastNormal sets the last time the component was seen in normal state
+func (a *Alert) WithLastNormal(lastNormal time.Time) *Alert {
+	a.LastNormal = lastNormal
+	return a
+}
+
+// WithReason sets the status of the reports that raised the alert
+func (a *Alert) WithReason(reason string) *Alert {
+	a.Reason = reason
+	return a
+}
+
+// SinceLastNormal returns for how long the component hasn't been normal at the alert time
+// It is zero when the last normal time is unknown
+func (a *Alert) SinceLastNormal() time.Duration {
+	if a.LastNormal.IsZero() {
+		return 0
+	}
+	return a.Timestamp.Sub(a.LastNormal)
+}
+
+// ReasonSuffix returns a suffix to append to alert summaries so that
+// alerts raised by persistent warn reports, flapping or check-ins read differently from fail ones
+func (a *Alert) ReasonSuffix() string {
+	switch a.Reason {
+	case ReasonWarn:
+		return " (persistent warn)"
+	case ReasonFlapping:
+		return " (flapping)"
+	case ReasonMissed:
+		return " (missed check-in)"
+	case ReasonTimeout:
+		return " (run too long)"
+	default:
+		return ""
+	}
+}
+
+// DedupKey identifies the incident of the alerted component
+// It doesn't depend on the severity so that a low alert escalated to high,
+// and its later resolution, all land on the same incident
+func (a *Alert) DedupKey() string {
+	return fmt.Sprintf("deepsentinel-%s-%s", a.Category, a.Component)
+}
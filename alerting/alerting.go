@@ -2,19 +2,27 @@ package alerting
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/equals215/deepsentinel/alerting/alert"
+	"github.com/equals215/deepsentinel/alerting/providers/keephq"
 	"github.com/equals215/deepsentinel/alerting/providers/pagerduty"
+	"github.com/equals215/deepsentinel/alerting/providers/smtp"
+	"github.com/equals215/deepsentinel/alerting/providers/webhook"
 	"github.com/equals215/deepsentinel/config"
 	log "github.com/sirupsen/logrus"
 )
 
 type AlertingConfig struct {
-	lowAlertProvider  AlertProvider
-	highAlertProvider AlertProvider
+	lowAlertProviders  []AlertProvider
+	highAlertProviders []AlertProvider
+	lowAlertPolicy     deliveryPolicy
+	highAlertPolicy    deliveryPolicy
 }
 
 type AlertProvider interface {
-	Send(category, name, status string) error
+	Send(a *alert.Alert) error
+	Resolve(a *alert.Alert) error
 	Name() string
 }
 
@@ -25,45 +33,71 @@ func Init(config *config.ServerConfig, noAlerting bool) {
 }
 
 func _init(serverConfig *config.ServerConfig, noAlerting bool) {
-	var err error
+	Config.lowAlertProviders = craftProviders(serverConfig.LowAlertProviders, "low")
+	Config.highAlertProviders = craftProviders(serverConfig.HighAlertProviders, "high")
+	Config.lowAlertPolicy = newDeliveryPolicy(serverConfig.LowAlertPolicy, serverConfig.LowAlertQuorum)
+	Config.highAlertPolicy = newDeliveryPolicy(serverConfig.HighAlertPolicy, serverConfig.HighAlertQuorum)
 
-	if serverConfig.LowAlertProvider.Type() != config.EmptyProviderType {
-		providerConfig := serverConfig.LowAlertProvider
-		Config.lowAlertProvider, err = craftProvider(providerConfig)
+	if noAlerting {
+		Config.lowAlertProviders = nil
+		Config.highAlertProviders = nil
+		log.Warn("Alerting is disabled due to -no-alert flag")
+	}
+}
+
+func craftProviders(providerConfigs []config.AlertProviderConfig, severity string) []AlertProvider {
+	providers := make([]AlertProvider, 0, len(providerConfigs))
+
+	for _, providerConfig := range providerConfigs {
+		provider, err := craftProvider(providerConfig)
 		if err != nil {
-			log.Fatal("Failed to craft low alert provider")
+			log.Fatalf("Failed to craft %s alert provider: %v", severity, err)
 		}
-	} else {
-		Config.lowAlertProvider = nil
-		log.Warn("Low alert provider is not configured")
+		providers = append(providers, provider)
 	}
 
-	if serverConfig.HighAlertProvider.Type() != config.EmptyProviderType {
-		providerConfig := serverConfig.HighAlertProvider
-		Config.highAlertProvider, err = craftProvider(providerConfig)
-	} else {
-		Config.highAlertProvider = nil
-		log.Warn("High alert provider is not configured")
+	if len(providers) == 0 {
+		log.Warnf("%s alert provider is not configured", strings.ToUpper(severity[:1])+severity[1:])
 	}
+	return providers
+}
 
-	if noAlerting {
-		Config.lowAlertProvider = nil
-		Config.highAlertProvider = nil
-		log.Warn("Alerting is disabled due to -no-alert flag")
-	}
+// namedProvider is a provider instance declared with its own name and settings
+type namedProvider struct {
+	AlertProvider
+	name string
+}
+
+// Name returns the name the instance was declared with
+func (provider namedProvider) Name() string {
+	return provider.name
 }
 
 func craftProvider(provider interface{}) (AlertProvider, error) {
 	if provider != nil {
 		switch provider.(type) {
+		case *config.NamedAlertProviderConfig:
+			named := provider.(*config.NamedAlertProviderConfig)
+			log.Tracef("Crafting %s provider instance", named.Name)
+			instance, err := craftProvider(named.AlertProviderConfig)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", named.Name, err)
+			}
+			return namedProvider{AlertProvider: instance, name: named.Name}, nil
 		case *config.PagerDutyConfig:
 			log.Trace("Crafting PagerDuty provider")
 			pagerDutyProvider := pagerduty.NewInstance(provider.(*config.PagerDutyConfig))
 			return pagerDutyProvider, nil
 		case *config.KeepHQConfig:
 			log.Trace("Crafting KeepHQ provider")
-			// keepHQProvider := keephqalert.NewInstance(provider.(*config.KeepHQConfig))
-			return nil, nil
+			keepHQProvider := keephq.NewInstance(provider.(*config.KeepHQConfig))
+			return keepHQProvider, nil
+		case *config.WebhookConfig:
+			log.Trace("Crafting webhook provider")
+			return webhook.NewInstance(provider.(*config.WebhookConfig))
+		case *config.SMTPConfig:
+			log.Trace("Crafting SMTP provider")
+			return smtp.NewInstance(provider.(*config.SMTPConfig))
 		default:
 			return nil, fmt.Errorf("Unknown provider type")
 		}
@@ -71,42 +105,58 @@ func craftProvider(provider interface{}) (AlertProvider, error) {
 	return nil, fmt.Errorf("Provider is nil")
 }
 
-func ServerAlert(category, component, severity string) {
-	log.Tracef("Alerting %s %s %s", category, component, severity)
+// ServerAlert sends the alert through the providers of its severity following their delivery policy
+// Panic alerts go through the high alert providers, other alerts are held back when the server isn't the cluster leader
+func ServerAlert(a *alert.Alert) *Delivery {
+	log.Tracef("Alerting %s %s %s", a.Category, a.Component, a.Severity)
+	if holdAlert(a) {
+		return &Delivery{Policy: standbyPolicy}
+	}
+
+	severity := a.Severity
+	providers, policy := Config.lowAlertProviders, Config.lowAlertPolicy
+	if severity == "high" || severity == "panic" {
+		severity = "high"
+		providers, policy = Config.highAlertProviders, Config.highAlertPolicy
+	} else if severity != "low" {
+		log.Errorf("Unknown alert severity %s. Can't send alert.", a.Severity)
+		return &Delivery{}
+	}
 
-	if severity == "low" {
-		if Config.lowAlertProvider != nil {
-			log.Infof("Sending alert to low alert provider: %s", Config.lowAlertProvider.Name())
-			err := Config.lowAlertProvider.Send(category, component, severity)
-			if err != nil {
-				log.Error("Failed to send low alert: ", err)
-			}
-		} else {
-			log.Warn("No low alert provider configured. Can't send alert.")
-		}
+	if len(providers) == 0 {
+		log.Warnf("No %s alert provider configured. Can't send alert.", severity)
+		return &Delivery{}
 	}
 
-	if severity == "high" {
-		if Config.highAlertProvider != nil {
-			log.Infof("Sending alert to high alert provider: %s", Config.highAlertProvider.Name())
-			err := Config.highAlertProvider.Send(category, component, severity)
-			if err != nil {
-				log.Error("Failed to send high alert: ", err)
-			}
-		} else {
-			log.Warn("No high alert provider configured. Can't send alert.")
-		}
+	delivery := policy.deliver(providers, func(provider AlertProvider) error {
+		log.Infof("Sending alert to %s alert provider: %s", severity, provider.Name())
+		return provider.Send(a)
+	})
+	delivery.report(a, severity, "alert")
+	return delivery
+}
+
+// ServerResolve resolves the incident of the alerted component on every provider that may have received it
+// a.Severity is the last severity sent: a high alert was escalated from a low one
+// so it is resolved on the providers of both severities
+func ServerResolve(a *alert.Alert) *Delivery {
+	log.Tracef("Resolving %s %s %s", a.Category, a.Component, a.Severity)
+	if holdResolve(a) {
+		return &Delivery{Policy: standbyPolicy}
 	}
 
-	if severity == "panic" {
-		if Config.highAlertProvider != nil {
-			log.Infof("Sending alert to high alert provider: %s", Config.highAlertProvider.Name())
-			err := Config.highAlertProvider.Send(category, component, severity)
-			if err != nil {
-				log.Error("Failed to send panic alert: ", err)
-			}
-		} else {
-			log.Warn("No high alert provider configured. Can't send alert.")
-		}
+	providers := Config.lowAlertProviders
+	if a.Severity == "high" {
+		providers = append(append([]AlertProvider{}, Config.lowAlertProviders...), Config.highAlertProviders...)
+	}
+	if len(providers) == 0 {
+		return &Delivery{}
 	}
+
+	delivery := resolvePolicy.deliver(providers, func(provider AlertProvider) error {
+		log.Infof("Resolving alert on alert provider: %s", provider.Name())
+		return provider.Resolve(a)
+	})
+	delivery.report(a, a.Severity, "resolution")
+	return delivery
 }
@@ -2,8 +2,11 @@ package alerting
 
 import (
 	"bytes"
+	"errors"
 	"testing"
 
+	"github.com/equals215/deepsentinel/alerting/alert"
+	"github.com/equals215/deepsentinel/config"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	easy "github.com/t-tomalak/logrus-easy-formatter"
@@ -16,50 +19,177 @@ func TestServerAlert(t *testing.T) {
 		LogFormat: "%msg%",
 	})
 
-	// Test case 1: severity is "low" and lowAlertProvider is configured
-	Config.lowAlertProvider = &MockAlertProvider{}
-	ServerAlert("category", "component", "low")
-	assert.Equal(t, "Sending alert to low alert provider: MockProvider", logOutput.String())
+	// Test case 1: severity is "low" and lowAlertProviders is configured
+	Config.lowAlertProviders = []AlertProvider{&MockAlertProvider{}}
+	ServerAlert(alert.New("category", "component", "low"))
+	assert.Equal(t, "Sending alert to low alert provider: MockProviderlow alert delivered by MockProvider", logOutput.String())
 	logOutput.Reset()
 
-	// Test case 2: severity is "low" but lowAlertProvider is not configured
-	Config.lowAlertProvider = nil
-	ServerAlert("category", "component", "low")
+	// Test case 2: severity is "low" but lowAlertProviders is not configured
+	Config.lowAlertProviders = nil
+	ServerAlert(alert.New("category", "component", "low"))
 	assert.Equal(t, "No low alert provider configured. Can't send alert.", logOutput.String())
 	logOutput.Reset()
 
-	// Test case 3: severity is "high" and highAlertProvider is configured
-	Config.highAlertProvider = &MockAlertProvider{}
-	ServerAlert("category", "component", "high")
-	assert.Equal(t, "Sending alert to high alert provider: MockProvider", logOutput.String())
+	// Test case 3: severity is "high" and highAlertProviders is configured
+	Config.highAlertProviders = []AlertProvider{&MockAlertProvider{}}
+	ServerAlert(alert.New("category", "component", "high"))
+	assert.Equal(t, "Sending alert to high alert provider: MockProviderhigh alert delivered by MockProvider", logOutput.String())
 	logOutput.Reset()
 
-	// Test case 4: severity is "high" but highAlertProvider is not configured
-	Config.highAlertProvider = nil
-	ServerAlert("category", "component", "high")
+	// Test case 4: severity is "high" but highAlertProviders is not configured
+	Config.highAlertProviders = nil
+	ServerAlert(alert.New("category", "component", "high"))
 	assert.Equal(t, "No high alert provider configured. Can't send alert.", logOutput.String())
 	logOutput.Reset()
 
-	// Test case 5: severity is "panic" and highAlertProvider is configured
-	Config.highAlertProvider = &MockAlertProvider{}
-	ServerAlert("category", "component", "panic")
-	assert.Equal(t, "Sending alert to high alert provider: MockProvider", logOutput.String())
+	// Test case 5: severity is "panic" and highAlertProviders is configured
+	Config.highAlertProviders = []AlertProvider{&MockAlertProvider{}}
+	ServerAlert(alert.New("category", "component", "panic"))
+	assert.Equal(t, "Sending alert to high alert provider: MockProviderhigh alert delivered by MockProvider", logOutput.String())
 	logOutput.Reset()
 
-	// Test case 6: severity is "panic" but highAlertProvider is not configured
-	Config.highAlertProvider = nil
-	ServerAlert("category", "component", "panic")
+	// Test case 6: severity is "panic" but highAlertProviders is not configured
+	Config.highAlertProviders = nil
+	ServerAlert(alert.New("category", "component", "panic"))
 	assert.Equal(t, "No high alert provider configured. Can't send alert.", logOutput.String())
 	logOutput.Reset()
 }
 
+func TestServerAlertPolicies(t *testing.T) {
+	log.SetOutput(&bytes.Buffer{})
+	defer func() {
+		Config = AlertingConfig{}
+	}()
+
+	failing := func() *MockAlertProvider { return &MockAlertProvider{err: errors.New("unreachable")} }
+
+	// Test case 1: "all" sends to every provider and fails if one of them fails
+	providers := []*MockAlertProvider{{}, failing(), {}}
+	Config.lowAlertProviders = []AlertProvider{providers[0], providers[1], providers[2]}
+	Config.lowAlertPolicy = newDeliveryPolicy("", 0)
+	delivery := ServerAlert(alert.New("machine", "machine1", "low"))
+	assert.False(t, delivery.Delivered)
+	assert.Len(t, delivery.Results, 3)
+	for _, provider := range providers {
+		assert.Equal(t, 1, provider.sent)
+	}
+	assert.False(t, Health().Healthy)
+	assert.Equal(t, "low alert for machine machine1 not delivered (policy all)", Health().LastError)
+
+	// Test case 2: "failover" stops at the first provider that succeeds
+	providers = []*MockAlertProvider{failing(), {}, {}}
+	Config.lowAlertProviders = []AlertProvider{providers[0], providers[1], providers[2]}
+	Config.lowAlertPolicy = newDeliveryPolicy("failover", 0)
+	delivery = ServerAlert(alert.New("machine", "machine1", "low"))
+	assert.True(t, delivery.Delivered)
+	assert.Len(t, delivery.Results, 2)
+	assert.Equal(t, []int{1, 1, 0}, []int{providers[0].sent, providers[1].sent, providers[2].sent})
+	assert.True(t, Health().Healthy)
+
+	// Test case 3: "quorum" defaults to a majority of the providers
+	Config.highAlertProviders = []AlertProvider{&MockAlertProvider{}, failing(), failing()}
+	Config.highAlertPolicy = newDeliveryPolicy("quorum", 0)
+	delivery = ServerAlert(alert.New("machine", "machine1", "high"))
+	assert.False(t, delivery.Delivered)
+
+	// Test case 4: "quorum" with an explicit quorum
+	Config.highAlertPolicy = newDeliveryPolicy("quorum", 1)
+	delivery = ServerAlert(alert.New("machine", "machine1", "high"))
+	assert.True(t, delivery.Delivered)
+
+	// Test case 5: deliveries are counted per provider, severity, kind and result
+	counts := make(map[string]uint64)
+	for _, count := range ProviderCounts() {
+		counts[count.Severity+" "+count.Kind+" "+count.Result] += count.Count
+	}
+	assert.GreaterOrEqual(t, counts["high alert sent"], uint64(2))
+	assert.GreaterOrEqual(t, counts["low alert failed"], uint64(2))
+
+	// Test case 6: "failover" fails when every provider fails
+	Config.lowAlertProviders = []AlertProvider{failing(), failing()}
+	Config.lowAlertPolicy = newDeliveryPolicy("failover", 0)
+	failedDeliveries := Health().FailedDeliveries
+	delivery = ServerAlert(alert.New("service", "machine1-nginx", "low"))
+	assert.False(t, delivery.Delivered)
+	assert.Equal(t, failedDeliveries+1, Health().FailedDeliveries)
+}
+
+func TestServerResolve(t *testing.T) {
+	var logOutput bytes.Buffer
+	log.SetOutput(&logOutput)
+	log.SetFormatter(&easy.Formatter{
+		LogFormat: "%msg%",
+	})
+
+	lowProvider := &MockAlertProvider{}
+	highProvider := &MockAlertProvider{}
+	Config.lowAlertProviders = []AlertProvider{lowProvider}
+	Config.highAlertProviders = []AlertProvider{highProvider}
+	defer func() {
+		Config = AlertingConfig{}
+	}()
+
+	// Test case 1: a low alert is only resolved on the low alert providers
+	ServerResolve(alert.New("category", "component", "low"))
+	assert.Equal(t, "Resolving alert on alert provider: MockProviderlow resolution delivered by MockProvider", logOutput.String())
+	assert.Equal(t, []string{"deepsentinel-category-component"}, lowProvider.resolved)
+	assert.Empty(t, highProvider.resolved)
+	logOutput.Reset()
+
+	// Test case 2: a high alert is resolved on both low and high alert providers
+	delivery := ServerResolve(alert.New("category", "component", "high"))
+	assert.True(t, delivery.Delivered)
+	assert.Len(t, delivery.Results, 2)
+	assert.Equal(t, []string{"deepsentinel-category-component", "deepsentinel-category-component"}, lowProvider.resolved)
+	assert.Equal(t, []string{"deepsentinel-category-component"}, highProvider.resolved)
+	logOutput.Reset()
+}
+
+func TestCraftNamedProviders(t *testing.T) {
+	log.SetOutput(&bytes.Buffer{})
+
+	// Test case 1: instances of the same type are crafted with their own settings and names
+	providers := craftProviders([]config.AlertProviderConfig{
+		&config.NamedAlertProviderConfig{Name: "ops", AlertProviderConfig: &config.WebhookConfig{URL: "https://ops.example.com"}},
+		&config.NamedAlertProviderConfig{Name: "oncall", AlertProviderConfig: &config.WebhookConfig{URL: "https://oncall.example.com"}},
+		&config.WebhookConfig{URL: "https://default.example.com"},
+	}, "low")
+	assert.Len(t, providers, 3)
+	assert.Equal(t, "ops", providers[0].Name())
+	assert.Equal(t, "oncall", providers[1].Name())
+	assert.Equal(t, "Webhook", providers[2].Name())
+
+	// Test case 2: the delivery outcome tells the instances apart
+	failing := namedProvider{AlertProvider: &MockAlertProvider{err: errors.New("down")}, name: "ops"}
+	working := namedProvider{AlertProvider: &MockAlertProvider{}, name: "oncall"}
+	delivery := newDeliveryPolicy("failover", 0).deliver([]AlertProvider{failing, working}, func(provider AlertProvider) error {
+		return provider.Send(alert.New("category", "component", "low"))
+	})
+	assert.True(t, delivery.Delivered)
+	assert.Equal(t, "ops", delivery.Results[0].Provider)
+	assert.Error(t, delivery.Results[0].Error)
+	assert.Equal(t, "oncall", delivery.Results[1].Provider)
+	assert.NoError(t, delivery.Results[1].Error)
+}
+
 // MockAlertProvider is a mock implementation of the AlertProvider interface
-type MockAlertProvider struct{}
+type MockAlertProvider struct {
+	err      error
+	sent     int
+	resolved []string
+}
 
 func (m *MockAlertProvider) Name() string {
 	return "MockProvider"
 }
 
-func (m *MockAlertProvider) Send(category, component, severity string) error {
-	return nil
+func (m *MockAlertProvider) Send(a *alert.Alert) error {
+	m.sent++
+	return m.err
+}
+
+func (m *MockAlertProvider) Resolve(a *alert.Alert) error {
+	m.resolved = append(m.resolved, a.DedupKey())
+	return m.err
 }
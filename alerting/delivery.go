@@ -0,0 +1,154 @@
+package alerting
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/equals215/deepsentinel/alerting/alert"
+	log "github.com/sirupsen/logrus"
+)
+
+// deliveryPolicy tells how an alert is delivered through the ordered providers of a severity
+// "all" sends to every provider and needs them all to succeed,
+// "failover" tries the providers in order until one succeeds,
+// "quorum" sends to every provider and needs quorum of them to succeed (a majority by default)
+type deliveryPolicy struct {
+	kind   string
+	quorum int
+}
+
+var resolvePolicy = deliveryPolicy{kind: "all"}
+
+func newDeliveryPolicy(kind string, quorum int) deliveryPolicy {
+	if kind == "" {
+		kind = "all"
+	}
+	return deliveryPolicy{
+		kind:   kind,
+		quorum: quorum,
+	}
+}
+
+// ProviderResult is the outcome of a delivery through one provider
+type ProviderResult struct {
+	Provider string
+	Error    error
+}
+
+// Delivery is the outcome of a delivery through the providers of a severity
+type Delivery struct {
+	Policy    string
+	Results   []ProviderResult
+	Delivered bool
+}
+
+// DeliveryHealth sums up the recent deliveries, it is unhealthy when the last delivery failed
+type DeliveryHealth struct {
+	Healthy          bool      `json:"healthy"`
+	FailedDeliveries int       `json:"failedDeliveries"`
+	LastFailure      time.Time `json:"lastFailure,omitempty"`
+	LastError        string    `json:"lastError,omitempty"`
+	LastSuccess      time.Time `json:"lastSuccess,omitempty"`
+}
+
+var health = struct {
+	sync.Mutex
+	DeliveryHealth
+}{
+	DeliveryHealth: DeliveryHealth{Healthy: true},
+}
+
+// Health returns the health of the alert deliveries
+func Health() DeliveryHealth {
+	health.Lock()
+	defer health.Unlock()
+	return health.DeliveryHealth
+}
+
+func (p deliveryPolicy) deliver(providers []AlertProvider, action func(AlertProvider) error) *Delivery {
+	delivery := &Delivery{Policy: p.kind}
+
+	if p.kind == "failover" {
+		for _, provider := range providers {
+			err := action(provider)
+			delivery.Results = append(delivery.Results, ProviderResult{Provider: provider.Name(), Error: err})
+			if err == nil {
+				break
+			}
+		}
+	} else {
+		var wg sync.WaitGroup
+		delivery.Results = make([]ProviderResult, len(providers))
+		for i, provider := range providers {
+			wg.Add(1)
+			go func(i int, provider AlertProvider) {
+				defer wg.Done()
+				delivery.Results[i] = ProviderResult{Provider: provider.Name(), Error: action(provider)}
+			}(i, provider)
+		}
+		wg.Wait()
+	}
+
+	successes := 0
+	for _, result := range delivery.Results {
+		if result.Error == nil {
+			successes++
+		}
+	}
+
+	switch p.kind {
+	case "failover":
+		delivery.Delivered = successes > 0
+	case "quorum":
+		delivery.Delivered = successes >= p.required(len(providers))
+	default:
+		delivery.Delivered = successes == len(providers)
+	}
+	return delivery
+}
+
+// required returns how many providers must succeed to reach the quorum
+func (p deliveryPolicy) required(count int) int {
+	if p.quorum <= 0 {
+		return count/2 + 1
+	}
+	if p.quorum > count {
+		return count
+	}
+	return p.quorum
+}
+
+// report logs and counts the outcome of each provider and records failed deliveries in the alerting health
+func (d *Delivery) report(a *alert.Alert, severity, kind string) {
+	for _, result := range d.Results {
+		countDelivery(result.Provider, severity, kind, result.Error)
+		if result.Error != nil {
+			log.WithFields(log.Fields{
+				"provider":  result.Provider,
+				"category":  a.Category,
+				"component": a.Component,
+			}).Errorf("Failed to send %s %s: %v", severity, kind, result.Error)
+		} else {
+			log.Infof("%s %s delivered by %s", severity, kind, result.Provider)
+		}
+	}
+
+	health.Lock()
+	defer health.Unlock()
+	if d.Delivered {
+		health.LastSuccess = time.Now()
+		health.Healthy = true
+		return
+	}
+
+	health.FailedDeliveries++
+	health.LastFailure = time.Now()
+	health.LastError = fmt.Sprintf("%s %s for %s %s not delivered (policy %s)", severity, kind, a.Category, a.Component, d.Policy)
+	health.Healthy = false
+	log.WithFields(log.Fields{
+		"category":  a.Category,
+		"component": a.Component,
+		"policy":    d.Policy,
+	}).Errorf("ALERT DELIVERY FAILED: %s", health.LastError)
+}
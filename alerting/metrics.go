@@ -0,0 +1,72 @@
+package alerting
+
+import (
+	"sort"
+	"sync"
+)
+
+// ProviderCount is the number of deliveries of one kind through a provider with the same outcome
+// Kind is "alert" or "resolution" and Result "sent" or "failed"
+type ProviderCount struct {
+	Provider string
+	Severity string
+	Kind     string
+	Result   string
+	Count    uint64
+}
+
+type providerCountKey struct {
+	provider string
+	severity string
+	kind     string
+	result   string
+}
+
+var providerCounts = struct {
+	sync.Mutex
+	counts map[providerCountKey]uint64
+}{
+	counts: make(map[providerCountKey]uint64),
+}
+
+func countDelivery(provider, severity, kind string, err error) {
+	result := "sent"
+	if err != nil {
+		result = "failed"
+	}
+
+	providerCounts.Lock()
+	defer providerCounts.Unlock()
+	providerCounts.counts[providerCountKey{provider, severity, kind, result}]++
+}
+
+// ProviderCounts returns the deliveries counted since the server started
+func ProviderCounts() []ProviderCount {
+	providerCounts.Lock()
+	counts := make([]ProviderCount, 0, len(providerCounts.counts))
+	for key, count := range providerCounts.counts {
+		counts = append(counts, ProviderCount{
+			Provider: key.provider,
+			Severity: key.severity,
+			Kind:     key.kind,
+			Result:   key.result,
+			Count:    count,
+		})
+	}
+	providerCounts.Unlock()
+
+	sort.Slice(counts, func(i, j int) bool {
+		a, b := counts[i], counts[j]
+		if a.Provider != b.Provider {
+			return a.Provider < b.Provider
+		}
+		if a.Severity != b.Severity {
+			return a.Severity < b.Severity
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Result < b.Result
+	})
+	return counts
+}
@@ -0,0 +1,150 @@
+package keephq
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/equals215/deepsentinel/alerting/alert"
+	"github.com/equals215/deepsentinel/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const eventPath = "/alerts/event"
+
+type KeepHQInstance struct {
+	config *config.KeepHQConfig
+	client *http.Client
+}
+
+// AlertPayload is the alert format expected by the Keep event API
+type AlertPayload struct {
+	Name         string            `json:"name"`
+	Status       string            `json:"status"`
+	Severity     string            `json:"severity"`
+	LastReceived string            `json:"lastReceived"`
+	Source       []string          `json:"source"`
+	Service      string            `json:"service"`
+	Message      string            `json:"message"`
+	Fingerprint  string            `json:"fingerprint"`
+	Labels       map[string]string `json:"labels"`
+}
+
+// NewInstance creates a new KeepHQ instance
+func NewInstance(config *config.KeepHQConfig) KeepHQInstance {
+	log.Info("Warming KeepHQ instance")
+
+	return KeepHQInstance{
+		config: config,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// Name returns the name of the instance
+func (instance KeepHQInstance) Name() string {
+	return "KeepHQ"
+}
+
+// Send sends a firing alert to Keep
+// Alerts about the same component share their fingerprint so Keep deduplicates them
+func (instance KeepHQInstance) Send(a *alert.Alert) error {
+	var message string
+
+	switch a.Category {
+	case "machine":
+		message = fmt.Sprintf("Deepsentinel - Machine %s alert level is %s", a.Component, a.Severity)
+	case "service":
+		message = fmt.Sprintf("Deepsentinel - Service %s alert level is %s%s", a.Component, a.Severity, a.ReasonSuffix())
+	case "group":
+		message = fmt.Sprintf("Deepsentinel - Group %s alert level is %s", a.Component, a.Severity)
+	case "checkin":
+		message = fmt.Sprintf("Deepsentinel - Check-in %s alert level is %s%s", a.Component, a.Severity, a.ReasonSuffix())
+	case "deepsentinel":
+		message = fmt.Sprintf("Deepsentinel - %s %s error catched", a.Component, a.Severity)
+	default:
+		message = fmt.Sprintf("Unknown component %s is %s", a.Component, a.Severity)
+	}
+
+	severity, err := keepSeverity(a.Severity)
+	if err != nil {
+		return err
+	}
+	return _sendKeepHQEvent(instance, craftPayload(a, "firing", severity, message))
+}
+
+// Resolve marks the alert of the component as resolved in Keep
+func (instance KeepHQInstance) Resolve(a *alert.Alert) error {
+	severity, err := keepSeverity(a.Severity)
+	if err != nil {
+		severity = "info"
+	}
+	message := fmt.Sprintf("Deepsentinel - %s %s is back to normal", a.Category, a.Component)
+	return _sendKeepHQEvent(instance, craftPayload(a, "resolved", severity, message))
+}
+
+func keepSeverity(severity string) (string, error) {
+	switch severity {
+	case "low":
+		return "warning", nil
+	case "high", "panic":
+		return "critical", nil
+	default:
+		return "", fmt.Errorf("unknown severity %s", severity)
+	}
+}
+
+func craftPayload(a *alert.Alert, status, severity, message string) *AlertPayload {
+	payload := &AlertPayload{
+		Name:         fmt.Sprintf("deepsentinel %s %s", a.Category, a.Component),
+		Status:       status,
+		Severity:     severity,
+		LastReceived: a.Timestamp.UTC().Format(time.RFC3339),
+		Source:       []string{"deepsentinel"},
+		Service:      a.Component,
+		Message:      message,
+		Fingerprint:  a.DedupKey(),
+		Labels: map[string]string{
+			"category":  a.Category,
+			"component": a.Component,
+			"severity":  a.Severity,
+		},
+	}
+	if a.Reason != "" {
+		payload.Labels["reason"] = a.Reason
+	}
+	return payload
+}
+
+func _sendKeepHQEvent(instance KeepHQInstance, payload *AlertPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal Keep alert: %v", err)
+	}
+
+	req, err := http.NewRequest("POST", instance.config.APIURL+eventPath, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create Keep request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("X-API-KEY", instance.config.APIKey)
+
+	resp, err := instance.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send event to Keep: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected response %d from Keep: %s", resp.StatusCode, string(respBody))
+	}
+
+	log.Infof("%s event sent to KeepHQ successfully", payload.Status)
+	return nil
+}
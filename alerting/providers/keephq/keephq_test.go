@@ -0,0 +1,96 @@
+package keephq
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/equals215/deepsentinel/alerting/alert"
+	"github.com/equals215/deepsentinel/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeepHQInstance(t *testing.T) {
+	received := make(chan *AlertPayload, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/alerts/event" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Header.Get("X-API-KEY") != "test-api-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		payload := &AlertPayload{}
+		if err := json.NewDecoder(r.Body).Decode(payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- payload
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	instance := NewInstance(&config.KeepHQConfig{
+		APIKey: "test-api-key",
+		APIURL: server.URL,
+	})
+	assert.Equal(t, "KeepHQ", instance.Name())
+
+	// Test case 1: a low alert is sent as a firing warning
+	err := instance.Send(alert.New("machine", "machine1", "low"))
+	assert.Nil(t, err)
+	low := <-received
+	assert.Equal(t, "firing", low.Status)
+	assert.Equal(t, "warning", low.Severity)
+	assert.Equal(t, "machine1", low.Service)
+	assert.Equal(t, "deepsentinel-machine-machine1", low.Fingerprint)
+
+	// Test case 2: the escalated high alert keeps the same fingerprint
+	err = instance.Send(alert.New("machine", "machine1", "high"))
+	assert.Nil(t, err)
+	high := <-received
+	assert.Equal(t, "critical", high.Severity)
+	assert.Equal(t, low.Fingerprint, high.Fingerprint)
+
+	// Test case 3: resolving sends a resolved event with the same fingerprint
+	err = instance.Resolve(alert.New("machine", "machine1", "high"))
+	assert.Nil(t, err)
+	resolved := <-received
+	assert.Equal(t, "resolved", resolved.Status)
+	assert.Equal(t, low.Fingerprint, resolved.Fingerprint)
+
+	// Test case 4: unknown severity is rejected without calling Keep
+	err = instance.Send(alert.New("machine", "machine1", "medium"))
+	assert.EqualError(t, err, "unknown severity medium")
+	assert.Len(t, received, 0)
+
+	// Test case 5: alerts raised by warn reports are labelled with their reason
+	err = instance.Send(alert.New("service", "machine1-nginx", "low").WithReason(alert.ReasonWarn))
+	assert.Nil(t, err)
+	warned := <-received
+	assert.Equal(t, "warn", warned.Labels["reason"])
+	assert.Equal(t, "Deepsentinel - Service machine1-nginx alert level is low (persistent warn)", warned.Message)
+
+	// Test case 6: group alerts name the group
+	err = instance.Send(alert.New("group", "web", "high"))
+	assert.Nil(t, err)
+	group := <-received
+	assert.Equal(t, "Deepsentinel - Group web alert level is high", group.Message)
+
+	// Test case 7: check-in alerts name the job and tell why it alerted
+	err = instance.Send(alert.New("checkin", "backup", "high").WithReason(alert.ReasonMissed))
+	assert.Nil(t, err)
+	checkin := <-received
+	assert.Equal(t, "Deepsentinel - Check-in backup alert level is high (missed check-in)", checkin.Message)
+
+	// Test case 8: Keep errors are returned
+	unauthorized := NewInstance(&config.KeepHQConfig{
+		APIKey: "wrong-api-key",
+		APIURL: server.URL,
+	})
+	err = unauthorized.Send(alert.New("service", "machine1-nginx", "low"))
+	assert.EqualError(t, err, "unexpected response 401 from Keep: ")
+}
@@ -9,8 +9,8 @@ import (
 	"time"
 
 	pagerdutysdk "github.com/PagerDuty/go-pagerduty"
+	"github.com/equals215/deepsentinel/alerting/alert"
 	"github.com/equals215/deepsentinel/config"
-	"github.com/equals215/deepsentinel/utils"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -49,44 +49,69 @@ func (instance PagerDutyInstance) Name() string {
 }
 
 // Send sends an alert to PagerDuty
-func (instance PagerDutyInstance) Send(category, component, severity string) error {
-	if category == "machine" {
-		summary := fmt.Sprintf("Deepsentinel - Machine %s alert level is %s", component, severity)
-		return _sendPagerDutyAlert(instance, summary, component, severity)
-	} else if category == "service" {
-		summary := fmt.Sprintf("Deepsentinel - Service %s alert level is %s", component, severity)
-		return _sendPagerDutyAlert(instance, summary, component, severity)
-	} else if category == "deepsentinel" {
-		summary := fmt.Sprintf("Deepsentinel - %s %s error catched", component, severity)
-		return _sendPagerDutyAlert(instance, summary, component, severity)
+// Alerts about the same component share their dedup key so a high alert escalates the incident opened by the low one
+func (instance PagerDutyInstance) Send(a *alert.Alert) error {
+	if a.Category == "machine" {
+		summary := fmt.Sprintf("Deepsentinel - Machine %s alert level is %s", a.Component, a.Severity)
+		return _sendPagerDutyAlert(instance, summary, a)
+	} else if a.Category == "service" {
+		summary := fmt.Sprintf("Deepsentinel - Service %s alert level is %s%s", a.Component, a.Severity, a.ReasonSuffix())
+		return _sendPagerDutyAlert(instance, summary, a)
+	} else if a.Category == "group" {
+		summary := fmt.Sprintf("Deepsentinel - Group %s alert level is %s", a.Component, a.Severity)
+		return _sendPagerDutyAlert(instance, summary, a)
+	} else if a.Category == "checkin" {
+		summary := fmt.Sprintf("Deepsentinel - Check-in %s alert level is %s%s", a.Component, a.Severity, a.ReasonSuffix())
+		return _sendPagerDutyAlert(instance, summary, a)
+	} else if a.Category == "deepsentinel" {
+		summary := fmt.Sprintf("Deepsentinel - %s %s error catched", a.Component, a.Severity)
+		return _sendPagerDutyAlert(instance, summary, a)
 	}
-	summary := fmt.Sprintf("Unknown component %s is %s", component, severity)
-	return _sendPagerDutyAlert(instance, summary, component, severity)
+	summary := fmt.Sprintf("Unknown component %s is %s", a.Component, a.Severity)
+	return _sendPagerDutyAlert(instance, summary, a)
 }
 
-func _sendPagerDutyAlert(instance PagerDutyInstance, summary, component, severity string) error {
-	ctx := context.Background()
+// Resolve resolves the PagerDuty incident opened for the component
+func (instance PagerDutyInstance) Resolve(a *alert.Alert) error {
+	event := &pagerdutysdk.V2Event{
+		RoutingKey: instance.config.IntegrationKey,
+		Action:     "resolve",
+		DedupKey:   a.DedupKey(),
+	}
+	return _managePagerDutyEvent(instance, event)
+}
 
-	if severity == "low" {
+func _sendPagerDutyAlert(instance PagerDutyInstance, summary string, a *alert.Alert) error {
+	var severity string
+
+	if a.Severity == "low" {
 		severity = "warning"
-	} else if severity == "high" {
+	} else if a.Severity == "high" || a.Severity == "panic" {
 		severity = "critical"
 	} else {
-		return fmt.Errorf("unknown severity %s", severity)
+		return fmt.Errorf("unknown severity %s", a.Severity)
 	}
 
 	// Construct the event details
 	event := &pagerdutysdk.V2Event{
 		RoutingKey: instance.config.IntegrationKey,
 		Action:     "trigger",
-		DedupKey:   utils.RandStringBytesMaskImprSrcUnsafe(12),
+		DedupKey:   a.DedupKey(),
 		Payload: &pagerdutysdk.V2Payload{
 			Summary:   summary,
-			Source:    component,
+			Source:    a.Component,
 			Severity:  severity,
-			Timestamp: time.Now().Format(time.RFC3339),
+			Timestamp: a.Timestamp.Format(time.RFC3339),
 		},
 	}
+	if a.Reason != "" {
+		event.Payload.Details = map[string]string{"reason": a.Reason}
+	}
+	return _managePagerDutyEvent(instance, event)
+}
+
+func _managePagerDutyEvent(instance PagerDutyInstance, event *pagerdutysdk.V2Event) error {
+	ctx := context.Background()
 
 	// Send the event
 	response, err := instance.client.ManageEventWithContext(ctx, event)
@@ -105,7 +130,7 @@ func _sendPagerDutyAlert(instance PagerDutyInstance, summary, component, severit
 		return outErr
 	}
 
-	log.Infof("Event sent to PagerDuty successfully: %s", response.Status)
+	log.Infof("%s event sent to PagerDuty successfully: %s", event.Action, response.Status)
 	return nil
 }
 
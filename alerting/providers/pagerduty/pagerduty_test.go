@@ -0,0 +1,64 @@
+package pagerduty
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	pagerdutysdk "github.com/PagerDuty/go-pagerduty"
+	"github.com/equals215/deepsentinel/alerting/alert"
+	"github.com/equals215/deepsentinel/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPagerDutyInstance(t *testing.T) {
+	received := make(chan *pagerdutysdk.V2Event, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := &pagerdutysdk.V2Event{}
+		if err := json.NewDecoder(r.Body).Decode(event); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- event
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"status":"success","message":"Event processed"}`))
+	}))
+	defer server.Close()
+
+	// NewInstance pings the PagerDuty API, the instance is built directly to send the events to the test server
+	instance := PagerDutyInstance{
+		config: &config.PagerDutyConfig{IntegrationKey: "test-integration-key"},
+		client: pagerdutysdk.NewClient("test-api-key", pagerdutysdk.WithV2EventsAPIEndpoint(server.URL)),
+	}
+
+	// Test case 1: a machine alert triggers an incident keyed on the component
+	err := instance.Send(alert.New("machine", "machine1", "low"))
+	assert.Nil(t, err)
+	event := <-received
+	assert.Equal(t, "trigger", event.Action)
+	assert.Equal(t, "deepsentinel-machine-machine1", event.DedupKey)
+	assert.Equal(t, "Deepsentinel - Machine machine1 alert level is low", event.Payload.Summary)
+	assert.Equal(t, "warning", event.Payload.Severity)
+
+	// Test case 2: group alerts name the group
+	err = instance.Send(alert.New("group", "web", "high"))
+	assert.Nil(t, err)
+	event = <-received
+	assert.Equal(t, "Deepsentinel - Group web alert level is high", event.Payload.Summary)
+	assert.Equal(t, "critical", event.Payload.Severity)
+
+	// Test case 3: check-in alerts name the job and tell why it alerted
+	err = instance.Send(alert.New("checkin", "backup", "high").WithReason(alert.ReasonTimeout))
+	assert.Nil(t, err)
+	event = <-received
+	assert.Equal(t, "Deepsentinel - Check-in backup alert level is high (run too long)", event.Payload.Summary)
+
+	// Test case 4: resolving sends a resolve event with the same dedup key
+	err = instance.Resolve(alert.New("group", "web", "high"))
+	assert.Nil(t, err)
+	event = <-received
+	assert.Equal(t, "resolve", event.Action)
+	assert.Equal(t, "deepsentinel-group-web", event.DedupKey)
+}
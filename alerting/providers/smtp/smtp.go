@@ -0,0 +1,225 @@
+package smtp
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/equals215/deepsentinel/alerting/alert"
+	"github.com/equals215/deepsentinel/config"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultLowSubject  = "[DeepSentinel] {{.Category}} {{.Component}} alert level is low"
+	defaultHighSubject = "[DeepSentinel] URGENT {{.Category}} {{.Component}} alert level is {{.Severity}}"
+	// sendTimeout bounds a whole mail, from the connection to the QUIT
+	sendTimeout = 30 * time.Second
+)
+
+type SMTPInstance struct {
+	config      *config.SMTPConfig
+	tlsConfig   *tls.Config
+	lowSubject  *template.Template
+	highSubject *template.Template
+}
+
+// TemplateData is the data available to the subject templates
+type TemplateData struct {
+	Category        string
+	Component       string
+	Severity        string
+	Reason          string
+	Timestamp       time.Time
+	LastNormal      time.Time
+	SinceLastNormal time.Duration
+}
+
+// NewInstance creates a new SMTP instance
+func NewInstance(config *config.SMTPConfig) (SMTPInstance, error) {
+	log.Info("Warming SMTP instance")
+
+	lowSubject, err := parseSubject("low", config.LowSubject, defaultLowSubject)
+	if err != nil {
+		return SMTPInstance{}, err
+	}
+	highSubject, err := parseSubject("high", config.HighSubject, defaultHighSubject)
+	if err != nil {
+		return SMTPInstance{}, err
+	}
+
+	return SMTPInstance{
+		config:      config,
+		tlsConfig:   &tls.Config{ServerName: config.Host},
+		lowSubject:  lowSubject,
+		highSubject: highSubject,
+	}, nil
+}
+
+func parseSubject(name, subject, fallback string) (*template.Template, error) {
+	if subject == "" {
+		subject = fallback
+	}
+	tmpl, err := template.New(name).Parse(subject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smtp %s subject template: %v", name, err)
+	}
+	return tmpl, nil
+}
+
+// Name returns the name of the instance
+func (instance SMTPInstance) Name() string {
+	return "SMTP"
+}
+
+// Send mails the alert to every recipient
+func (instance SMTPInstance) Send(a *alert.Alert) error {
+	subject, err := instance.subject(a)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("DeepSentinel raised a %s alert.\r\n\r\n", a.Severity) +
+		fmt.Sprintf("Category: %s\r\nComponent: %s\r\nSeverity: %s\r\nTime: %s\r\n",
+			a.Category, a.Component, a.Severity, a.Timestamp.Format(time.RFC1123Z))
+	if a.Reason != "" {
+		body += fmt.Sprintf("Reason: %s reports\r\n", a.Reason)
+	}
+	if !a.LastNormal.IsZero() {
+		body += fmt.Sprintf("Last normal: %s (%s ago)\r\n", a.LastNormal.Format(time.RFC1123Z), a.SinceLastNormal().Round(time.Second))
+	}
+	return _sendMail(instance, subject, body)
+}
+
+// Resolve mails the resolution of the alert to every recipient
+func (instance SMTPInstance) Resolve(a *alert.Alert) error {
+	subject, err := instance.subject(a)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("The %s alert about %s %s is resolved.\r\n\r\nTime: %s\r\n",
+		a.Severity, a.Category, a.Component, a.Timestamp.Format(time.RFC1123Z))
+	return _sendMail(instance, "[RESOLVED] "+subject, body)
+}
+
+func (instance SMTPInstance) subject(a *alert.Alert) (string, error) {
+	var subject bytes.Buffer
+
+	tmpl := instance.lowSubject
+	if a.Severity != "low" {
+		tmpl = instance.highSubject
+	}
+
+	err := tmpl.Execute(&subject, &TemplateData{
+		Category:        a.Category,
+		Component:       a.Component,
+		Severity:        a.Severity,
+		Reason:          a.Reason,
+		Timestamp:       a.Timestamp,
+		LastNormal:      a.LastNormal,
+		SinceLastNormal: a.SinceLastNormal().Round(time.Second),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to render smtp subject: %v", err)
+	}
+	// Header injection safety: a subject must stay on a single line
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(subject.String()), nil
+}
+
+func _sendMail(instance SMTPInstance, subject, body string) error {
+	client, err := instance.dial()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if instance.config.Username != "" {
+		auth := smtp.PlainAuth("", instance.config.Username, instance.config.Password, instance.config.Host)
+		if err := client.Auth(auth); err != nil {
+			return fmt.Errorf("smtp authentication failed: %v", err)
+		}
+	}
+
+	if err := client.Mail(instance.config.From); err != nil {
+		return fmt.Errorf("smtp MAIL FROM failed: %v", err)
+	}
+	for _, to := range instance.config.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("smtp RCPT TO %s failed: %v", to, err)
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("smtp DATA failed: %v", err)
+	}
+	if _, err := writer.Write(instance.message(subject, body)); err != nil {
+		return fmt.Errorf("failed to write smtp message: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("smtp message rejected: %v", err)
+	}
+
+	log.Infof("Alert mailed to %d recipients successfully", len(instance.config.To))
+	return client.Quit()
+}
+
+// dial connects to the server and secures the connection following the configured TLS mode
+// "tls" is implicit TLS (usually port 465), "starttls" upgrades a plain connection (usually port 587)
+// The connection and the whole session share a single deadline
+func (instance SMTPInstance) dial() (*smtp.Client, error) {
+	var conn net.Conn
+	var err error
+
+	deadline := time.Now().Add(sendTimeout)
+	address := net.JoinHostPort(instance.config.Host, strconv.Itoa(instance.config.Port))
+	dialer := &net.Dialer{Deadline: deadline}
+	if instance.config.TLS == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, instance.tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to smtp server: %v", err)
+	}
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, instance.config.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to greet smtp server: %v", err)
+	}
+
+	if instance.config.TLS == "starttls" {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, fmt.Errorf("smtp server doesn't support STARTTLS")
+		}
+		if err := client.StartTLS(instance.tlsConfig); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("smtp STARTTLS failed: %v", err)
+		}
+	}
+	return client, nil
+}
+
+func (instance SMTPInstance) message(subject, body string) []byte {
+	var message bytes.Buffer
+
+	fmt.Fprintf(&message, "From: %s\r\n", instance.config.From)
+	fmt.Fprintf(&message, "To: %s\r\n", strings.Join(instance.config.To, ", "))
+	fmt.Fprintf(&message, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	message.WriteString("\r\n")
+	message.WriteString(body)
+	return message.Bytes()
+}
@@ -0,0 +1,261 @@
+package smtp
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/equals215/deepsentinel/alerting/alert"
+	"github.com/equals215/deepsentinel/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// mail is a message received by the fake SMTP server
+type mail struct {
+	auth string
+	from string
+	to   []string
+	data string
+	tls  bool
+}
+
+// fakeSMTPServer is a minimal in-process SMTP server
+// It offers STARTTLS when startTLS is set and speaks implicit TLS when implicitTLS is set
+type fakeSMTPServer struct {
+	listener    net.Listener
+	certificate tls.Certificate
+	startTLS    bool
+	implicitTLS bool
+	mails       chan *mail
+}
+
+func newFakeSMTPServer(t *testing.T, startTLS, implicitTLS bool) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+
+	server := &fakeSMTPServer{
+		listener:    listener,
+		certificate: selfSignedCertificate(t),
+		startTLS:    startTLS,
+		implicitTLS: implicitTLS,
+		mails:       make(chan *mail, 10),
+	}
+	go server.serve()
+	t.Cleanup(func() { listener.Close() })
+	return server
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	current := &mail{}
+
+	if s.implicitTLS {
+		conn = tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{s.certificate}})
+		current.tls = true
+	}
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 fake ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch command {
+		case "EHLO", "HELO":
+			if s.startTLS && !current.tls {
+				reply("250-fake")
+				reply("250-STARTTLS")
+			} else {
+				reply("250-fake")
+			}
+			reply("250 AUTH PLAIN")
+		case "STARTTLS":
+			reply("220 ready to start TLS")
+			conn = tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{s.certificate}})
+			reader = bufio.NewReader(conn)
+			current.tls = true
+		case "AUTH":
+			credentials, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "AUTH PLAIN "))
+			current.auth = string(credentials)
+			reply("235 authenticated")
+		case "MAIL":
+			current.from = strings.TrimSuffix(strings.TrimPrefix(line, "MAIL FROM:<"), ">")
+			reply("250 ok")
+		case "RCPT":
+			current.to = append(current.to, strings.TrimSuffix(strings.TrimPrefix(line, "RCPT TO:<"), ">"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(dataLine)
+			}
+			current.data = data.String()
+			s.mails <- current
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func selfSignedCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func newTestInstance(t *testing.T, server *fakeSMTPServer, smtpConfig *config.SMTPConfig) SMTPInstance {
+	smtpConfig.Host = "127.0.0.1"
+	smtpConfig.Port = server.port()
+	instance, err := NewInstance(smtpConfig)
+	assert.Nil(t, err)
+
+	// Trust the fake server certificate
+	roots := x509.NewCertPool()
+	certificate, err := x509.ParseCertificate(server.certificate.Certificate[0])
+	assert.Nil(t, err)
+	roots.AddCert(certificate)
+	instance.tlsConfig.RootCAs = roots
+	return instance
+}
+
+func TestSMTPInstance(t *testing.T) {
+	server := newFakeSMTPServer(t, false, false)
+
+	// Test case 1: low alert with authentication, multiple recipients and a custom subject
+	instance := newTestInstance(t, server, &config.SMTPConfig{
+		Username:   "user",
+		Password:   "password",
+		From:       "deepsentinel@example.com",
+		To:         []string{"oncall@example.com", "sre@example.com"},
+		LowSubject: "LOW {{.Component}}",
+	})
+	assert.Equal(t, "SMTP", instance.Name())
+
+	err := instance.Send(alert.New("machine", "machine1", "low").WithLastNormal(time.Now().Add(-time.Minute)))
+	assert.Nil(t, err)
+	received := <-server.mails
+	assert.Equal(t, "\x00user\x00password", received.auth)
+	assert.Equal(t, "deepsentinel@example.com", received.from)
+	assert.Equal(t, []string{"oncall@example.com", "sre@example.com"}, received.to)
+	assert.Contains(t, received.data, "Subject: LOW machine1\r\n")
+	assert.Contains(t, received.data, "To: oncall@example.com, sre@example.com\r\n")
+	assert.Contains(t, received.data, "Component: machine1\r\n")
+	assert.Contains(t, received.data, "(1m0s ago)")
+	assert.False(t, received.tls)
+
+	// Test case 2: high alert uses the default high subject
+	err = instance.Send(alert.New("service", "machine1-nginx", "high"))
+	assert.Nil(t, err)
+	received = <-server.mails
+	assert.Contains(t, received.data, "Subject: [DeepSentinel] URGENT service machine1-nginx alert level is high\r\n")
+
+	// Test case 3: resolution is prefixed
+	err = instance.Resolve(alert.New("machine", "machine1", "low"))
+	assert.Nil(t, err)
+	received = <-server.mails
+	assert.Contains(t, received.data, "Subject: [RESOLVED] LOW machine1\r\n")
+
+	// Test case 4: STARTTLS is required but not offered
+	instance = newTestInstance(t, server, &config.SMTPConfig{
+		From: "deepsentinel@example.com",
+		To:   []string{"oncall@example.com"},
+		TLS:  "starttls",
+	})
+	err = instance.Send(alert.New("machine", "machine1", "low"))
+	assert.EqualError(t, err, "smtp server doesn't support STARTTLS")
+
+	// Test case 5: invalid subject template
+	_, err = NewInstance(&config.SMTPConfig{HighSubject: "{{.Component"})
+	assert.Error(t, err)
+}
+
+func TestSMTPInstanceTLS(t *testing.T) {
+	// Test case 1: STARTTLS upgrades the connection
+	server := newFakeSMTPServer(t, true, false)
+	instance := newTestInstance(t, server, &config.SMTPConfig{
+		Username: "user",
+		Password: "password",
+		From:     "deepsentinel@example.com",
+		To:       []string{"oncall@example.com"},
+		TLS:      "starttls",
+	})
+	err := instance.Send(alert.New("machine", "machine1", "high"))
+	assert.Nil(t, err)
+	received := <-server.mails
+	assert.True(t, received.tls)
+	assert.Equal(t, "\x00user\x00password", received.auth)
+
+	// Test case 2: implicit TLS
+	server = newFakeSMTPServer(t, false, true)
+	instance = newTestInstance(t, server, &config.SMTPConfig{
+		From: "deepsentinel@example.com",
+		To:   []string{"oncall@example.com"},
+		TLS:  "tls",
+	})
+	err = instance.Send(alert.New("machine", "machine1", "high"))
+	assert.Nil(t, err)
+	received = <-server.mails
+	assert.True(t, received.tls)
+
+	// Test case 3: the server certificate must be trusted
+	instance.tlsConfig.RootCAs = x509.NewCertPool()
+	err = instance.Send(alert.New("machine", "machine1", "high"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to connect to smtp server")
+}
@@ -0,0 +1,189 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/equals215/deepsentinel/alerting/alert"
+	"github.com/equals215/deepsentinel/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultTemplate renders the alert as a flat JSON object
+const DefaultTemplate = `{"status":{{json .Status}},"category":{{json .Category}},"component":{{json .Component}},` +
+	`"severity":{{json .Severity}},"reason":{{json .Reason}},"dedupKey":{{json .DedupKey}},"timestamp":{{json .Timestamp}},` +
+	`"lastNormal":{{json .LastNormal}},"sinceLastNormal":{{json .SinceLastNormal.String}}}`
+
+const (
+	defaultMethod       = "POST"
+	defaultHMACHeader   = "X-DeepSentinel-Signature"
+	defaultRetryBackoff = time.Second
+)
+
+type WebhookInstance struct {
+	config       *config.WebhookConfig
+	client       *http.Client
+	template     *template.Template
+	retryBackoff time.Duration
+}
+
+// TemplateData is the data available to the webhook body template
+// Reason is "fail" or "warn" for service alerts, empty otherwise
+type TemplateData struct {
+	// Status is either "trigger" or "resolve"
+	Status          string
+	Category        string
+	Component       string
+	Severity        string
+	Reason          string
+	DedupKey        string
+	Timestamp       time.Time
+	LastNormal      time.Time
+	SinceLastNormal time.Duration
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+}
+
+// NewInstance creates a new webhook instance
+func NewInstance(config *config.WebhookConfig) (WebhookInstance, error) {
+	log.Info("Warming webhook instance")
+
+	body := config.Template
+	if body == "" {
+		body = DefaultTemplate
+	}
+	tmpl, err := template.New("webhook").Funcs(templateFuncs).Parse(body)
+	if err != nil {
+		return WebhookInstance{}, fmt.Errorf("invalid webhook template: %v", err)
+	}
+
+	retryBackoff := defaultRetryBackoff
+	if config.RetryBackoff != "" {
+		retryBackoff, err = time.ParseDuration(config.RetryBackoff)
+		if err != nil {
+			return WebhookInstance{}, fmt.Errorf("invalid webhook retry backoff: %v", err)
+		}
+	}
+
+	return WebhookInstance{
+		config:       config,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		template:     tmpl,
+		retryBackoff: retryBackoff,
+	}, nil
+}
+
+// Name returns the name of the instance
+func (instance WebhookInstance) Name() string {
+	return "Webhook"
+}
+
+// Send renders the template for the triggered alert and sends it to the webhook
+func (instance WebhookInstance) Send(a *alert.Alert) error {
+	return _sendWebhook(instance, "trigger", a)
+}
+
+// Resolve renders the template for the resolved alert and sends it to the webhook
+func (instance WebhookInstance) Resolve(a *alert.Alert) error {
+	return _sendWebhook(instance, "resolve", a)
+}
+
+func _sendWebhook(instance WebhookInstance, status string, a *alert.Alert) error {
+	var body bytes.Buffer
+
+	err := instance.template.Execute(&body, &TemplateData{
+		Status:          status,
+		Category:        a.Category,
+		Component:       a.Component,
+		Severity:        a.Severity,
+		Reason:          a.Reason,
+		DedupKey:        a.DedupKey(),
+		Timestamp:       a.Timestamp,
+		LastNormal:      a.LastNormal,
+		SinceLastNormal: a.SinceLastNormal().Round(time.Second),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to render webhook template: %v", err)
+	}
+
+	var lastErr error
+	backoff := instance.retryBackoff
+	for attempt := 0; attempt <= instance.config.Retries; attempt++ {
+		if attempt > 0 {
+			log.Warnf("Webhook attempt %d failed: %v. Retrying in %s", attempt, lastErr, backoff)
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+
+		retry, err := instance.post(body.Bytes())
+		if err == nil {
+			log.Infof("%s event sent to webhook successfully", status)
+			return nil
+		}
+		lastErr = err
+		if !retry {
+			break
+		}
+	}
+	return lastErr
+}
+
+// post sends the body once and tells if a failure is worth retrying
+func (instance WebhookInstance) post(body []byte) (bool, error) {
+	method := instance.config.Method
+	if method == "" {
+		method = defaultMethod
+	}
+
+	req, err := http.NewRequest(method, instance.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("failed to create webhook request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range instance.config.Headers {
+		req.Header.Set(key, value)
+	}
+	if instance.config.HMACSecret != "" {
+		header := instance.config.HMACHeader
+		if header == "" {
+			header = defaultHMACHeader
+		}
+		req.Header.Set(header, "sha256="+Sign(instance.config.HMACSecret, body))
+	}
+
+	resp, err := instance.client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("failed to send webhook: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("unexpected response %d from webhook: %s", resp.StatusCode, string(respBody))
+	}
+	return false, nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the body so receivers can authenticate it
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,117 @@
+package webhook
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/equals215/deepsentinel/alerting/alert"
+	"github.com/equals215/deepsentinel/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type request struct {
+	method string
+	header http.Header
+	body   []byte
+}
+
+func TestWebhookInstance(t *testing.T) {
+	received := make(chan *request, 10)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- &request{method: r.Method, header: r.Header, body: body}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	// Test case 1: default template with HMAC signature and custom headers
+	instance, err := NewInstance(&config.WebhookConfig{
+		URL:        server.URL,
+		Headers:    map[string]string{"X-Team": "sre"},
+		HMACSecret: "secret",
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "Webhook", instance.Name())
+
+	a := alert.New("machine", "machine1", "low").WithLastNormal(time.Now().Add(-90 * time.Second))
+	assert.Nil(t, instance.Send(a))
+	req := <-received
+	assert.Equal(t, "POST", req.method)
+	assert.Equal(t, "sre", req.header.Get("X-Team"))
+	assert.Equal(t, "sha256="+Sign("secret", req.body), req.header.Get("X-DeepSentinel-Signature"))
+
+	var body map[string]any
+	assert.Nil(t, json.Unmarshal(req.body, &body), "Default template must render valid JSON")
+	assert.Equal(t, "trigger", body["status"])
+	assert.Equal(t, "machine", body["category"])
+	assert.Equal(t, "machine1", body["component"])
+	assert.Equal(t, "low", body["severity"])
+	assert.Equal(t, "deepsentinel-machine-machine1", body["dedupKey"])
+	assert.Equal(t, "1m30s", body["sinceLastNormal"])
+
+	// Test case 2: custom template rendered for a resolution
+	instance, err = NewInstance(&config.WebhookConfig{
+		URL:      server.URL,
+		Method:   "PUT",
+		Template: `{"text":{{printf "%s %s is %s (%s)" .Category .Component .Severity .Status | json}}}`,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, instance.Resolve(alert.New("service", "machine1-nginx", "high")))
+	req = <-received
+	assert.Equal(t, "PUT", req.method)
+	assert.Equal(t, `{"text":"service machine1-nginx is high (resolve)"}`, string(req.body))
+	assert.Empty(t, req.header.Get("X-DeepSentinel-Signature"))
+
+	// Test case 3: invalid template and backoff are rejected
+	_, err = NewInstance(&config.WebhookConfig{URL: server.URL, Template: "{{.Category"})
+	assert.Error(t, err)
+	_, err = NewInstance(&config.WebhookConfig{URL: server.URL, RetryBackoff: "soon"})
+	assert.Error(t, err)
+}
+
+func TestWebhookRetries(t *testing.T) {
+	var calls atomic.Int32
+	var status atomic.Int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(int(status.Load()))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	instance, err := NewInstance(&config.WebhookConfig{
+		URL:          server.URL,
+		Retries:      2,
+		RetryBackoff: "1ms",
+	})
+	assert.Nil(t, err)
+
+	// Test case 1: server errors are retried until success
+	status.Store(http.StatusBadGateway)
+	assert.Nil(t, instance.Send(alert.New("machine", "machine1", "high")))
+	assert.Equal(t, int32(3), calls.Load())
+
+	// Test case 2: client errors are not retried
+	calls.Store(0)
+	status.Store(http.StatusBadRequest)
+	err = instance.Send(alert.New("machine", "machine1", "high"))
+	assert.EqualError(t, err, "unexpected response 400 from webhook: ")
+	assert.Equal(t, int32(1), calls.Load())
+
+	// Test case 3: retries are exhausted
+	instance.config.Retries = 1
+	calls.Store(0)
+	status.Store(http.StatusServiceUnavailable)
+	err = instance.Send(alert.New("machine", "machine1", "high"))
+	assert.EqualError(t, err, "unexpected response 503 from webhook: ")
+	assert.Equal(t, int32(2), calls.Load())
+}
@@ -0,0 +1,81 @@
+package alerting
+
+import (
+	"sync"
+	"time"
+
+	"github.com/equals215/deepsentinel/alerting/alert"
+	log "github.com/sirupsen/logrus"
+)
+
+// standbyPolicy is the policy reported for the deliveries held back by a standby server
+const standbyPolicy = "standby"
+
+// standby holds the alerts a server doesn't send because it isn't the cluster leader
+// The latest alert of every open incident is kept so a new leader can send those
+// the previous leader may have missed
+var standby = struct {
+	sync.Mutex
+	leader func() bool
+	held   map[string]*alert.Alert
+}{
+	leader: func() bool { return true },
+	held:   make(map[string]*alert.Alert),
+}
+
+// SetLeaderCheck makes the deliveries depend on leader, only a leader sends alerts and resolutions
+// Panic alerts about the server itself are always sent
+func SetLeaderCheck(leader func() bool) {
+	standby.Lock()
+	defer standby.Unlock()
+	standby.leader = leader
+}
+
+// holdAlert returns true when a is held back because the server isn't the leader
+func holdAlert(a *alert.Alert) bool {
+	standby.Lock()
+	defer standby.Unlock()
+
+	if a.Severity == "panic" || standby.leader() {
+		return false
+	}
+	log.WithFields(log.Fields{
+		"category":  a.Category,
+		"component": a.Component,
+		"severity":  a.Severity,
+	}).Debug("Not the cluster leader, alert held back")
+	standby.held[a.DedupKey()] = a
+	return true
+}
+
+// holdResolve returns true when the resolution of a is held back because the server isn't the leader
+func holdResolve(a *alert.Alert) bool {
+	standby.Lock()
+	defer standby.Unlock()
+
+	delete(standby.held, a.DedupKey())
+	return !standby.leader()
+}
+
+// TakeOver sends the alerts held back since the given time then forgets the held alerts
+// It is called when the server becomes the leader: the previous leader may have died before sending them
+func TakeOver(since time.Time) {
+	standby.Lock()
+	pending := make([]*alert.Alert, 0)
+	for key, a := range standby.held {
+		if a.Timestamp.After(since) {
+			pending = append(pending, a)
+		}
+		delete(standby.held, key)
+	}
+	standby.Unlock()
+
+	for _, a := range pending {
+		log.WithFields(log.Fields{
+			"category":  a.Category,
+			"component": a.Component,
+			"severity":  a.Severity,
+		}).Warn("Became the cluster leader, sending alert held back")
+		ServerAlert(a)
+	}
+}
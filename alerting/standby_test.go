@@ -0,0 +1,50 @@
+package alerting
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/equals215/deepsentinel/alerting/alert"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStandby(t *testing.T) {
+	log.SetOutput(&bytes.Buffer{})
+	provider := &MockAlertProvider{}
+	Config.lowAlertProviders = []AlertProvider{provider}
+	Config.highAlertProviders = []AlertProvider{provider}
+	leader := false
+	SetLeaderCheck(func() bool { return leader })
+	defer func() {
+		Config = AlertingConfig{}
+		SetLeaderCheck(func() bool { return true })
+	}()
+
+	// Test case 1: a standby server holds alerts and resolutions back
+	delivery := ServerAlert(alert.New("service", "machine1-nginx", "low"))
+	assert.Equal(t, "standby", delivery.Policy)
+	assert.False(t, delivery.Delivered)
+	ServerAlert(alert.New("service", "machine1-db", "low"))
+	delivery = ServerResolve(alert.New("service", "machine1-db", "low"))
+	assert.Equal(t, "standby", delivery.Policy)
+	assert.Equal(t, 0, provider.sent)
+	assert.Empty(t, provider.resolved)
+
+	// Test case 2: panic alerts are always sent
+	ServerAlert(alert.New("deepsentinel", "server", "panic"))
+	assert.Equal(t, 1, provider.sent)
+
+	// Test case 3: taking over sends the recent alerts still open
+	old := alert.New("service", "machine1-old", "low")
+	old.Timestamp = time.Now().Add(-time.Hour)
+	ServerAlert(old)
+	leader = true
+	TakeOver(time.Now().Add(-time.Minute))
+	assert.Equal(t, 2, provider.sent)
+
+	// Test case 4: held alerts are forgotten once taken over
+	TakeOver(time.Time{})
+	assert.Equal(t, 2, provider.sent)
+}
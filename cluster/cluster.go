@@ -0,0 +1,427 @@
+// Package cluster replicates the probes between DeepSentinel servers and elects the one sending alerts.
+package cluster
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/equals215/deepsentinel/alerting"
+	"github.com/equals215/deepsentinel/alerting/alert"
+	"github.com/equals215/deepsentinel/config"
+	"github.com/equals215/deepsentinel/store"
+	"github.com/equals215/deepsentinel/tokens"
+	log "github.com/sirupsen/logrus"
+)
+
+// Replicated message kinds
+const (
+	KindReport       = "report"
+	KindDelete       = "delete"
+	KindSilence      = "silence"
+	KindClearSilence = "clearSilence"
+	KindCheckin      = "checkin"
+	KindApprove      = "approve"
+	KindReject       = "reject"
+	KindToken        = "token"
+	KindRevokeToken  = "revokeToken"
+)
+
+// queueSize is the number of messages waiting to be replicated to a peer before new ones are dropped
+const queueSize = 1000
+
+// Message is an operation received by a server and replicated to its peers
+// Report is the body of the agent report and Sequence its signed sequence, if any, for the report kind
+// Machine holds the check-in name and Signal the job signal for the checkin kind
+// Machine holds the token ID and Token the created token for the token kinds
+type Message struct {
+	Kind      string          `json:"kind"`
+	Origin    string          `json:"origin"`
+	Machine   string          `json:"machine"`
+	Timestamp time.Time       `json:"timestamp"`
+	Report    json.RawMessage `json:"report,omitempty"`
+	Sequence  uint64          `json:"sequence,omitempty"`
+	Service   string          `json:"service,omitempty"`
+	Until     time.Time       `json:"until,omitempty"`
+	Reason    string          `json:"reason,omitempty"`
+	Signal    string          `json:"signal,omitempty"`
+	Token     *tokens.Token   `json:"token,omitempty"`
+}
+
+// Peer is the view of a peer from a node
+// ID is empty until the peer answered a heartbeat
+type Peer struct {
+	URL       string    `json:"url"`
+	ID        string    `json:"id,omitempty"`
+	Alive     bool      `json:"alive"`
+	LastSeen  time.Time `json:"lastSeen,omitempty"`
+	LastError string    `json:"lastError,omitempty"`
+}
+
+// Name returns the ID of the peer, or its URL when its ID is unknown
+func (p Peer) Name() string {
+	if p.ID != "" {
+		return p.ID
+	}
+	return p.URL
+}
+
+// Status is the view of the cluster from a node
+type Status struct {
+	ID     string `json:"id"`
+	Leader string `json:"leader"`
+	Peers  []Peer `json:"peers"`
+}
+
+type peer struct {
+	Peer
+	lost  bool
+	queue chan *Message
+}
+
+// Node is a server of the cluster
+// The leader is the node with the lowest ID among itself and its alive peers
+type Node struct {
+	sync.Mutex
+	id       string
+	token    string
+	interval time.Duration
+	timeout  time.Duration
+	client   *http.Client
+	peers    []*peer
+	leader   string
+	started  time.Time
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// OnPeerLost is called when a peer stops answering the heartbeats
+	OnPeerLost func(Peer)
+	// OnPeerBack is called when a lost peer answers again
+	OnPeerBack func(Peer)
+	// OnElected is called when the node becomes the leader
+	OnElected func()
+}
+
+// NewNode creates the node id of a cluster with the given peer base URLs
+// Peers are authenticated with token, they are polled every interval and lost after timeout
+func NewNode(id string, peerURLs []string, token string, interval, timeout time.Duration) *Node {
+	n := &Node{
+		id:       id,
+		token:    token,
+		interval: interval,
+		timeout:  timeout,
+		client:   &http.Client{Timeout: interval},
+		leader:   id,
+		stop:     make(chan struct{}),
+	}
+	for _, peerURL := range peerURLs {
+		n.peers = append(n.peers, &peer{
+			Peer:  Peer{URL: strings.TrimSuffix(peerURL, "/")},
+			queue: make(chan *Message, queueSize),
+		})
+	}
+	return n
+}
+
+// SetTLSConfig sets the TLS configuration used to reach the peers served over HTTPS, it must be called before Start
+func (n *Node) SetTLSConfig(tlsConfig *tls.Config) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	n.client = &http.Client{Timeout: n.interval, Transport: transport}
+}
+
+// ID returns the ID of the node
+func (n *Node) ID() string {
+	return n.id
+}
+
+// Start polls the peers once then keeps polling them and replicating messages in the background
+// Peers that never answered are assumed alive for the peer timeout after Start
+func (n *Node) Start() {
+	n.Lock()
+	n.started = time.Now()
+	n.Unlock()
+
+	n.poll()
+	for _, p := range n.peers {
+		go n.send(p)
+	}
+	go func() {
+		ticker := time.NewTicker(n.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-n.stop:
+				return
+			case <-ticker.C:
+				n.poll()
+			}
+		}
+	}()
+}
+
+// Stop stops polling and replicating, it can be called more than once
+func (n *Node) Stop() {
+	n.stopOnce.Do(func() {
+		close(n.stop)
+	})
+}
+
+// IsLeader returns true when the node is the leader
+func (n *Node) IsLeader() bool {
+	n.Lock()
+	defer n.Unlock()
+	return n.leader == n.id
+}
+
+// Status returns the view of the cluster from the node
+func (n *Node) Status() Status {
+	n.Lock()
+	defer n.Unlock()
+
+	status := Status{
+		ID:     n.id,
+		Leader: n.leader,
+		Peers:  make([]Peer, 0, len(n.peers)),
+	}
+	for _, p := range n.peers {
+		status.Peers = append(status.Peers, p.Peer)
+	}
+	return status
+}
+
+// Replicate queues msg for every alive peer
+func (n *Node) Replicate(msg *Message) {
+	msg.Origin = n.id
+
+	n.Lock()
+	defer n.Unlock()
+	for _, p := range n.peers {
+		if !p.Alive {
+			continue
+		}
+		select {
+		case p.queue <- msg:
+		default:
+			log.WithFields(log.Fields{
+				"peer": p.Name(),
+				"kind": msg.Kind,
+			}).Warn("Replication queue full, dropping message")
+		}
+	}
+}
+
+// FetchState returns the probes state of the first peer that answers
+func (n *Node) FetchState() ([]*store.ProbeState, error) {
+	var lastErr error
+	for _, p := range n.peers {
+		states := make([]*store.ProbeState, 0)
+		if err := n.request("GET", p.URL+"/cluster/state", nil, &states); err != nil {
+			lastErr = err
+			continue
+		}
+		log.WithFields(log.Fields{
+			"peer":   p.URL,
+			"probes": len(states),
+		}).Info("Fetched probes state from peer")
+		return states, nil
+	}
+	return nil, fmt.Errorf("no peer answered: %v", lastErr)
+}
+
+// poll sends a heartbeat to every peer then refreshes the leader
+func (n *Node) poll() {
+	var wg sync.WaitGroup
+	for _, p := range n.peers {
+		wg.Add(1)
+		go func(p *peer) {
+			defer wg.Done()
+			status := &Status{}
+			err := n.request("GET", p.URL+"/cluster", nil, status)
+
+			n.Lock()
+			defer n.Unlock()
+			if err != nil {
+				p.LastError = err.Error()
+				return
+			}
+			if status.ID == n.id {
+				p.LastError = "peer has the same node ID"
+				log.WithField("peer", p.URL).Error("Cluster peer has the same node ID, ignoring it")
+				return
+			}
+			p.ID = status.ID
+			p.LastSeen = time.Now()
+			p.LastError = ""
+		}(p)
+	}
+	wg.Wait()
+	n.refresh()
+}
+
+// refresh updates the liveness of the peers and the leader then calls the hooks of the changes
+func (n *Node) refresh() {
+	var lost, back []Peer
+
+	n.Lock()
+	ids := []string{n.id}
+	for _, p := range n.peers {
+		lastSeen := p.LastSeen
+		if lastSeen.IsZero() {
+			lastSeen = n.started
+		}
+		p.Alive = time.Since(lastSeen) < n.timeout
+		if p.Alive && p.ID != "" {
+			ids = append(ids, p.ID)
+		}
+		if !p.Alive && !p.lost {
+			p.lost = true
+			lost = append(lost, p.Peer)
+		} else if p.Alive && p.lost && !p.LastSeen.IsZero() {
+			p.lost = false
+			back = append(back, p.Peer)
+		}
+	}
+	sort.Strings(ids)
+	previousLeader := n.leader
+	leader := ids[0]
+	n.leader = leader
+	n.Unlock()
+
+	if leader != previousLeader {
+		log.WithFields(log.Fields{
+			"node":   n.id,
+			"leader": leader,
+		}).Warn("Cluster leader changed")
+	}
+	elected := leader == n.id && previousLeader != n.id
+	if elected && n.OnElected != nil {
+		n.OnElected()
+	}
+	for _, p := range lost {
+		log.WithField("peer", p.Name()).Error("Cluster peer lost")
+		if n.OnPeerLost != nil {
+			n.OnPeerLost(p)
+		}
+	}
+	for _, p := range back {
+		log.WithField("peer", p.Name()).Info("Cluster peer is back")
+		if n.OnPeerBack != nil {
+			n.OnPeerBack(p)
+		}
+	}
+}
+
+// send replicates the queued messages to p in order
+func (n *Node) send(p *peer) {
+	for {
+		select {
+		case <-n.stop:
+			return
+		case msg := <-p.queue:
+			body, err := json.Marshal(msg)
+			if err != nil {
+				log.WithError(err).Error("Failed to marshal replicated message")
+				continue
+			}
+			if err := n.request("POST", p.URL+"/cluster/replicate", body, nil); err != nil {
+				log.WithFields(log.Fields{
+					"peer":    p.URL,
+					"kind":    msg.Kind,
+					"machine": msg.Machine,
+				}).WithError(err).Warn("Failed to replicate message")
+			}
+		}
+	}
+}
+
+// request sends an authenticated request to a peer and decodes its JSON answer in out when it isn't nil
+func (n *Node) request(method, rawURL string, body []byte, out interface{}) error {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid peer URL: %v", err)
+	}
+	req, err := http.NewRequest(method, parsedURL.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", n.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("unexpected response %d: %s", resp.StatusCode, string(respBody))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+var node *Node
+
+// Init creates the node of the server from the cluster configuration
+// Alerts are then only sent by the leader, which also alerts about the lost peers
+func Init(cluster config.ClusterConfig, token string) (*Node, error) {
+	interval, timeout, err := cluster.Durations()
+	if err != nil {
+		return nil, err
+	}
+
+	node = NewNode(cluster.NodeID, cluster.Peers, token, interval, timeout)
+	node.OnPeerLost = func(p Peer) {
+		alerting.ServerAlert(alert.New("deepsentinel", "peer-"+p.Name(), "low"))
+	}
+	node.OnPeerBack = func(p Peer) {
+		alerting.ServerResolve(alert.New("deepsentinel", "peer-"+p.Name(), "low"))
+	}
+	node.OnElected = func() {
+		// The alerts raised while the previous leader was dying were held back here
+		alerting.TakeOver(time.Now().Add(-timeout - interval))
+	}
+	alerting.SetLeaderCheck(IsLeader)
+	return node, nil
+}
+
+// Enabled returns true when the server is part of a cluster
+func Enabled() bool {
+	return node != nil
+}
+
+// IsLeader returns true when the server is the cluster leader or isn't part of a cluster
+func IsLeader() bool {
+	if node == nil {
+		return true
+	}
+	return node.IsLeader()
+}
+
+// Replicate replicates msg to the peers of the server, it does nothing outside of a cluster
+func Replicate(msg *Message) {
+	if node == nil {
+		return
+	}
+	node.Replicate(msg)
+}
+
+// CurrentStatus returns the view of the cluster from the server
+func CurrentStatus() Status {
+	if node == nil {
+		return Status{Peers: []Peer{}}
+	}
+	return node.Status()
+}
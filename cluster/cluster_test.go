@@ -0,0 +1,122 @@
+package cluster
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/equals215/deepsentinel/store"
+	"github.com/stretchr/testify/assert"
+)
+
+// testServer serves the cluster endpoints of node like the API server does
+type testServer struct {
+	*httptest.Server
+	node     *Node
+	mu       sync.Mutex
+	received []*Message
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/cluster":
+			json.NewEncoder(w).Encode(s.node.Status())
+		case "/cluster/state":
+			json.NewEncoder(w).Encode([]*store.ProbeState{{Name: "machine1", Status: "normal"}})
+		case "/cluster/replicate":
+			msg := &Message{}
+			if err := json.NewDecoder(r.Body).Decode(msg); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			s.mu.Lock()
+			s.received = append(s.received, msg)
+			s.mu.Unlock()
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return s
+}
+
+func (s *testServer) messages() []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Message{}, s.received...)
+}
+
+func TestCluster(t *testing.T) {
+	serverA := newTestServer(t)
+	serverB := newTestServer(t)
+	defer serverB.Close()
+
+	interval, timeout := 20*time.Millisecond, 100*time.Millisecond
+	serverA.node = NewNode("a", []string{serverB.URL}, "test-token", interval, timeout)
+	serverB.node = NewNode("b", []string{serverA.URL + "/"}, "test-token", interval, timeout)
+
+	lost := make(chan Peer, 1)
+	elected := make(chan struct{}, 1)
+	serverB.node.OnPeerLost = func(p Peer) { lost <- p }
+	serverB.node.OnElected = func() { elected <- struct{}{} }
+
+	serverA.node.Start()
+	serverB.node.Start()
+	defer serverA.node.Stop()
+	defer serverB.node.Stop()
+
+	// Test case 1: the node with the lowest ID is the leader
+	assert.Eventually(t, func() bool {
+		return serverA.node.IsLeader() && !serverB.node.IsLeader()
+	}, time.Second, 10*time.Millisecond)
+	status := serverB.node.Status()
+	assert.Equal(t, "a", status.Leader)
+	assert.Equal(t, "a", status.Peers[0].ID)
+	assert.True(t, status.Peers[0].Alive)
+
+	// Test case 2: messages are replicated to the peers in order
+	serverB.node.Replicate(&Message{Kind: KindReport, Machine: "machine1", Report: json.RawMessage(`{"machineStatus":"pass"}`)})
+	serverB.node.Replicate(&Message{Kind: KindDelete, Machine: "machine1"})
+	assert.Eventually(t, func() bool {
+		return len(serverA.messages()) == 2
+	}, time.Second, 10*time.Millisecond)
+	messages := serverA.messages()
+	assert.Equal(t, KindReport, messages[0].Kind)
+	assert.Equal(t, "b", messages[0].Origin)
+	assert.JSONEq(t, `{"machineStatus":"pass"}`, string(messages[0].Report))
+	assert.Equal(t, KindDelete, messages[1].Kind)
+
+	// Test case 3: a joining node fetches the probes state of a peer
+	states, err := serverB.node.FetchState()
+	assert.Nil(t, err)
+	assert.Equal(t, "machine1", states[0].Name)
+
+	// Test case 4: the standby takes over and reports the lost leader
+	serverA.node.Stop()
+	serverA.Close()
+	select {
+	case <-elected:
+	case <-time.After(time.Second):
+		t.Fatal("standby wasn't elected")
+	}
+	select {
+	case p := <-lost:
+		assert.Equal(t, "a", p.Name())
+	case <-time.After(time.Second):
+		t.Fatal("lost peer wasn't reported")
+	}
+	assert.True(t, serverB.node.IsLeader())
+
+	// Test case 5: no peer answering fails fetching the state
+	_, err = serverB.node.FetchState()
+	assert.NotNil(t, err)
+}
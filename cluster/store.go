@@ -0,0 +1,56 @@
+package cluster
+
+import "github.com/equals215/deepsentinel/store"
+
+// seededStore loads the probes fetched from a peer and persists them to the local store, if any
+type seededStore struct {
+	local  store.Store
+	states []*store.ProbeState
+}
+
+// SeedStore returns a store loading states instead of the probes of local
+// so that a server joining the cluster starts from the state of its peers
+// local can be nil when the server doesn't persist its probes
+func SeedStore(local store.Store, states []*store.ProbeState) store.Store {
+	return &seededStore{local: local, states: states}
+}
+
+func (s *seededStore) Load() ([]*store.ProbeState, error) {
+	return s.states, nil
+}
+
+func (s *seededStore) Save(state *store.ProbeState) error {
+	if s.local == nil {
+		return nil
+	}
+	return s.local.Save(state)
+}
+
+func (s *seededStore) Delete(name string) error {
+	if s.local == nil {
+		return nil
+	}
+	return s.local.Delete(name)
+}
+
+// LoadApprovals returns the approvals of local, the peers replicate the approvals made since
+func (s *seededStore) LoadApprovals() ([]string, error) {
+	if s.local == nil {
+		return nil, nil
+	}
+	return s.local.LoadApprovals()
+}
+
+func (s *seededStore) SaveApprovals(machines []string) error {
+	if s.local == nil {
+		return nil
+	}
+	return s.local.SaveApprovals(machines)
+}
+
+func (s *seededStore) Close() error {
+	if s.local == nil {
+		return nil
+	}
+	return s.local.Close()
+}
@@ -25,6 +25,7 @@ func main() {
 	agent.Cmd(rootCmd)
 	agent.ConfigCmd(rootCmd)
 	agent.UnregisterCmd(rootCmd)
+	agent.MaintenanceCmd(rootCmd)
 	installCmd(rootCmd)
 
 	if err := rootCmd.Execute(); err != nil {
@@ -12,18 +12,41 @@ import (
 var Agent *AgentConfig
 
 // AgentConfig is the configuration for the agent
+// Labels describe the machine to the server, such as its env, role or datacenter
+// SigningSecret signs the reports when set, it must be the signed-reports secret of the server
 type AgentConfig struct {
 	sync.Mutex
-	ServerAddress string `mapstructure:"server-address"`
-	MachineName   string `mapstructure:"machine-name"`
-	LoggingLevel  string `mapstructure:"logging-level"`
-	AuthToken     string `mapstructure:"auth-token"`
-	MachineState  bool   `mapstructure:"machine-state"`
+	ServerAddress string            `mapstructure:"server-address"`
+	MachineName   string            `mapstructure:"machine-name"`
+	LoggingLevel  string            `mapstructure:"logging-level"`
+	AuthToken     string            `mapstructure:"auth-token"`
+	MachineState  bool              `mapstructure:"machine-state"`
+	Services      []ServiceConfig   `mapstructure:"services"`
+	MachineChecks []ServiceConfig   `mapstructure:"machine-checks"`
+	Thresholds    ThresholdsConfig  `mapstructure:"thresholds"`
+	Labels        map[string]string `mapstructure:"labels"`
+	TLS           AgentTLSConfig    `mapstructure:"tls"`
+	SigningSecret string            `mapstructure:"signing-secret"`
 }
 
-// ServiceConfig is the configuration for the service
+// AgentTLSConfig is the TLS configuration of the agent
+// The server is verified against CAFile, or the system roots when empty,
+// and the certificate is presented to the servers requiring client certificates
+type AgentTLSConfig struct {
+	CAFile   string `mapstructure:"ca-file"`
+	CertFile string `mapstructure:"cert-file"`
+	KeyFile  string `mapstructure:"key-file"`
+}
+
+// ServiceConfig is the configuration of a service check run by the agent
+// Machine checks share it, the worst of their results is reported as the machine status
+// Type is one of systemd, process, tcp, http or command and Target is the
+// unit, process name, host:port, URL or shell command to check
 type ServiceConfig struct {
-	ServiceName string `json:"service_name"`
+	Name    string `mapstructure:"name"`
+	Type    string `mapstructure:"type"`
+	Target  string `mapstructure:"target"`
+	Timeout string `mapstructure:"timeout"`
 }
 
 // CraftAgentConfig parse file>env>flag for agent configuration then loads it into Agent variable
@@ -93,4 +116,18 @@ func PrintAgentConfig(refresh ...bool) {
 	}
 	printToLevel("Server address: %s\n", Agent.ServerAddress)
 	printToLevel("Machine name: %s\n", Agent.MachineName)
+	printToLevel("Service checks: %d\n", len(Agent.Services))
+	printToLevel("Machine checks: %d\n", len(Agent.MachineChecks))
+	if Agent.SigningSecret != "" {
+		printToLevel("Reports are signed\n")
+	}
+	if Agent.TLS.CertFile != "" {
+		printToLevel("Client certificate: %s\n", Agent.TLS.CertFile)
+	}
+	if len(Agent.Labels) > 0 {
+		printToLevel("Labels: %v\n", Agent.Labels)
+	}
+	if !Agent.Thresholds.IsZero() {
+		printToLevel("Declared thresholds: %+v\n", Agent.Thresholds)
+	}
 }
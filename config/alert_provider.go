@@ -5,6 +5,12 @@ type AlertProviderConfig interface {
 	Type() AlertProviderType
 }
 
+// NamedAlertProviderConfig is a provider instance declared under alert-providers with its own settings
+type NamedAlertProviderConfig struct {
+	Name string
+	AlertProviderConfig
+}
+
 type EmptyProvider struct{}
 
 func (k *EmptyProvider) Type() AlertProviderType {
@@ -17,9 +23,21 @@ type AlertProviderType int
 const (
 	pagerDuty AlertProviderType = iota
 	keepHQ
+	webhook
+	smtp
 	EmptyProviderType
 )
 
 func (a AlertProviderType) String() string {
-	return [...]string{"pagerduty", "keephq"}[a]
+	return [...]string{"pagerduty", "keephq", "webhook", "smtp", ""}[a]
+}
+
+// alertProviderType returns the provider type called name
+func alertProviderType(name string) (AlertProviderType, bool) {
+	for a := pagerDuty; a < EmptyProviderType; a++ {
+		if a.String() == name {
+			return a, true
+		}
+	}
+	return EmptyProviderType, false
 }
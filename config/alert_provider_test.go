@@ -0,0 +1,50 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCraftNamedAlertProviderConfig(t *testing.T) {
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set("webhook.url", "https://default.example.com")
+	viper.Set("alert-providers.ops.type", "webhook")
+	viper.Set("alert-providers.ops.url", "https://ops.example.com")
+	viper.Set("alert-providers.ops.retries", 2)
+	viper.Set("alert-providers.oncall.type", "webhook")
+	viper.Set("alert-providers.oncall.url", "https://oncall.example.com")
+	viper.Set("alert-providers.broken.type", "carrier-pigeon")
+	viper.Set("alert-providers.mail.type", "smtp")
+	viper.Set("alert-providers.mail.host", "smtp.example.com")
+
+	// Test case 1: a provider type reads the section of the same name
+	providerConfig, err := craftNamedAlertProviderConfig("webhook")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://default.example.com", providerConfig.(*WebhookConfig).URL)
+
+	// Test case 2: two instances of the same type have their own settings
+	providerConfig, err = craftNamedAlertProviderConfig("ops")
+	assert.NoError(t, err)
+	named := providerConfig.(*NamedAlertProviderConfig)
+	assert.Equal(t, "ops", named.Name)
+	assert.Equal(t, webhook, named.Type())
+	assert.Equal(t, "https://ops.example.com", named.AlertProviderConfig.(*WebhookConfig).URL)
+	assert.Equal(t, 2, named.AlertProviderConfig.(*WebhookConfig).Retries)
+	providerConfig, err = craftNamedAlertProviderConfig("oncall")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://oncall.example.com", providerConfig.(*NamedAlertProviderConfig).AlertProviderConfig.(*WebhookConfig).URL)
+	assert.Equal(t, 3, providerConfig.(*NamedAlertProviderConfig).AlertProviderConfig.(*WebhookConfig).Retries, "unset settings take the type defaults")
+
+	// Test case 3: unknown names and types are rejected
+	_, err = craftNamedAlertProviderConfig("pager")
+	assert.ErrorContains(t, err, "'pager' is an unknown alert provider")
+	_, err = craftNamedAlertProviderConfig("broken")
+	assert.ErrorContains(t, err, "unknown alert provider type for broken")
+
+	// Test case 4: missing settings are reported under the instance section
+	_, err = craftNamedAlertProviderConfig("mail")
+	assert.ErrorContains(t, err, "alert-providers.mail.from")
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// CheckinConfig is a job expected to check in on Schedule, such as a cron job hitting /checkin/<name>
+// Schedule is a cron expression, a descriptor such as "@daily" or "every 24h"
+// The job is missed once Grace elapsed after an expected run, and it timed out when it ran longer than MaxDuration
+// or, without MaxDuration, when it is still running once Grace elapsed after its next expected run
+type CheckinConfig struct {
+	Name        string `mapstructure:"name"`
+	Schedule    string `mapstructure:"schedule"`
+	Grace       string `mapstructure:"grace"`
+	MaxDuration string `mapstructure:"max-duration"`
+	Severity    string `mapstructure:"severity"`
+}
+
+// ParsedSchedule returns the schedule of the expected runs
+func (c CheckinConfig) ParsedSchedule() (cron.Schedule, error) {
+	schedule := strings.TrimSpace(c.Schedule)
+	if strings.HasPrefix(schedule, "every ") {
+		schedule = "@" + schedule
+	}
+	return cron.ParseStandard(schedule)
+}
+
+// Durations returns the parsed grace period and maximum run duration, a zero maximum doesn't limit the runs
+func (c CheckinConfig) Durations() (time.Duration, time.Duration, error) {
+	grace, err := time.ParseDuration(c.Grace)
+	if err != nil || grace < 0 {
+		return 0, 0, fmt.Errorf("grace must be a duration")
+	}
+	if c.MaxDuration == "" {
+		return grace, 0, nil
+	}
+	maxDuration, err := time.ParseDuration(c.MaxDuration)
+	if err != nil || maxDuration <= 0 {
+		return 0, 0, fmt.Errorf("max-duration must be a positive duration")
+	}
+	return grace, maxDuration, nil
+}
+
+// validateCheckins checks the check-ins then fills their defaults
+func validateCheckins() error {
+	names := make(map[string]bool)
+	for i := range Server.Checkins {
+		checkin := &Server.Checkins[i]
+		if checkin.Name == "" || strings.Contains(checkin.Name, "/") {
+			return fmt.Errorf("checkins: every check-in requires a name without slash")
+		}
+		if names[checkin.Name] {
+			return fmt.Errorf("checkins: duplicate check-in name '%s'", checkin.Name)
+		}
+		names[checkin.Name] = true
+
+		if _, err := checkin.ParsedSchedule(); err != nil {
+			return fmt.Errorf("checkins %s: invalid schedule '%s': %v", checkin.Name, checkin.Schedule, err)
+		}
+		if checkin.Grace == "" {
+			checkin.Grace = "5m"
+		}
+		if _, _, err := checkin.Durations(); err != nil {
+			return fmt.Errorf("checkins %s: %v", checkin.Name, err)
+		}
+		switch checkin.Severity {
+		case "":
+			checkin.Severity = "high"
+		case "low", "high":
+		default:
+			return fmt.Errorf("checkins %s: severity must be low or high", checkin.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// ClusterConfig is the configuration of the high availability mode
+// The cluster is disabled when Peers is empty
+type ClusterConfig struct {
+	NodeID            string   `mapstructure:"node-id"`
+	Peers             []string `mapstructure:"-"`
+	HeartbeatInterval string   `mapstructure:"heartbeat-interval"`
+	PeerTimeout       string   `mapstructure:"peer-timeout"`
+}
+
+// Enabled returns true when peers are configured
+func (c ClusterConfig) Enabled() bool {
+	return len(c.Peers) > 0
+}
+
+// Durations returns the parsed heartbeat interval and peer timeout
+func (c ClusterConfig) Durations() (time.Duration, time.Duration, error) {
+	interval, err := time.ParseDuration(c.HeartbeatInterval)
+	if err != nil || interval <= 0 {
+		return 0, 0, fmt.Errorf("cluster.heartbeat-interval must be a positive duration")
+	}
+	timeout, err := time.ParseDuration(c.PeerTimeout)
+	if err != nil || timeout <= interval {
+		return 0, 0, fmt.Errorf("cluster.peer-timeout must be a duration longer than cluster.heartbeat-interval")
+	}
+	return interval, timeout, nil
+}
+
+func validateCluster() error {
+	Server.Cluster.Peers = stringList("cluster.peers")
+	if !Server.Cluster.Enabled() {
+		return nil
+	}
+	if Server.Cluster.NodeID == "" {
+		return fmt.Errorf("cluster.node-id is required when cluster.peers is set")
+	}
+	if Server.AuthToken == "" {
+		return fmt.Errorf("auth-token is required when cluster.peers is set")
+	}
+	_, _, err := Server.Cluster.Durations()
+	return err
+}
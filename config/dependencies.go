@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+	"path"
+)
+
+// DependencyConfig makes the machines matching Match depend on the Parent machine
+// When Service is set only the services matching it depend on Parent, such as a service using a remote database
+// Match and Service are names or globs such as "web-*"
+type DependencyConfig struct {
+	Match   string `mapstructure:"match"`
+	Service string `mapstructure:"service"`
+	Parent  string `mapstructure:"parent"`
+}
+
+func validateDependencies() error {
+	for _, dependency := range Server.Dependencies {
+		if dependency.Match == "" || dependency.Parent == "" {
+			return fmt.Errorf("dependencies: every dependency requires a match and a parent")
+		}
+		for _, pattern := range []string{dependency.Match, dependency.Service} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("dependencies: invalid match '%s': %v", pattern, err)
+			}
+		}
+	}
+	return dependencyCycle()
+}
+
+// dependencyCycle returns an error when machines depend on each other, which would suppress all their alerts
+// Only parents can be part of a cycle so the graph of the parents is enough
+func dependencyCycle() error {
+	parents := func(machine string) []string {
+		found := make([]string, 0)
+		for _, dependency := range Server.Dependencies {
+			// A machine matching its own parent glob is skipped by the server
+			if matched, _ := path.Match(dependency.Match, machine); matched && dependency.Service == "" && dependency.Parent != machine {
+				found = append(found, dependency.Parent)
+			}
+		}
+		return found
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var visit func(machine string) error
+	visit = func(machine string) error {
+		switch state[machine] {
+		case visiting:
+			return fmt.Errorf("dependencies: machine %s depends on itself", machine)
+		case visited:
+			return nil
+		}
+		state[machine] = visiting
+		for _, parent := range parents(machine) {
+			if err := visit(parent); err != nil {
+				return err
+			}
+		}
+		state[machine] = visited
+		return nil
+	}
+	for _, dependency := range Server.Dependencies {
+		if err := visit(dependency.Parent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDependencies(t *testing.T) {
+	Server = &ServerConfig{}
+
+	// Test case 1: a chain of dependencies is valid, including a parent matching its own glob
+	Server.Dependencies = []DependencyConfig{
+		{Match: "web-*", Parent: "gateway"},
+		{Match: "gateway", Parent: "router"},
+		{Match: "router-*", Parent: "router-1"},
+		{Match: "app", Service: "db-*", Parent: "db"},
+	}
+	assert.NoError(t, validateDependencies())
+
+	// Test case 2: machines depending on each other are rejected
+	Server.Dependencies = append(Server.Dependencies, DependencyConfig{Match: "rout*", Parent: "web-1"})
+	assert.ErrorContains(t, validateDependencies(), "depends on itself")
+
+	// Test case 3: a service depending on its own machine isn't a cycle
+	Server.Dependencies = []DependencyConfig{{Match: "db", Service: "replica", Parent: "db"}}
+	assert.NoError(t, validateDependencies())
+
+	// Test case 4: a dependency requires a match and a parent
+	Server.Dependencies = []DependencyConfig{{Match: "web-*"}}
+	assert.Error(t, validateDependencies())
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Group rule statuses
+const (
+	// GroupStatusFailed counts the probes that reached the failed status
+	GroupStatusFailed = "failed"
+	// GroupStatusSilent counts the probes that missed their inactivity delay
+	GroupStatusSilent = "silent"
+)
+
+// MachineLabelsConfig attaches Labels to the machines whose name matches Match
+// Match is a machine name or a glob such as "web-*"
+type MachineLabelsConfig struct {
+	Match  string            `mapstructure:"match"`
+	Labels map[string]string `mapstructure:"labels"`
+}
+
+// GroupRuleConfig alerts when at least Percent percent of the probes matching every Selector label are in Status
+// A zero Percent stands for every probe of the group
+type GroupRuleConfig struct {
+	Name     string            `mapstructure:"name"`
+	Selector map[string]string `mapstructure:"selector"`
+	Status   string            `mapstructure:"status"`
+	Percent  int               `mapstructure:"percent"`
+	Severity string            `mapstructure:"severity"`
+}
+
+// NormalizeLabels returns labels with trimmed lowercase names and trimmed values
+// Config file keys are case insensitive so label names are too
+func NormalizeLabels(labels map[string]string) map[string]string {
+	normalized := make(map[string]string, len(labels))
+	for name, value := range labels {
+		normalized[strings.ToLower(strings.TrimSpace(name))] = strings.TrimSpace(value)
+	}
+	return normalized
+}
+
+// validateGroups checks the machine labels and the group rules then fills the group rules defaults
+func validateGroups() error {
+	for i, machineLabels := range Server.MachineLabels {
+		if machineLabels.Match == "" {
+			return fmt.Errorf("machine-labels: every entry requires a match")
+		}
+		if _, err := path.Match(machineLabels.Match, ""); err != nil {
+			return fmt.Errorf("machine-labels: invalid match '%s': %v", machineLabels.Match, err)
+		}
+		Server.MachineLabels[i].Labels = NormalizeLabels(machineLabels.Labels)
+		if _, ok := Server.MachineLabels[i].Labels[""]; ok {
+			return fmt.Errorf("machine-labels %s: label names can't be empty", machineLabels.Match)
+		}
+	}
+
+	names := make(map[string]bool)
+	for i := range Server.GroupRules {
+		rule := &Server.GroupRules[i]
+		if rule.Name == "" {
+			return fmt.Errorf("group-rules: every rule requires a name")
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("group-rules: duplicate rule name '%s'", rule.Name)
+		}
+		names[rule.Name] = true
+		if len(rule.Selector) == 0 {
+			return fmt.Errorf("group-rules %s: selector can't be empty", rule.Name)
+		}
+		rule.Selector = NormalizeLabels(rule.Selector)
+
+		switch rule.Status {
+		case "":
+			rule.Status = GroupStatusFailed
+		case GroupStatusFailed, GroupStatusSilent:
+		default:
+			return fmt.Errorf("group-rules %s: status must be %s or %s", rule.Name, GroupStatusFailed, GroupStatusSilent)
+		}
+		if rule.Percent < 0 || rule.Percent > 100 {
+			return fmt.Errorf("group-rules %s: percent must be between 0 and 100", rule.Name)
+		}
+		if rule.Percent == 0 {
+			rule.Percent = 100
+		}
+		switch rule.Severity {
+		case "":
+			rule.Severity = "high"
+		case "low", "high":
+		default:
+			return fmt.Errorf("group-rules %s: severity must be low or high", rule.Name)
+		}
+	}
+	return nil
+}
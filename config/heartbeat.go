@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// HeartbeatConfig is the configuration of the check-in sent to an external dead man's switch
+// The heartbeat is disabled when URL is empty
+type HeartbeatConfig struct {
+	URL      string            `mapstructure:"url"`
+	Method   string            `mapstructure:"method"`
+	Headers  map[string]string `mapstructure:"-"`
+	Body     string            `mapstructure:"body"`
+	Interval string            `mapstructure:"interval"`
+}
+
+// Enabled returns true when a heartbeat URL is configured
+func (h HeartbeatConfig) Enabled() bool {
+	return h.URL != ""
+}
+
+// ParsedInterval returns the parsed delay between two heartbeats
+func (h HeartbeatConfig) ParsedInterval() (time.Duration, error) {
+	interval, err := time.ParseDuration(h.Interval)
+	if err != nil || interval <= 0 {
+		return 0, fmt.Errorf("heartbeat.interval must be a positive duration")
+	}
+	return interval, nil
+}
+
+func validateHeartbeat() error {
+	Server.Heartbeat.Headers = viper.GetStringMapString("heartbeat.headers")
+	if !Server.Heartbeat.Enabled() {
+		return nil
+	}
+	parsedURL, err := url.Parse(Server.Heartbeat.URL)
+	if err != nil || (parsedURL.Scheme != "http" && parsedURL.Scheme != "https") || parsedURL.Host == "" {
+		return fmt.Errorf("heartbeat.url must be an http or https URL")
+	}
+	if Server.Heartbeat.Method == "" {
+		Server.Heartbeat.Method = "GET"
+	}
+	_, err = Server.Heartbeat.ParsedInterval()
+	return err
+}
@@ -1,6 +1,8 @@
 package config
 
 type KeepHQConfig struct {
+	APIKey string `json:"api_key"`
+	APIURL string `json:"api_url"`
 }
 
 func (k *KeepHQConfig) Type() AlertProviderType {
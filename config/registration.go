@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"path"
+)
+
+// Registration policies
+const (
+	// RegistrationOpen creates a probe for every machine reporting
+	RegistrationOpen = "open"
+	// RegistrationApproval holds new machines as pending until they are approved or allowlisted
+	RegistrationApproval = "approval"
+)
+
+// RegistrationConfig decides which new machines get a probe
+// Allowlist holds machine names or globs registered without approval, a zero MaxProbes doesn't cap the probes
+type RegistrationConfig struct {
+	Policy    string   `mapstructure:"policy"`
+	Allowlist []string `mapstructure:"-"`
+	MaxProbes int      `mapstructure:"max-probes"`
+}
+
+func validateRegistration() error {
+	Server.Registration.Allowlist = stringList("registration.allowlist")
+	switch Server.Registration.Policy {
+	case "":
+		Server.Registration.Policy = RegistrationOpen
+	case RegistrationOpen, RegistrationApproval:
+	default:
+		return fmt.Errorf("'%s' is an unknown registration policy", Server.Registration.Policy)
+	}
+	for _, pattern := range Server.Registration.Allowlist {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("registration.allowlist: invalid match '%s': %v", pattern, err)
+		}
+	}
+	if Server.Registration.MaxProbes < 0 {
+		return fmt.Errorf("registration.max-probes can't be negative")
+	}
+	return nil
+}
@@ -2,7 +2,9 @@ package config
 
 import (
 	"fmt"
+	"os"
 	"strings"
+	"time"
 
 	"github.com/equals215/deepsentinel/utils"
 	log "github.com/sirupsen/logrus"
@@ -14,33 +16,184 @@ var Server *ServerConfig
 
 // ServerConfig is the configuration for the server
 type ServerConfig struct {
-	ListeningAddress                 string `mapstructure:"address"`
-	Port                             int    `mapstructure:"port"`
-	AuthToken                        string `mapstructure:"auth-token"`
-	ProbeInactivityDelay             string `mapstructure:"probe-inactivity-delay"`
-	DegradedToFailedThreshold        int    `mapstructure:"degraded-to-failed"`
-	FailedToAlertedLowThreshold      int    `mapstructure:"failed-to-alertLow"`
-	AlertedLowToAlertedHighThreshold int    `mapstructure:"alertLow-to-alertHigh"`
-	LoggingLevel                     string `mapstructure:"logging-level"`
-	LowAlertProvider                 AlertProviderConfig
-	HighAlertProvider                AlertProviderConfig
+	ListeningAddress                     string                  `mapstructure:"address"`
+	Port                                 int                     `mapstructure:"port"`
+	AuthToken                            string                  `mapstructure:"auth-token"`
+	ProbeInactivityDelay                 string                  `mapstructure:"probe-inactivity-delay"`
+	DegradedToFailedThreshold            int                     `mapstructure:"degraded-to-failed"`
+	FailedToAlertedLowThreshold          int                     `mapstructure:"failed-to-alertLow"`
+	AlertedLowToAlertedHighThreshold     int                     `mapstructure:"alertLow-to-alertHigh"`
+	WarnToAlertedLowThreshold            int                     `mapstructure:"warn-to-alertLow"`
+	WarnAlertedLowToAlertedHighThreshold int                     `mapstructure:"warn-alertLow-to-alertHigh"`
+	LoggingLevel                         string                  `mapstructure:"logging-level"`
+	ShutdownTimeout                      string                  `mapstructure:"shutdown-timeout"`
+	State                                StateConfig             `mapstructure:"state"`
+	Journal                              JournalConfig           `mapstructure:"journal"`
+	Tokens                               TokensConfig            `mapstructure:"tokens"`
+	TLS                                  TLSConfig               `mapstructure:"tls"`
+	SignedReports                        SignedReportsConfig     `mapstructure:"signed-reports"`
+	Cluster                              ClusterConfig           `mapstructure:"cluster"`
+	Heartbeat                            HeartbeatConfig         `mapstructure:"heartbeat"`
+	LowAlertPolicy                       string                  `mapstructure:"low-alert-policy"`
+	LowAlertQuorum                       int                     `mapstructure:"low-alert-quorum"`
+	HighAlertPolicy                      string                  `mapstructure:"high-alert-policy"`
+	HighAlertQuorum                      int                     `mapstructure:"high-alert-quorum"`
+	ProbeOverrides                       []ProbeOverrideConfig   `mapstructure:"probe-overrides"`
+	ThresholdLimits                      ThresholdLimitsConfig   `mapstructure:"threshold-limits"`
+	FlapDetection                        FlapDetectionConfig     `mapstructure:"flap-detection"`
+	ServiceOverrides                     []ServiceOverrideConfig `mapstructure:"service-overrides"`
+	MachineLabels                        []MachineLabelsConfig   `mapstructure:"machine-labels"`
+	GroupRules                           []GroupRuleConfig       `mapstructure:"group-rules"`
+	Dependencies                         []DependencyConfig      `mapstructure:"dependencies"`
+	Checkins                             []CheckinConfig         `mapstructure:"checkins"`
+	Registration                         RegistrationConfig      `mapstructure:"registration"`
+	LowAlertProviders                    []AlertProviderConfig
+	HighAlertProviders                   []AlertProviderConfig
 }
 
-func craftAlertProviderConfig(a AlertProviderType) (AlertProviderConfig, error) {
+// JournalConfig is the configuration of the events journal
+// Events are only kept in memory when Path is empty
+type JournalConfig struct {
+	Capacity int    `mapstructure:"capacity"`
+	Path     string `mapstructure:"path"`
+}
+
+// ParsedShutdownTimeout returns the delay given to the server to stop gracefully
+func (s *ServerConfig) ParsedShutdownTimeout() (time.Duration, error) {
+	timeout, err := time.ParseDuration(s.ShutdownTimeout)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("shutdown-timeout must be a positive duration")
+	}
+	return timeout, nil
+}
+
+// TokensConfig is the configuration of the machine tokens
+type TokensConfig struct {
+	Path string `mapstructure:"path"`
+}
+
+// StateConfig is the configuration of the probes state persistence
+type StateConfig struct {
+	Backend string `mapstructure:"backend"`
+	Path    string `mapstructure:"path"`
+}
+
+// craftAlertProviderConfig reads the settings of a provider of type a under section
+// The section is the type name for the default instance, alert-providers.<name> for a named one
+func craftAlertProviderConfig(a AlertProviderType, section string) (AlertProviderConfig, error) {
 	switch a.String() {
 	case "pagerduty":
 		return &PagerDutyConfig{
-			APIKey:         viper.GetString("pagerduty.api-key"),
-			IntegrationKey: viper.GetString("pagerduty.integration-key"),
-			IntegrationURL: viper.GetString("pagerduty.integration-url"),
+			APIKey:         viper.GetString(section + ".api-key"),
+			IntegrationKey: viper.GetString(section + ".integration-key"),
+			IntegrationURL: viper.GetString(section + ".integration-url"),
 		}, nil
 	case "keephq":
-		return nil, fmt.Errorf("keephq provider is not implemented")
+		keepHQConfig := &KeepHQConfig{
+			APIKey: viper.GetString(section + ".api-key"),
+			APIURL: strings.TrimSuffix(viper.GetString(section+".api-url"), "/"),
+		}
+		if keepHQConfig.APIKey == "" || keepHQConfig.APIURL == "" {
+			return nil, fmt.Errorf("keephq provider requires %[1]s.api-key and %[1]s.api-url", section)
+		}
+		return keepHQConfig, nil
+	case "webhook":
+		webhookConfig := &WebhookConfig{
+			URL:          viper.GetString(section + ".url"),
+			Method:       viper.GetString(section + ".method"),
+			Headers:      viper.GetStringMapString(section + ".headers"),
+			Template:     viper.GetString(section + ".template"),
+			HMACSecret:   viper.GetString(section + ".hmac-secret"),
+			HMACHeader:   viper.GetString(section + ".hmac-header"),
+			Retries:      viper.GetInt(section + ".retries"),
+			RetryBackoff: viper.GetString(section + ".retry-backoff"),
+		}
+		if webhookConfig.URL == "" {
+			return nil, fmt.Errorf("webhook provider requires %s.url", section)
+		}
+		if templateFile := viper.GetString(section + ".template-file"); templateFile != "" {
+			content, err := os.ReadFile(templateFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read webhook template file: %w", err)
+			}
+			webhookConfig.Template = string(content)
+		}
+		return webhookConfig, nil
+	case "smtp":
+		smtpConfig := &SMTPConfig{
+			Host:        viper.GetString(section + ".host"),
+			Port:        viper.GetInt(section + ".port"),
+			Username:    viper.GetString(section + ".username"),
+			Password:    viper.GetString(section + ".password"),
+			From:        viper.GetString(section + ".from"),
+			To:          stringList(section + ".to"),
+			TLS:         viper.GetString(section + ".tls"),
+			LowSubject:  viper.GetString(section + ".low-subject"),
+			HighSubject: viper.GetString(section + ".high-subject"),
+		}
+		if smtpConfig.Host == "" || smtpConfig.From == "" || len(smtpConfig.To) == 0 {
+			return nil, fmt.Errorf("smtp provider requires %[1]s.host, %[1]s.from and %[1]s.to", section)
+		}
+		switch smtpConfig.TLS {
+		case "", "none", "starttls", "tls":
+		default:
+			return nil, fmt.Errorf("'%s' is an unknown %s.tls mode", smtpConfig.TLS, section)
+		}
+		return smtpConfig, nil
 	default:
 		return nil, fmt.Errorf("unknown provider")
 	}
 }
 
+// namedAlertProviderDefaults are the settings given to named instances when they don't set them,
+// the same as the defaults of the type sections flags
+var namedAlertProviderDefaults = map[AlertProviderType]map[string]interface{}{
+	keepHQ:  {"api-url": "https://api.keephq.dev"},
+	webhook: {"retries": 3},
+	smtp:    {"port": 587},
+}
+
+// craftNamedAlertProviderConfig returns the settings of the provider listed as name
+// A name declared under alert-providers is an instance with its own settings and type,
+// otherwise the name is a provider type configured by the section of the same name
+func craftNamedAlertProviderConfig(name string) (AlertProviderConfig, error) {
+	section := "alert-providers." + name
+	if providerType := viper.GetString(section + ".type"); providerType != "" {
+		a, ok := alertProviderType(providerType)
+		if !ok {
+			return nil, fmt.Errorf("'%s' is an unknown alert provider type for %s", providerType, name)
+		}
+		for key, value := range namedAlertProviderDefaults[a] {
+			viper.SetDefault(section+"."+key, value)
+		}
+		providerConfig, err := craftAlertProviderConfig(a, section)
+		if err != nil {
+			return nil, err
+		}
+		return &NamedAlertProviderConfig{Name: name, AlertProviderConfig: providerConfig}, nil
+	}
+
+	a, ok := alertProviderType(name)
+	if !ok {
+		return nil, fmt.Errorf("'%s' is an unknown alert provider", name)
+	}
+	return craftAlertProviderConfig(a, name)
+}
+
+// stringList returns the values listed under key
+// either as a comma separated string or as a list in the config file
+func stringList(key string) []string {
+	names := make([]string, 0)
+	for _, name := range viper.GetStringSlice(key) {
+		for _, split := range strings.Split(name, ",") {
+			if split = strings.TrimSpace(split); split != "" {
+				names = append(names, split)
+			}
+		}
+	}
+	return names
+}
+
 // CraftServerConfig parse file>env>flag for server configuration then loads it into Server variable
 // Flags defaults set defaults for the server configuration
 func CraftServerConfig() error {
@@ -64,29 +217,70 @@ func CraftServerConfig() error {
 
 	viper.Unmarshal(&Server)
 
-	alertProvidersType := map[string]string{"low": viper.Get("low-alert-provider").(string), "high": viper.Get("high-alert-provider").(string)}
-	alertProviders := map[string]AlertProviderConfig{"low": nil, "high": nil}
-	for k, v := range alertProvidersType {
-		var err error
-		switch v {
-		case "pagerduty":
-			alertProviders[k], err = craftAlertProviderConfig(pagerDuty)
-			if err != nil {
-				return err
-			}
-		case "keephq":
-			alertProviders[k], err = craftAlertProviderConfig(keepHQ)
+	alertProvidersType := map[string][]string{"low": stringList("low-alert-provider"), "high": stringList("high-alert-provider")}
+	alertProviders := map[string][]AlertProviderConfig{"low": {}, "high": {}}
+	for k, names := range alertProvidersType {
+		for _, v := range names {
+			providerConfig, err := craftNamedAlertProviderConfig(v)
 			if err != nil {
 				return err
 			}
-		case "":
-			alertProviders[k] = &EmptyProvider{}
+			alertProviders[k] = append(alertProviders[k], providerConfig)
+		}
+	}
+	Server.LowAlertProviders = alertProviders["low"]
+	Server.HighAlertProviders = alertProviders["high"]
+
+	for _, policy := range []string{Server.LowAlertPolicy, Server.HighAlertPolicy} {
+		switch policy {
+		case "", "all", "failover", "quorum":
 		default:
-			return fmt.Errorf("'%s' is an unknown alert provider", v)
+			return fmt.Errorf("'%s' is an unknown alert delivery policy", policy)
 		}
 	}
-	Server.LowAlertProvider = alertProviders["low"]
-	Server.HighAlertProvider = alertProviders["high"]
+
+	if Server.ShutdownTimeout == "" {
+		Server.ShutdownTimeout = "30s"
+	}
+	if _, err := Server.ParsedShutdownTimeout(); err != nil {
+		return err
+	}
+
+	if err := validateThresholds(); err != nil {
+		return err
+	}
+
+	if err := validateGroups(); err != nil {
+		return err
+	}
+
+	if err := validateDependencies(); err != nil {
+		return err
+	}
+
+	if err := validateCheckins(); err != nil {
+		return err
+	}
+
+	if err := validateRegistration(); err != nil {
+		return err
+	}
+
+	if err := validateSignedReports(); err != nil {
+		return err
+	}
+
+	if err := validateTLS(); err != nil {
+		return err
+	}
+
+	if err := validateCluster(); err != nil {
+		return err
+	}
+
+	if err := validateHeartbeat(); err != nil {
+		return err
+	}
 
 	SetLogging()
 
@@ -103,9 +297,59 @@ func CraftServerConfig() error {
 // PrintServerConfig prints the server configuration
 func PrintServerConfig() {
 	log.Info("deepSentinel API server starting...")
-	log.Infof("Serving on %s:%d", Server.ListeningAddress, Server.Port)
+	if Server.TLS.Enabled() {
+		log.Infof("Serving HTTPS on %s:%d", Server.ListeningAddress, Server.Port)
+	} else {
+		log.Infof("Serving on %s:%d", Server.ListeningAddress, Server.Port)
+	}
+	if Server.TLS.ClientCAFile != "" {
+		log.Infof("Client certificates verified against %s, required: %t", Server.TLS.ClientCAFile, Server.TLS.RequireClientCert)
+	}
+	log.Infof("Machine tokens: %s", Server.Tokens.Path)
+	if Server.SignedReports.Enabled() {
+		log.Infof("Signed reports: %s, max clock skew %s", Server.SignedReports.Mode, Server.SignedReports.MaxSkew)
+	}
+	if Server.AuthToken == "" {
+		log.Warn("Global auth token disabled, only machine tokens are accepted")
+	}
 	log.Infof("Probe inactivity delay: %s", Server.ProbeInactivityDelay)
 	log.Infof("Degraded to failed threshold: %d", Server.DegradedToFailedThreshold)
 	log.Infof("Failed to alerted low threshold: %d", Server.FailedToAlertedLowThreshold)
 	log.Infof("Alerted low to alerted high threshold: %d", Server.AlertedLowToAlertedHighThreshold)
+	if len(Server.ProbeOverrides) > 0 {
+		log.Infof("Probe threshold overrides: %d", len(Server.ProbeOverrides))
+	}
+	if Server.Registration.Policy == RegistrationApproval {
+		log.Infof("Registration: new machines need approval, allowlist: %s", strings.Join(Server.Registration.Allowlist, ", "))
+	}
+	if Server.Registration.MaxProbes > 0 {
+		log.Infof("Maximum probes: %d", Server.Registration.MaxProbes)
+	}
+	if len(Server.Dependencies) > 0 {
+		log.Infof("Dependencies: %d", len(Server.Dependencies))
+	}
+	if len(Server.Checkins) > 0 {
+		log.Infof("Check-ins: %d", len(Server.Checkins))
+	}
+	if len(Server.GroupRules) > 0 {
+		log.Infof("Group rules: %d", len(Server.GroupRules))
+	}
+	if Server.Cluster.Enabled() {
+		log.Infof("Cluster node %s with peers: %s", Server.Cluster.NodeID, strings.Join(Server.Cluster.Peers, ", "))
+	}
+	if Server.Heartbeat.Enabled() {
+		log.Infof("Heartbeat: %s every %s", Server.Heartbeat.Method, Server.Heartbeat.Interval)
+	}
+	log.Infof("Low alert providers: %s (policy %s)", strings.Join(stringList("low-alert-provider"), ", "), Server.LowAlertPolicy)
+	log.Infof("High alert providers: %s (policy %s)", strings.Join(stringList("high-alert-provider"), ", "), Server.HighAlertPolicy)
+	if Server.Journal.Path != "" {
+		log.Infof("Events journal: %d events (%s)", Server.Journal.Capacity, Server.Journal.Path)
+	} else {
+		log.Infof("Events journal: %d events in memory", Server.Journal.Capacity)
+	}
+	if Server.State.Backend != "" {
+		log.Infof("State backend: %s (%s)", Server.State.Backend, Server.State.Path)
+	} else {
+		log.Warn("No state backend configured, probes won't survive a restart")
+	}
 }
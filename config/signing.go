@@ -0,0 +1,59 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Signed reports modes
+const (
+	// SignedReportsOff ignores the report signatures
+	SignedReportsOff = "off"
+	// SignedReportsOptional checks the signed reports and accepts the unsigned ones of the machines that never signed
+	SignedReportsOptional = "optional"
+	// SignedReportsRequired rejects the unsigned reports
+	SignedReportsRequired = "required"
+)
+
+// SignedReportsConfig is the configuration of the HMAC signed reports
+// Signed reports are rejected when their timestamp is more than MaxSkew away from the server clock
+type SignedReportsConfig struct {
+	Mode    string `mapstructure:"mode"`
+	Secret  string `mapstructure:"secret"`
+	MaxSkew string `mapstructure:"max-skew"`
+}
+
+// Enabled returns true when the report signatures are checked
+func (s SignedReportsConfig) Enabled() bool {
+	return s.Mode == SignedReportsOptional || s.Mode == SignedReportsRequired
+}
+
+// ParsedMaxSkew returns the parsed clock skew tolerance
+func (s SignedReportsConfig) ParsedMaxSkew() (time.Duration, error) {
+	maxSkew, err := time.ParseDuration(s.MaxSkew)
+	if err != nil || maxSkew <= 0 {
+		return 0, fmt.Errorf("signed-reports.max-skew must be a positive duration")
+	}
+	return maxSkew, nil
+}
+
+func validateSignedReports() error {
+	switch Server.SignedReports.Mode {
+	case "":
+		Server.SignedReports.Mode = SignedReportsOff
+	case SignedReportsOff, SignedReportsOptional, SignedReportsRequired:
+	default:
+		return fmt.Errorf("'%s' is an unknown signed-reports mode", Server.SignedReports.Mode)
+	}
+	if !Server.SignedReports.Enabled() {
+		return nil
+	}
+	if Server.SignedReports.Secret == "" {
+		return fmt.Errorf("signed-reports.secret is required when signed-reports.mode is %s", Server.SignedReports.Mode)
+	}
+	if Server.SignedReports.MaxSkew == "" {
+		Server.SignedReports.MaxSkew = "30s"
+	}
+	_, err := Server.SignedReports.ParsedMaxSkew()
+	return err
+}
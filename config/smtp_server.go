@@ -0,0 +1,17 @@
+package config
+
+type SMTPConfig struct {
+	Host        string   `json:"host"`
+	Port        int      `json:"port"`
+	Username    string   `json:"username"`
+	Password    string   `json:"password"`
+	From        string   `json:"from"`
+	To          []string `json:"to"`
+	TLS         string   `json:"tls"`
+	LowSubject  string   `json:"low_subject"`
+	HighSubject string   `json:"high_subject"`
+}
+
+func (s *SMTPConfig) Type() AlertProviderType {
+	return smtp
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"time"
+)
+
+// ThresholdsConfig holds the escalation settings of a probe
+// Zero values inherit the server-wide settings
+type ThresholdsConfig struct {
+	ProbeInactivityDelay             string `mapstructure:"probe-inactivity-delay" json:"probeInactivityDelay,omitempty"`
+	DegradedToFailedThreshold        int    `mapstructure:"degraded-to-failed" json:"degradedToFailed,omitempty"`
+	FailedToAlertedLowThreshold      int    `mapstructure:"failed-to-alertLow" json:"failedToAlertLow,omitempty"`
+	AlertedLowToAlertedHighThreshold int    `mapstructure:"alertLow-to-alertHigh" json:"alertLowToAlertHigh,omitempty"`
+}
+
+// ProbeOverrideConfig applies thresholds to the probes whose name matches Match
+// Match is a machine name or a glob such as "batch-*"
+type ProbeOverrideConfig struct {
+	Match            string `mapstructure:"match"`
+	ThresholdsConfig `mapstructure:",squash"`
+}
+
+// ServiceOverrideConfig applies the warn escalation settings to the services whose name matches Match
+// on the machines matching Machine, an empty Machine matches every machine
+// Unset settings inherit the server-wide ones, 0 disables the escalation
+type ServiceOverrideConfig struct {
+	Match                                string `mapstructure:"match"`
+	Machine                              string `mapstructure:"machine"`
+	WarnToAlertedLowThreshold            *int   `mapstructure:"warn-to-alertLow"`
+	WarnAlertedLowToAlertedHighThreshold *int   `mapstructure:"warn-alertLow-to-alertHigh"`
+}
+
+// FlapDetectionConfig detects the services changing status too often
+// A service flaps once it changed status Threshold times over its last Window reports
+// and recovers after RecoveryPasses consecutive pass reports. A zero Window or Threshold disables it
+type FlapDetectionConfig struct {
+	Window         int `mapstructure:"window"`
+	Threshold      int `mapstructure:"threshold"`
+	RecoveryPasses int `mapstructure:"recovery-passes"`
+}
+
+// MaxFlapHistory is the maximum number of reports flap detection can look back at
+// It matches the number of reports kept when the time series are trimmed
+const MaxFlapHistory = 100
+
+// Enabled returns true when flap detection is configured
+func (f FlapDetectionConfig) Enabled() bool {
+	return f.Window > 0 && f.Threshold > 0
+}
+
+// ThresholdLimitsConfig bounds the thresholds declared by the agents
+// Empty or zero limits don't bound anything
+type ThresholdLimitsConfig struct {
+	MinProbeInactivityDelay string `mapstructure:"min-probe-inactivity-delay"`
+	MaxProbeInactivityDelay string `mapstructure:"max-probe-inactivity-delay"`
+	MinThreshold            int    `mapstructure:"min-threshold"`
+	MaxThreshold            int    `mapstructure:"max-threshold"`
+}
+
+// IsZero returns true when no threshold is set
+func (t ThresholdsConfig) IsZero() bool {
+	return t == ThresholdsConfig{}
+}
+
+func (t ThresholdsConfig) validate() error {
+	if t.ProbeInactivityDelay != "" {
+		if err := validateDelay(t.ProbeInactivityDelay); err != nil {
+			return err
+		}
+	}
+	for _, threshold := range []int{t.DegradedToFailedThreshold, t.FailedToAlertedLowThreshold, t.AlertedLowToAlertedHighThreshold} {
+		if threshold < 0 {
+			return fmt.Errorf("threshold %d can't be negative", threshold)
+		}
+	}
+	return nil
+}
+
+func validateDelay(delay string) error {
+	duration, err := time.ParseDuration(delay)
+	if err != nil {
+		return fmt.Errorf("invalid delay '%s': %v", delay, err)
+	}
+	if duration <= 0 {
+		return fmt.Errorf("delay '%s' must be positive", delay)
+	}
+	return nil
+}
+
+// validateThresholds checks the server-wide thresholds, the probe and service overrides, the flap detection and the limits
+func validateThresholds() error {
+	if err := validateDelay(Server.ProbeInactivityDelay); err != nil {
+		return fmt.Errorf("probe-inactivity-delay: %v", err)
+	}
+
+	for _, override := range Server.ProbeOverrides {
+		if override.Match == "" {
+			return fmt.Errorf("probe-overrides: every override requires a match")
+		}
+		if _, err := path.Match(override.Match, ""); err != nil {
+			return fmt.Errorf("probe-overrides: invalid match '%s': %v", override.Match, err)
+		}
+		if err := override.validate(); err != nil {
+			return fmt.Errorf("probe-overrides %s: %v", override.Match, err)
+		}
+	}
+
+	if Server.WarnToAlertedLowThreshold < 0 || Server.WarnAlertedLowToAlertedHighThreshold < 0 {
+		return fmt.Errorf("warn thresholds can't be negative")
+	}
+	for _, override := range Server.ServiceOverrides {
+		if override.Match == "" {
+			return fmt.Errorf("service-overrides: every override requires a match")
+		}
+		for _, pattern := range []string{override.Match, override.Machine} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("service-overrides: invalid match '%s': %v", pattern, err)
+			}
+		}
+		for _, threshold := range []*int{override.WarnToAlertedLowThreshold, override.WarnAlertedLowToAlertedHighThreshold} {
+			if threshold != nil && *threshold < 0 {
+				return fmt.Errorf("service-overrides %s: threshold %d can't be negative", override.Match, *threshold)
+			}
+		}
+	}
+
+	flap := Server.FlapDetection
+	if flap.Window < 0 || flap.Threshold < 0 || flap.RecoveryPasses < 0 {
+		return fmt.Errorf("flap-detection: settings can't be negative")
+	}
+	if flap.Window > MaxFlapHistory || flap.RecoveryPasses > MaxFlapHistory {
+		return fmt.Errorf("flap-detection: window and recovery-passes can't exceed %d reports", MaxFlapHistory)
+	}
+	if flap.Enabled() && flap.Threshold >= flap.Window {
+		return fmt.Errorf("flap-detection: threshold must be lower than window, %d reports hold at most %d changes", flap.Window, flap.Window-1)
+	}
+
+	limits := Server.ThresholdLimits
+	for _, delay := range []string{limits.MinProbeInactivityDelay, limits.MaxProbeInactivityDelay} {
+		if delay == "" {
+			continue
+		}
+		if err := validateDelay(delay); err != nil {
+			return fmt.Errorf("threshold-limits: %v", err)
+		}
+	}
+	if limits.MinProbeInactivityDelay != "" && limits.MaxProbeInactivityDelay != "" {
+		minDelay, _ := time.ParseDuration(limits.MinProbeInactivityDelay)
+		maxDelay, _ := time.ParseDuration(limits.MaxProbeInactivityDelay)
+		if minDelay > maxDelay {
+			return fmt.Errorf("threshold-limits: min-probe-inactivity-delay is greater than max-probe-inactivity-delay")
+		}
+	}
+	if limits.MaxThreshold > 0 && limits.MinThreshold > limits.MaxThreshold {
+		return fmt.Errorf("threshold-limits: min-threshold is greater than max-threshold")
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package config
+
+import "fmt"
+
+// TLSConfig is the configuration of the server HTTPS listener, plain HTTP is served when CertFile is empty
+// Client certificates are verified against ClientCAFile when set, and required when RequireClientCert is set
+// The cluster peers served over HTTPS are verified against PeerCAFile when set, the system roots otherwise
+type TLSConfig struct {
+	CertFile          string `mapstructure:"cert-file"`
+	KeyFile           string `mapstructure:"key-file"`
+	ClientCAFile      string `mapstructure:"client-ca-file"`
+	RequireClientCert bool   `mapstructure:"require-client-cert"`
+	PeerCAFile        string `mapstructure:"peer-ca-file"`
+}
+
+// Enabled returns true when the server serves HTTPS
+func (t TLSConfig) Enabled() bool {
+	return t.CertFile != ""
+}
+
+func validateTLS() error {
+	if (Server.TLS.CertFile == "") != (Server.TLS.KeyFile == "") {
+		return fmt.Errorf("tls.cert-file and tls.key-file must be set together")
+	}
+	if !Server.TLS.Enabled() && Server.TLS.ClientCAFile != "" {
+		return fmt.Errorf("tls.client-ca-file requires tls.cert-file")
+	}
+	if Server.TLS.RequireClientCert && Server.TLS.ClientCAFile == "" {
+		return fmt.Errorf("tls.require-client-cert requires tls.client-ca-file")
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package config
+
+type WebhookConfig struct {
+	URL          string            `json:"url"`
+	Method       string            `json:"method"`
+	Headers      map[string]string `json:"headers"`
+	Template     string            `json:"template"`
+	HMACSecret   string            `json:"hmac_secret"`
+	HMACHeader   string            `json:"hmac_header"`
+	Retries      int               `json:"retries"`
+	RetryBackoff string            `json:"retry_backoff"`
+}
+
+func (w *WebhookConfig) Type() AlertProviderType {
+	return webhook
+}
@@ -2,15 +2,46 @@ package dashboard
 
 import (
 	"sync"
+	"time"
 )
 
+// Probe is the state of a machine, UnreachableVia is the failed machine it depends on
 type Probe struct {
-	Name   string `json:"name"`
-	Status string `json:"status"`
+	Name           string            `json:"name"`
+	Status         string            `json:"status"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	UnreachableVia string            `json:"unreachableVia,omitempty"`
+	Silences       []*Silence        `json:"silences,omitempty"`
+}
+
+// Silence is a maintenance window shown next to the probe, an empty Service silences the whole machine
+type Silence struct {
+	Service string     `json:"service,omitempty"`
+	Until   *time.Time `json:"until,omitempty"`
+	Reason  string     `json:"reason,omitempty"`
+}
+
+// Group is the state of a group rule, Affected probes are in the status counted by the rule
+type Group struct {
+	Name      string `json:"name"`
+	Probes    int    `json:"probes"`
+	Affected  int    `json:"affected"`
+	Triggered bool   `json:"triggered"`
+}
+
+// Checkin is the state of a job checking in
+type Checkin struct {
+	Name    string    `json:"name"`
+	Status  string    `json:"status"`
+	NextRun time.Time `json:"nextRun"`
 }
 
 type Data struct {
-	Probes []*Probe `json:"probes"`
+	Probes        []*Probe   `json:"probes"`
+	Groups        []*Group   `json:"groups,omitempty"`
+	Checkins      []*Checkin `json:"checkins,omitempty"`
+	Pending       []string   `json:"pending,omitempty"`
+	AlertingError string     `json:"alertingError,omitempty"`
 }
 
 type Operator struct {
@@ -48,6 +79,13 @@ func (o *Operator) NewWorker() (chan *Data, int) {
 	return worker, i
 }
 
+// Clients returns the number of connected dashboard clients
+func (o *Operator) Clients() int {
+	o.Lock()
+	defer o.Unlock()
+	return len(o.workers)
+}
+
 func (o *Operator) RemoveWorker(i int) {
 	o.Lock()
 	close(o.workers[i])
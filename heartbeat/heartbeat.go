@@ -0,0 +1,141 @@
+// Package heartbeat checks in with an external dead man's switch while the server is healthy.
+package heartbeat
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/equals215/deepsentinel/alerting"
+	"github.com/equals215/deepsentinel/config"
+	"github.com/equals215/deepsentinel/monitoring"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrMonitoringStalled is returned by Healthy when the monitoring loop stopped ticking
+var ErrMonitoringStalled = errors.New("monitoring loop stalled")
+
+// Check returns an error when the server is unhealthy and mustn't check in
+type Check func() error
+
+// Healthy is the default Check, it fails when the monitoring loop stalled or the last alert delivery failed
+func Healthy() error {
+	if !monitoring.LoopHealthy() {
+		return ErrMonitoringStalled
+	}
+	if alertingHealth := alerting.Health(); !alertingHealth.Healthy {
+		return fmt.Errorf("alerting unhealthy: %s", alertingHealth.LastError)
+	}
+	return nil
+}
+
+// Pinger sends a request to the heartbeat URL every interval as long as its check passes
+type Pinger struct {
+	url      string
+	method   string
+	headers  map[string]string
+	body     string
+	interval time.Duration
+	check    Check
+	client   *http.Client
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	sync.Mutex
+	lastError error
+}
+
+// New creates a pinger from the heartbeat configuration
+func New(heartbeat config.HeartbeatConfig, check Check) (*Pinger, error) {
+	interval, err := heartbeat.ParsedInterval()
+	if err != nil {
+		return nil, err
+	}
+	method := heartbeat.Method
+	if method == "" {
+		method = "GET"
+	}
+	return &Pinger{
+		url:      heartbeat.URL,
+		method:   strings.ToUpper(method),
+		headers:  heartbeat.Headers,
+		body:     heartbeat.Body,
+		interval: interval,
+		check:    check,
+		client:   &http.Client{Timeout: interval},
+		stop:     make(chan struct{}),
+	}, nil
+}
+
+// Start beats every interval in the background
+// The first beat is sent after one interval so that the monitoring loop had time to start
+func (p *Pinger) Start() {
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.Beat()
+			}
+		}
+	}()
+}
+
+// Stop stops beating, it can be called more than once
+func (p *Pinger) Stop() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+}
+
+// Beat sends the heartbeat if the check passes
+// Failures are only logged when they differ from the previous beat to avoid flooding the logs
+func (p *Pinger) Beat() error {
+	err := p.check()
+	if err != nil {
+		err = fmt.Errorf("heartbeat skipped: %w", err)
+	} else {
+		err = p.send()
+	}
+
+	p.Lock()
+	defer p.Unlock()
+	switch {
+	case err != nil && (p.lastError == nil || p.lastError.Error() != err.Error()):
+		log.WithError(err).Error("Heartbeat not sent, the external dead man's switch will fire")
+	case err == nil && p.lastError != nil:
+		log.Info("Heartbeat sent again")
+	}
+	p.lastError = err
+	return err
+}
+
+func (p *Pinger) send() error {
+	req, err := http.NewRequest(p.method, p.url, strings.NewReader(p.body))
+	if err != nil {
+		return err
+	}
+	for key, value := range p.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response %d", resp.StatusCode)
+	}
+	log.Trace("Heartbeat sent")
+	return nil
+}
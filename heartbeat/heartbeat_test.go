@@ -0,0 +1,99 @@
+package heartbeat
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/equals215/deepsentinel/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// checkIn records the requests received by a local stand-in of the external service
+type checkIn struct {
+	method string
+	header string
+	body   string
+}
+
+func newStandIn(t *testing.T, status int) (*httptest.Server, func() []checkIn) {
+	var mu sync.Mutex
+	var received []checkIn
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, checkIn{method: r.Method, header: r.Header.Get("X-Test"), body: string(body)})
+		mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []checkIn {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]checkIn{}, received...)
+	}
+}
+
+func TestBeat(t *testing.T) {
+	server, received := newStandIn(t, http.StatusOK)
+	var checkErr error
+	pinger, err := New(config.HeartbeatConfig{
+		URL:      server.URL,
+		Method:   "post",
+		Headers:  map[string]string{"X-Test": "value"},
+		Body:     `{"server":"deepsentinel"}`,
+		Interval: "1m",
+	}, func() error { return checkErr })
+	assert.NoError(t, err)
+
+	// Test case 1: a healthy server checks in with the configured request
+	assert.NoError(t, pinger.Beat())
+	assert.Equal(t, []checkIn{{method: "POST", header: "value", body: `{"server":"deepsentinel"}`}}, received())
+
+	// Test case 2: an unhealthy server stays silent
+	checkErr = errors.New("monitoring loop stalled")
+	assert.ErrorContains(t, pinger.Beat(), "monitoring loop stalled")
+	assert.Len(t, received(), 1)
+
+	// Test case 3: the server checks in again once healthy
+	checkErr = nil
+	assert.NoError(t, pinger.Beat())
+	assert.Len(t, received(), 2)
+}
+
+func TestBeatFailure(t *testing.T) {
+	server, _ := newStandIn(t, http.StatusInternalServerError)
+	pinger, err := New(config.HeartbeatConfig{URL: server.URL, Interval: "1m"}, func() error { return nil })
+	assert.NoError(t, err)
+
+	// Test case 1: an error answer is a failed beat
+	assert.ErrorContains(t, pinger.Beat(), "unexpected response 500")
+
+	// Test case 2: an invalid interval is rejected
+	_, err = New(config.HeartbeatConfig{URL: server.URL, Interval: "0s"}, Healthy)
+	assert.Error(t, err)
+}
+
+func TestStart(t *testing.T) {
+	server, received := newStandIn(t, http.StatusOK)
+	pinger, err := New(config.HeartbeatConfig{URL: server.URL, Interval: "20ms"}, func() error { return nil })
+	assert.NoError(t, err)
+
+	// Test case 1: beats are sent every interval until stopped
+	pinger.Start()
+	assert.Eventually(t, func() bool { return len(received()) >= 2 }, time.Second, 10*time.Millisecond)
+	pinger.Stop()
+	pinger.Stop()
+	count := len(received())
+	time.Sleep(60 * time.Millisecond)
+	assert.LessOrEqual(t, len(received()), count+1)
+}
+
+func TestHealthy(t *testing.T) {
+	// Test case 1: the server is unhealthy until the monitoring loop runs
+	assert.ErrorIs(t, Healthy(), ErrMonitoringStalled)
+}
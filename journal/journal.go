@@ -0,0 +1,320 @@
+// Package journal records the probes state transitions and alerts as structured events.
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultCapacity is the number of events kept when no capacity is configured
+const DefaultCapacity = 10000
+
+// Event kinds
+const (
+	KindTransition   = "transition"
+	KindAlert        = "alert"
+	KindResolution   = "resolution"
+	KindSilence      = "silence"
+	KindDelete       = "delete"
+	KindRegistration = "registration"
+)
+
+// Event is a state transition, an alert or an action on a probe
+// Service is empty for events about the machine itself
+// Group and Checkin are only set for events about a group rule or a check-in
+type Event struct {
+	ID              uint64       `json:"id"`
+	Timestamp       time.Time    `json:"timestamp"`
+	Kind            string       `json:"kind"`
+	Machine         string       `json:"machine,omitempty"`
+	Service         string       `json:"service,omitempty"`
+	Group           string       `json:"group,omitempty"`
+	Checkin         string       `json:"checkin,omitempty"`
+	From            string       `json:"from,omitempty"`
+	To              string       `json:"to,omitempty"`
+	Counter         int          `json:"counter"`
+	SinceLastNormal string       `json:"sinceLastNormal,omitempty"`
+	Alert           *AlertRecord `json:"alert,omitempty"`
+	Message         string       `json:"message,omitempty"`
+}
+
+// AlertRecord is the outcome of an alert or a resolution
+type AlertRecord struct {
+	Severity   string           `json:"severity"`
+	Reason     string           `json:"reason,omitempty"`
+	Suppressed bool             `json:"suppressed,omitempty"`
+	Policy     string           `json:"policy,omitempty"`
+	Delivered  bool             `json:"delivered"`
+	Providers  []ProviderRecord `json:"providers,omitempty"`
+}
+
+// ProviderRecord is the outcome of an alert through one provider
+type ProviderRecord struct {
+	Provider string `json:"provider"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Filter filters the events, zero values don't filter
+// Limit keeps the latest events
+type Filter struct {
+	Since   time.Time
+	Until   time.Time
+	Machine string
+	Service string
+	Group   string
+	Checkin string
+	Limit   int
+}
+
+// Journal is a bounded ring of events, optionally persisted to a JSON lines file
+type Journal struct {
+	sync.Mutex
+	events   []Event
+	start    int
+	size     int
+	nextID   uint64
+	path     string
+	file     *os.File
+	written  int
+	capacity int
+}
+
+var journal *Journal
+
+// Init creates the journal used by Record and Query
+// Events are only kept in memory when path is empty
+func Init(capacity int, path string) error {
+	newJournal, err := New(capacity, path)
+	if err != nil {
+		return err
+	}
+	if journal != nil {
+		journal.Close()
+	}
+	journal = newJournal
+	return nil
+}
+
+// Record adds an event to the journal set by Init, events are dropped if Init wasn't called
+func Record(event Event) {
+	if journal == nil {
+		return
+	}
+	journal.Record(event)
+}
+
+// Query returns the events of the journal set by Init matching f
+func Query(f Filter) []Event {
+	if journal == nil {
+		return []Event{}
+	}
+	return journal.Query(f)
+}
+
+// Close closes the journal set by Init
+func Close() error {
+	if journal == nil {
+		return nil
+	}
+	return journal.Close()
+}
+
+// New creates a journal keeping the last capacity events
+// When path is set, the events it holds are loaded and new events are appended to it
+func New(capacity int, path string) (*Journal, error) {
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	j := &Journal{
+		events:   make([]Event, capacity),
+		capacity: capacity,
+		path:     path,
+	}
+	if path == "" {
+		return j, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create journal directory: %w", err)
+	}
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+	// Rewriting drops the events that don't fit anymore and any truncated line
+	if err := j.compact(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+func (j *Journal) load() error {
+	file, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			log.WithError(err).Warn("Skipping invalid journal line")
+			continue
+		}
+		j.push(event)
+		if event.ID >= j.nextID {
+			j.nextID = event.ID + 1
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read journal: %w", err)
+	}
+	return nil
+}
+
+// compact rewrites the journal file with the events in memory then reopens it for appending
+func (j *Journal) compact() error {
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(j.path), ".journal-*")
+	if err != nil {
+		return fmt.Errorf("failed to compact journal: %w", err)
+	}
+	writer := bufio.NewWriter(temp)
+	encoder := json.NewEncoder(writer)
+	for i := 0; i < j.size; i++ {
+		if err := encoder.Encode(j.at(i)); err != nil {
+			temp.Close()
+			os.Remove(temp.Name())
+			return fmt.Errorf("failed to compact journal: %w", err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return fmt.Errorf("failed to compact journal: %w", err)
+	}
+	temp.Close()
+	if err := os.Rename(temp.Name(), j.path); err != nil {
+		os.Remove(temp.Name())
+		return fmt.Errorf("failed to compact journal: %w", err)
+	}
+
+	j.file, err = os.OpenFile(j.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	j.written = j.size
+	return nil
+}
+
+func (j *Journal) push(event Event) {
+	if j.size < j.capacity {
+		j.events[(j.start+j.size)%j.capacity] = event
+		j.size++
+		return
+	}
+	j.events[j.start] = event
+	j.start = (j.start + 1) % j.capacity
+}
+
+// at returns the i-th oldest event
+func (j *Journal) at(i int) Event {
+	return j.events[(j.start+i)%j.capacity]
+}
+
+// Record adds an event, its ID and timestamp are set by the journal
+func (j *Journal) Record(event Event) {
+	j.Lock()
+	defer j.Unlock()
+
+	event.ID = j.nextID
+	j.nextID++
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+	j.push(event)
+
+	if j.file == nil {
+		return
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.WithError(err).Error("Failed to marshal journal event")
+		return
+	}
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		log.WithError(err).Error("Failed to persist journal event")
+		return
+	}
+	j.written++
+
+	// The file keeps at most twice the capacity before being rewritten
+	if j.written >= 2*j.capacity {
+		if err := j.compact(); err != nil {
+			log.WithError(err).Error("Failed to compact journal")
+		}
+	}
+}
+
+// Query returns the events matching f from the oldest to the newest
+func (j *Journal) Query(f Filter) []Event {
+	j.Lock()
+	defer j.Unlock()
+
+	events := make([]Event, 0)
+	for i := 0; i < j.size; i++ {
+		event := j.at(i)
+		if !f.Since.IsZero() && event.Timestamp.Before(f.Since) {
+			continue
+		}
+		if !f.Until.IsZero() && event.Timestamp.After(f.Until) {
+			continue
+		}
+		if f.Machine != "" && event.Machine != f.Machine {
+			continue
+		}
+		if f.Service != "" && event.Service != f.Service {
+			continue
+		}
+		if f.Group != "" && event.Group != f.Group {
+			continue
+		}
+		if f.Checkin != "" && event.Checkin != f.Checkin {
+			continue
+		}
+		events = append(events, event)
+	}
+
+	if f.Limit > 0 && len(events) > f.Limit {
+		events = events[len(events)-f.Limit:]
+	}
+	return events
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	j.Lock()
+	defer j.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
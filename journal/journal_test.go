@@ -0,0 +1,105 @@
+package journal
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournal(t *testing.T) {
+	j, err := New(3, "")
+	assert.Nil(t, err)
+	start := time.Now()
+
+	// Test case 1: events are returned from the oldest to the newest
+	j.Record(Event{Kind: KindTransition, Machine: "machine1", From: "normal", To: "degraded", Timestamp: start})
+	j.Record(Event{Kind: KindTransition, Machine: "machine2", Service: "nginx", From: "pass", To: "fail", Timestamp: start.Add(time.Second)})
+	events := j.Query(Filter{})
+	assert.Len(t, events, 2)
+	assert.Equal(t, uint64(0), events[0].ID)
+	assert.Equal(t, "machine2", events[1].Machine)
+
+	// Test case 2: the oldest events are dropped once the capacity is reached
+	j.Record(Event{Kind: KindAlert, Machine: "machine1", Timestamp: start.Add(2 * time.Second)})
+	j.Record(Event{Kind: KindTransition, Machine: "machine1", From: "degraded", To: "normal", Timestamp: start.Add(3 * time.Second)})
+	events = j.Query(Filter{})
+	assert.Len(t, events, 3)
+	assert.Equal(t, []uint64{1, 2, 3}, []uint64{events[0].ID, events[1].ID, events[2].ID})
+
+	// Test case 3: events are filtered by machine, service and time
+	assert.Len(t, j.Query(Filter{Machine: "machine1"}), 2)
+	assert.Len(t, j.Query(Filter{Service: "nginx"}), 1)
+	assert.Len(t, j.Query(Filter{Since: start.Add(2 * time.Second)}), 2)
+	assert.Len(t, j.Query(Filter{Until: start.Add(2 * time.Second)}), 2)
+
+	// Test case 4: the limit keeps the latest events
+	events = j.Query(Filter{Limit: 1})
+	assert.Len(t, events, 1)
+	assert.Equal(t, uint64(3), events[0].ID)
+}
+
+func TestJournalPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal", "events.jsonl")
+
+	// Test case 1: events are reloaded and IDs keep increasing
+	j, err := New(5, path)
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		j.Record(Event{Kind: KindTransition, Machine: "machine1"})
+	}
+	assert.Nil(t, j.Close())
+
+	j, err = New(5, path)
+	assert.Nil(t, err)
+	assert.Len(t, j.Query(Filter{}), 3)
+	j.Record(Event{Kind: KindAlert, Machine: "machine1"})
+	events := j.Query(Filter{})
+	assert.Equal(t, uint64(3), events[3].ID)
+
+	// Test case 2: the file is compacted once it holds twice the capacity
+	for i := 0; i < 10; i++ {
+		j.Record(Event{Kind: KindTransition, Machine: "machine2"})
+	}
+	assert.LessOrEqual(t, countLines(t, path), 10)
+	assert.Nil(t, j.Close())
+
+	// Test case 3: a smaller capacity only reloads the latest events
+	j, err = New(2, path)
+	assert.Nil(t, err)
+	events = j.Query(Filter{})
+	assert.Len(t, events, 2)
+	assert.Equal(t, uint64(13), events[1].ID)
+	assert.Equal(t, 2, countLines(t, path))
+	assert.Nil(t, j.Close())
+}
+
+func TestPackageJournal(t *testing.T) {
+	defer func() { journal = nil }()
+
+	// Test case 1: events are dropped until Init is called
+	Record(Event{Kind: KindTransition, Machine: "machine1"})
+	assert.Empty(t, Query(Filter{}))
+
+	// Test case 2: Init sets the journal used by Record and Query
+	assert.Nil(t, Init(10, ""))
+	Record(Event{Kind: KindTransition, Machine: "machine1"})
+	assert.Len(t, Query(Filter{}), 1)
+	assert.Nil(t, Close())
+}
+
+func countLines(t *testing.T, path string) int {
+	file, err := os.Open(path)
+	assert.Nil(t, err)
+	defer file.Close()
+
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines++
+	}
+	return lines
+}
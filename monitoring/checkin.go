@@ -0,0 +1,291 @@
+package monitoring
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/equals215/deepsentinel/alerting"
+	"github.com/equals215/deepsentinel/alerting/alert"
+	"github.com/equals215/deepsentinel/config"
+	"github.com/equals215/deepsentinel/journal"
+	"github.com/robfig/cron/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+// Check-in signals sent by the jobs
+const (
+	SignalStart   = "start"
+	SignalSuccess = "success"
+	SignalFail    = "fail"
+)
+
+// Check-in statuses
+const (
+	CheckinNew     = "new"
+	CheckinUp      = "up"
+	CheckinRunning = "running"
+	CheckinLate    = "late"
+	CheckinMissed  = "missed"
+	CheckinFailed  = "failed"
+	CheckinTimeout = "timeout"
+)
+
+var (
+	// ErrCheckinNotFound is returned when a job checks in under a name that isn't configured
+	ErrCheckinNotFound = errors.New("check-in not found")
+	// ErrInvalidSignal is returned for a signal other than start, success or fail
+	ErrInvalidSignal = errors.New("signal must be start, success or fail")
+)
+
+// checkinObject is the state of a job checking in
+// The next run is expected on the schedule after the latest success or failure, or after the server started
+// alerted is the reason of the alert raised since the latest success, empty when none was
+type checkinObject struct {
+	config      config.CheckinConfig
+	schedule    cron.Schedule
+	grace       time.Duration
+	maxDuration time.Duration
+	status      string
+	reference   time.Time
+	lastSuccess time.Time
+	lastFailure time.Time
+	lastStart   time.Time
+	running     bool
+	alerted     string
+}
+
+// CheckinInfo is a snapshot of a check-in
+type CheckinInfo struct {
+	Name        string    `json:"name"`
+	Schedule    string    `json:"schedule"`
+	Grace       string    `json:"grace"`
+	MaxDuration string    `json:"maxDuration,omitempty"`
+	Status      string    `json:"status"`
+	NextRun     time.Time `json:"nextRun"`
+	LastSuccess time.Time `json:"lastSuccess,omitempty"`
+	LastFailure time.Time `json:"lastFailure,omitempty"`
+	LastStart   time.Time `json:"lastStart,omitempty"`
+}
+
+// checkins holds the configured check-ins in the configuration order
+var checkins = struct {
+	sync.Mutex
+	byName map[string]*checkinObject
+	order  []string
+}{
+	byName: make(map[string]*checkinObject),
+}
+
+// initCheckins creates the configured check-ins, expecting their first run on schedule after now
+func initCheckins(now time.Time) {
+	checkins.Lock()
+	defer checkins.Unlock()
+
+	checkins.byName = make(map[string]*checkinObject)
+	checkins.order = nil
+	for _, checkinConfig := range config.Server.Checkins {
+		schedule, err := checkinConfig.ParsedSchedule()
+		if err != nil {
+			log.WithField("checkin", checkinConfig.Name).WithError(err).Error("Invalid check-in schedule, skipping")
+			continue
+		}
+		grace, maxDuration, err := checkinConfig.Durations()
+		if err != nil {
+			log.WithField("checkin", checkinConfig.Name).WithError(err).Error("Invalid check-in durations, skipping")
+			continue
+		}
+		checkins.byName[checkinConfig.Name] = &checkinObject{
+			config:      checkinConfig,
+			schedule:    schedule,
+			grace:       grace,
+			maxDuration: maxDuration,
+			status:      CheckinNew,
+			reference:   now,
+		}
+		checkins.order = append(checkins.order, checkinConfig.Name)
+	}
+}
+
+// Checkin handles a signal sent by the job name at the given time
+// The alert or resolution it raises is delivered in the background
+func Checkin(name, signal string, at time.Time) error {
+	deliver, err := checkin(name, signal, at)
+	if deliver != nil {
+		deliverInBackground("checkins", deliver)
+	}
+	return err
+}
+
+// checkin updates the check-in name and returns the delivery of the alert or resolution it raised, if any
+func checkin(name, signal string, at time.Time) (func(), error) {
+	checkins.Lock()
+	defer checkins.Unlock()
+
+	c, ok := checkins.byName[name]
+	if !ok {
+		return nil, ErrCheckinNotFound
+	}
+
+	var deliver func()
+	switch signal {
+	case SignalStart:
+		c.running = true
+		c.lastStart = at
+		deliver = c.transition(CheckinRunning)
+	case SignalSuccess:
+		c.running = false
+		c.lastSuccess = at
+		c.reference = at
+		deliver = c.transition(CheckinUp)
+	case SignalFail:
+		c.running = false
+		c.lastFailure = at
+		c.reference = at
+		deliver = c.transition(CheckinFailed)
+	default:
+		return nil, ErrInvalidSignal
+	}
+
+	log.WithFields(log.Fields{
+		"checkin": name,
+		"signal":  signal,
+	}).Debug("Check-in received")
+	return deliver, nil
+}
+
+// evaluateCheckins alerts about the jobs that missed their run or run for too long
+// The alerts are delivered in the background, once the checkins lock is released
+func evaluateCheckins(now time.Time) {
+	checkins.Lock()
+	deliveries := make([]func(), 0)
+	for _, name := range checkins.order {
+		if deliver := checkins.byName[name].evaluate(now); deliver != nil {
+			deliveries = append(deliveries, deliver)
+		}
+	}
+	checkins.Unlock()
+
+	if len(deliveries) > 0 {
+		deliverInBackground("checkins", func() {
+			for _, deliver := range deliveries {
+				deliver()
+			}
+		})
+	}
+}
+
+// evaluate updates the status of a job that didn't signal anything and returns the delivery of its alert, if any
+// A failed job stays failed until its next success, caller must hold the checkins lock
+func (c *checkinObject) evaluate(now time.Time) func() {
+	if c.status == CheckinFailed {
+		return nil
+	}
+	if c.running {
+		if now.After(c.runDeadline()) {
+			return c.transition(CheckinTimeout)
+		}
+		return nil
+	}
+
+	next := c.schedule.Next(c.reference)
+	switch {
+	case now.After(next.Add(c.grace)):
+		return c.transition(CheckinMissed)
+	case now.After(next):
+		return c.transition(CheckinLate)
+	}
+	return nil
+}
+
+// runDeadline returns the time the current run must be done by
+// A job without max-duration must be done when its next run is due, once grace elapsed, so that a run that crashed
+// without signaling fail doesn't stay running forever
+func (c *checkinObject) runDeadline() time.Time {
+	if c.maxDuration > 0 {
+		return c.lastStart.Add(c.maxDuration)
+	}
+	return c.schedule.Next(c.lastStart).Add(c.grace)
+}
+
+// transition changes the status of the check-in and returns the delivery of the alert or resolution it raised, if any
+// Caller must hold the checkins lock
+func (c *checkinObject) transition(status string) func() {
+	from := c.status
+	if from == status {
+		return nil
+	}
+	c.status = status
+	log.WithFields(log.Fields{
+		"checkin": c.config.Name,
+		"from":    from,
+	}).Infof("Check-in is now %s", status)
+	journal.Record(journal.Event{
+		Kind:    journal.KindTransition,
+		Checkin: c.config.Name,
+		From:    from,
+		To:      status,
+	})
+
+	reasons := map[string]string{
+		CheckinMissed:  alert.ReasonMissed,
+		CheckinFailed:  alert.ReasonFail,
+		CheckinTimeout: alert.ReasonTimeout,
+	}
+	if reason, ok := reasons[status]; ok {
+		c.alerted = reason
+		return c.delivery(journal.KindAlert, alerting.ServerAlert, c.alert(reason))
+	}
+	// A job starting again after an alert is only resolved once it succeeds
+	if status == CheckinUp && c.alerted != "" {
+		deliver := c.delivery(journal.KindResolution, alerting.ServerResolve, c.alert(c.alerted))
+		c.alerted = ""
+		return deliver
+	}
+	return nil
+}
+
+func (c *checkinObject) alert(reason string) *alert.Alert {
+	return alert.New("checkin", c.config.Name, c.config.Severity).WithReason(reason).WithLastNormal(c.lastSuccess)
+}
+
+// delivery returns the function sending a through send and journaling the outcome, it doesn't need the checkins lock
+func (c *checkinObject) delivery(kind string, send func(*alert.Alert) *alerting.Delivery, a *alert.Alert) func() {
+	name := c.config.Name
+	return func() {
+		journal.Record(journal.Event{
+			Kind:            kind,
+			Checkin:         name,
+			SinceLastNormal: sinceLastNormal(a.LastNormal),
+			Alert:           deliveryRecord(a, send(a)),
+		})
+	}
+}
+
+// CheckinAlerting returns true when status is alerted
+func CheckinAlerting(status string) bool {
+	return status == CheckinMissed || status == CheckinFailed || status == CheckinTimeout
+}
+
+// Checkins returns a snapshot of every check-in in the configuration order
+func Checkins() []*CheckinInfo {
+	checkins.Lock()
+	defer checkins.Unlock()
+
+	infos := make([]*CheckinInfo, 0, len(checkins.order))
+	for _, name := range checkins.order {
+		c := checkins.byName[name]
+		infos = append(infos, &CheckinInfo{
+			Name:        name,
+			Schedule:    c.config.Schedule,
+			Grace:       c.config.Grace,
+			MaxDuration: c.config.MaxDuration,
+			Status:      c.status,
+			NextRun:     c.schedule.Next(c.reference),
+			LastSuccess: c.lastSuccess,
+			LastFailure: c.lastFailure,
+			LastStart:   c.lastStart,
+		})
+	}
+	return infos
+}
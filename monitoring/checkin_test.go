@@ -0,0 +1,101 @@
+package monitoring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/equals215/deepsentinel/config"
+	"github.com/equals215/deepsentinel/journal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckins(t *testing.T) {
+	config.Server = &config.ServerConfig{
+		Checkins: []config.CheckinConfig{
+			{Name: "backup", Schedule: "0 2 * * *", Grace: "30m", MaxDuration: "1h", Severity: "high"},
+			{Name: "sync", Schedule: "every 1h", Grace: "5m", Severity: "low"},
+		},
+	}
+	assert.Nil(t, journal.Init(100, ""))
+	defer journal.Close()
+
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	initCheckins(start)
+	status := func(name string) string {
+		for _, checkin := range Checkins() {
+			if checkin.Name == name {
+				return checkin.Status
+			}
+		}
+		return ""
+	}
+
+	// Test case 1: the first run is expected on schedule after the server started
+	infos := Checkins()
+	assert.Len(t, infos, 2)
+	assert.Equal(t, time.Date(2024, 5, 2, 2, 0, 0, 0, time.Local), infos[0].NextRun)
+	assert.Equal(t, start.Add(time.Hour), infos[1].NextRun)
+	evaluateCheckins(start.Add(30 * time.Minute))
+	assert.Equal(t, CheckinNew, status("sync"))
+
+	// Test case 2: a job is late after its expected run then missed after the grace period
+	evaluateCheckins(start.Add(61 * time.Minute))
+	assert.Equal(t, CheckinLate, status("sync"))
+	evaluateCheckins(start.Add(66 * time.Minute))
+	assert.Equal(t, CheckinMissed, status("sync"))
+	waitDeliveries()
+	events := journal.Query(journal.Filter{Checkin: "sync", Limit: 1})
+	assert.Equal(t, journal.KindAlert, events[0].Kind)
+	assert.Equal(t, "missed", events[0].Alert.Reason)
+	assert.Equal(t, "low", events[0].Alert.Severity)
+
+	// Test case 3: a success resolves the alert and moves the next expected run
+	at := start.Add(70 * time.Minute)
+	assert.NoError(t, Checkin("sync", SignalSuccess, at))
+	assert.Equal(t, CheckinUp, status("sync"))
+	assert.Equal(t, at.Add(time.Hour), Checkins()[1].NextRun)
+	waitDeliveries()
+	events = journal.Query(journal.Filter{Checkin: "sync", Limit: 1})
+	assert.Equal(t, journal.KindResolution, events[0].Kind)
+
+	// Test case 4: a job running longer than its maximum duration times out
+	runStart := time.Date(2024, 5, 2, 2, 0, 0, 0, time.Local)
+	assert.NoError(t, Checkin("backup", SignalStart, runStart))
+	evaluateCheckins(runStart.Add(50 * time.Minute))
+	assert.Equal(t, CheckinRunning, status("backup"))
+	evaluateCheckins(runStart.Add(61 * time.Minute))
+	assert.Equal(t, CheckinTimeout, status("backup"))
+
+	// Test case 5: a failed job stays failed until its next success
+	assert.NoError(t, Checkin("backup", SignalFail, runStart.Add(62*time.Minute)))
+	assert.Equal(t, CheckinFailed, status("backup"))
+	evaluateCheckins(runStart.Add(48 * time.Hour))
+	assert.Equal(t, CheckinFailed, status("backup"))
+	waitDeliveries()
+	events = journal.Query(journal.Filter{Checkin: "backup"})
+	alerts := 0
+	for _, event := range events {
+		if event.Kind == journal.KindAlert {
+			alerts++
+		}
+	}
+	assert.Equal(t, 2, alerts)
+	assert.NoError(t, Checkin("backup", SignalSuccess, runStart.Add(49*time.Hour)))
+	assert.Equal(t, CheckinUp, status("backup"))
+
+	// Test case 6: a job without max-duration that never finishes times out once its next run is missed
+	syncStart := at.Add(time.Hour)
+	assert.NoError(t, Checkin("sync", SignalStart, syncStart))
+	evaluateCheckins(syncStart.Add(64 * time.Minute))
+	assert.Equal(t, CheckinRunning, status("sync"))
+	evaluateCheckins(syncStart.Add(66 * time.Minute))
+	assert.Equal(t, CheckinTimeout, status("sync"))
+	waitDeliveries()
+	events = journal.Query(journal.Filter{Checkin: "sync", Limit: 1})
+	assert.Equal(t, journal.KindAlert, events[0].Kind)
+	assert.Equal(t, "timeout", events[0].Alert.Reason)
+
+	// Test case 7: unknown check-ins and signals are rejected
+	assert.ErrorIs(t, Checkin("unknown", SignalSuccess, start), ErrCheckinNotFound)
+	assert.ErrorIs(t, Checkin("sync", "reboot", start), ErrInvalidSignal)
+}
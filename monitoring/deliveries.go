@@ -0,0 +1,53 @@
+package monitoring
+
+import "sync"
+
+// deliveries chains the alerts delivered in the background, by key
+// Alerts sharing a key are delivered in the order they were raised, so that a resolution never overtakes its alert,
+// while slow providers block neither the probes, the monitoring loop, the requests nor the other keys
+var deliveries = struct {
+	sync.Mutex
+	last map[string]chan struct{}
+}{
+	last: make(map[string]chan struct{}),
+}
+
+// deliverInBackground runs deliver once every delivery queued before it under key is done
+func deliverInBackground(key string, deliver func()) {
+	deliveries.Lock()
+	previous := deliveries.last[key]
+	done := make(chan struct{})
+	deliveries.last[key] = done
+	deliveries.Unlock()
+
+	go func() {
+		if previous != nil {
+			<-previous
+		}
+		deliver()
+
+		deliveries.Lock()
+		if deliveries.last[key] == done {
+			delete(deliveries.last, key)
+		}
+		deliveries.Unlock()
+		close(done)
+	}()
+}
+
+// waitDeliveries returns once every delivery queued so far, under any key, is done
+func waitDeliveries() {
+	for {
+		var pending chan struct{}
+		deliveries.Lock()
+		for _, last := range deliveries.last {
+			pending = last
+			break
+		}
+		deliveries.Unlock()
+		if pending == nil {
+			return
+		}
+		<-pending
+	}
+}
@@ -0,0 +1,68 @@
+package monitoring
+
+import (
+	"path"
+	"time"
+
+	"github.com/equals215/deepsentinel/alerting/alert"
+	"github.com/equals215/deepsentinel/config"
+	"github.com/equals215/deepsentinel/journal"
+	log "github.com/sirupsen/logrus"
+)
+
+// publishStatus mirrors the probe status for the probes depending on it, caller must hold the probe lock
+// Dependents read the mirror without taking the probe lock so that two probes never wait on each other
+func (p *probeObject) publishStatus() {
+	previous := probeStatus(p.published.Swap(int32(p.status)))
+	if previous >= failed && p.status < failed {
+		p.recovered.Store(time.Now().UnixNano())
+	}
+}
+
+// unreachableVia returns the failed parent the machine, or service when it isn't empty, depends on
+// A recovered parent still counts as failed for one inactivity delay of the probe, leaving it time to report again
+// It returns an empty string when every parent is up, caller must hold the probe lock
+func (p *probeObject) unreachableVia(service string) string {
+	for _, dependency := range config.Server.Dependencies {
+		if dependency.Parent == p.name {
+			continue
+		}
+		if matched, _ := path.Match(dependency.Match, p.name); !matched {
+			continue
+		}
+		if dependency.Service != "" {
+			if matched, _ := path.Match(dependency.Service, service); service == "" || !matched {
+				continue
+			}
+		}
+
+		parent, ok := lookupProbe(dependency.Parent)
+		if !ok {
+			continue
+		}
+		recovered := time.Unix(0, parent.recovered.Load())
+		if probeStatus(parent.published.Load()) >= failed || time.Since(recovered) < p.thresholds.inactivityDelay {
+			return dependency.Parent
+		}
+	}
+	return ""
+}
+
+// suppressUnreachable holds back a until the parent recovers, caller must hold the probe lock
+func (p *probeObject) suppressUnreachable(service, parent string, a *alert.Alert) {
+	log.WithFields(log.Fields{
+		"probe":     p.name,
+		"parent":    parent,
+		"component": a.Component,
+		"severity":  a.Severity,
+	}).Info("Probe is unreachable, alert suppressed")
+	p.suppressed[a.DedupKey()] = &suppressedAlert{service: service, alert: a}
+	journal.Record(journal.Event{
+		Kind:            journal.KindAlert,
+		Machine:         p.name,
+		Service:         service,
+		SinceLastNormal: sinceLastNormal(a.LastNormal),
+		Alert:           deliveryRecord(a, nil),
+		Message:         "unreachable, parent " + parent + " is failed",
+	})
+}
@@ -0,0 +1,80 @@
+package monitoring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/equals215/deepsentinel/config"
+	"github.com/equals215/deepsentinel/journal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDependencies(t *testing.T) {
+	config.Server = &config.ServerConfig{
+		ProbeInactivityDelay:             "1ms",
+		DegradedToFailedThreshold:        1,
+		FailedToAlertedLowThreshold:      1,
+		AlertedLowToAlertedHighThreshold: 1,
+		Dependencies: []config.DependencyConfig{
+			{Match: "web-*", Parent: "gateway"},
+			{Match: "app", Service: "db-*", Parent: "db"},
+		},
+	}
+	assert.Nil(t, journal.Init(100, ""))
+	defer journal.Close()
+
+	probes := make(map[string]*probeObject)
+	for _, machine := range []string{"gateway", "web-1", "app", "db"} {
+		probes[machine] = makeProbe(&Payload{Machine: machine, Timestamp: time.Now()})
+		assert.True(t, registerProbe(probes[machine]))
+	}
+	defer func() {
+		for machine := range probes {
+			unregisterProbe(machine)
+		}
+	}()
+	gateway, web := probes["gateway"], probes["web-1"]
+
+	// Test case 1: a machine whose parent is up alerts
+	assert.Equal(t, "", web.unreachableVia(""))
+
+	// Test case 2: once the parent failed the machine is unreachable and only the parent alerts
+	for i := 0; i < 3; i++ {
+		gateway.timerIncrement()
+		web.timerIncrement()
+	}
+	assert.Equal(t, alertedLow, gateway.status)
+	assert.Equal(t, alertedLow, web.status)
+	assert.Equal(t, "gateway", web.unreachableVia(""))
+	info, _ := Probe("web-1")
+	assert.Equal(t, "gateway", info.UnreachableVia)
+	events := journal.Query(journal.Filter{Machine: "web-1", Limit: 1})
+	assert.Equal(t, journal.KindAlert, events[0].Kind)
+	assert.True(t, events[0].Alert.Suppressed)
+	assert.Contains(t, events[0].Message, "gateway")
+	assert.Len(t, web.suppressed, 1)
+	waitDeliveries()
+	events = journal.Query(journal.Filter{Machine: "gateway", Limit: 1})
+	assert.False(t, events[0].Alert.Suppressed)
+
+	// Test case 3: a service only depends on the parent of its dependency
+	assert.Equal(t, "", probes["app"].unreachableVia("db-conn"))
+	probes["db"].status = failed
+	probes["db"].publishStatus()
+	assert.Equal(t, "db", probes["app"].unreachableVia("db-conn"))
+	assert.Equal(t, "", probes["app"].unreachableVia("nginx"))
+	assert.Equal(t, "", probes["app"].unreachableVia(""))
+
+	// Test case 4: the machine is unreachable for one inactivity delay after its parent recovered
+	gateway.reset()
+	assert.Equal(t, "gateway", web.unreachableVia(""))
+	time.Sleep(2 * time.Millisecond)
+	assert.Equal(t, "", web.unreachableVia(""))
+
+	// Test case 5: the suppressed alert is sent once the machine is reachable and still failing
+	web.sendSuppressed()
+	assert.Len(t, web.suppressed, 0)
+	waitDeliveries()
+	events = journal.Query(journal.Filter{Machine: "web-1", Limit: 1})
+	assert.False(t, events[0].Alert.Suppressed)
+}
@@ -0,0 +1,92 @@
+package monitoring
+
+import (
+	"time"
+
+	"github.com/equals215/deepsentinel/alerting"
+	"github.com/equals215/deepsentinel/alerting/alert"
+	"github.com/equals215/deepsentinel/journal"
+)
+
+// recordTransition journals a status change of the machine, or of service when it isn't empty
+func (p *probeObject) recordTransition(service, from, to string, counter int, lastNormal time.Time) {
+	journal.Record(journal.Event{
+		Kind:            journal.KindTransition,
+		Machine:         p.name,
+		Service:         service,
+		From:            from,
+		To:              to,
+		Counter:         counter,
+		SinceLastNormal: sinceLastNormal(lastNormal),
+	})
+}
+
+// recordDelivery journals an alert or a resolution with the outcome of each provider, caller must hold the probe lock
+// A nil delivery stands for an alert suppressed by a silence
+func (p *probeObject) recordDelivery(kind, service string, a *alert.Alert, delivery *alerting.Delivery) {
+	event := p.deliveryEvent(kind, service, a)
+	event.Alert = deliveryRecord(a, delivery)
+	journal.Record(event)
+}
+
+// deliver sends a through send in the background then journals the outcome, caller must hold the probe lock
+// The alerts of a probe are delivered in the order they were raised, without holding the probe
+func (p *probeObject) deliver(kind, service string, a *alert.Alert, send func(*alert.Alert) *alerting.Delivery) {
+	event := p.deliveryEvent(kind, service, a)
+	deliverInBackground("probe/"+p.name, func() {
+		event.Alert = deliveryRecord(a, send(a))
+		journal.Record(event)
+	})
+}
+
+// deliveryEvent returns the journal event of an alert or a resolution, caller must hold the probe lock
+func (p *probeObject) deliveryEvent(kind, service string, a *alert.Alert) journal.Event {
+	event := journal.Event{
+		Kind:            kind,
+		Machine:         p.name,
+		Service:         service,
+		SinceLastNormal: sinceLastNormal(a.LastNormal),
+	}
+	if service == "" {
+		event.Counter = p.counter
+	}
+	return event
+}
+
+// deliveryRecord returns the journaled outcome of a delivery, a nil delivery stands for a suppressed alert
+func deliveryRecord(a *alert.Alert, delivery *alerting.Delivery) *journal.AlertRecord {
+	record := &journal.AlertRecord{
+		Severity:   a.Severity,
+		Reason:     a.Reason,
+		Suppressed: delivery == nil,
+	}
+	if delivery != nil {
+		record.Policy = delivery.Policy
+		record.Delivered = delivery.Delivered
+		for _, result := range delivery.Results {
+			providerRecord := journal.ProviderRecord{Provider: result.Provider}
+			if result.Error != nil {
+				providerRecord.Error = result.Error.Error()
+			}
+			record.Providers = append(record.Providers, providerRecord)
+		}
+	}
+	return record
+}
+
+// recordSilence journals the start or the end of a silence
+func (p *probeObject) recordSilence(service, message string) {
+	journal.Record(journal.Event{
+		Kind:    journal.KindSilence,
+		Machine: p.name,
+		Service: service,
+		Message: message,
+	})
+}
+
+func sinceLastNormal(lastNormal time.Time) string {
+	if lastNormal.IsZero() {
+		return ""
+	}
+	return time.Since(lastNormal).Round(time.Second).String()
+}
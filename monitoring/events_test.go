@@ -0,0 +1,68 @@
+package monitoring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/equals215/deepsentinel/config"
+	"github.com/equals215/deepsentinel/journal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvents(t *testing.T) {
+	config.Server = &config.ServerConfig{
+		ProbeInactivityDelay:             "2s",
+		DegradedToFailedThreshold:        1,
+		FailedToAlertedLowThreshold:      1,
+		AlertedLowToAlertedHighThreshold: 1,
+	}
+	assert.Nil(t, journal.Init(100, ""))
+	defer journal.Close()
+
+	probe := makeProbe(&Payload{Machine: "machine1", Timestamp: time.Now()})
+
+	// Test case 1: machine transitions and alerts are journaled
+	probe.timerIncrement()
+	probe.timerIncrement()
+	events := journal.Query(journal.Filter{Machine: "machine1"})
+	assert.Len(t, events, 2)
+	assert.Equal(t, journal.KindTransition, events[0].Kind)
+	assert.Equal(t, "normal", events[0].From)
+	assert.Equal(t, "degraded", events[0].To)
+	assert.Equal(t, "failed", events[1].To)
+	assert.Equal(t, 1, events[1].Counter)
+
+	probe.timerIncrement()
+	waitDeliveries()
+	events = journal.Query(journal.Filter{Machine: "machine1"})
+	assert.Len(t, events, 4)
+	assert.Equal(t, "alertedLow", events[2].To)
+	assert.Equal(t, journal.KindAlert, events[3].Kind)
+	assert.Equal(t, "low", events[3].Alert.Severity)
+	assert.False(t, events[3].Alert.Delivered)
+
+	// Test case 2: service transitions are journaled
+	probe.workServices(&Payload{Machine: "machine1", Timestamp: time.Now(), Services: map[string]string{"nginx": "pass"}})
+	probe.workServices(&Payload{Machine: "machine1", Timestamp: time.Now(), Services: map[string]string{"nginx": "fail"}})
+	events = journal.Query(journal.Filter{Service: "nginx"})
+	assert.Len(t, events, 1)
+	assert.Equal(t, "pass", events[0].From)
+	assert.Equal(t, "fail", events[0].To)
+
+	// Test case 3: the recovery and the resolution are journaled
+	probe.reset()
+	waitDeliveries()
+	events = journal.Query(journal.Filter{Machine: "machine1", Limit: 2})
+	assert.Equal(t, "normal", events[0].To)
+	assert.Equal(t, "alertedLow", events[0].From)
+	assert.Equal(t, journal.KindResolution, events[1].Kind)
+
+	// Test case 4: a suppressed alert is journaled as such
+	probe.silences[""] = &Silence{Machine: "machine1"}
+	probe.status = failed
+	probe.counter = 1
+	probe.timerIncrement()
+	waitDeliveries()
+	events = journal.Query(journal.Filter{Machine: "machine1", Limit: 1})
+	assert.True(t, events[0].Alert.Suppressed)
+}
@@ -0,0 +1,95 @@
+package monitoring
+
+import (
+	"github.com/equals215/deepsentinel/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// flapHistory returns the number of latest reports flap detection needs to keep
+func flapHistory() int {
+	flap := config.Server.FlapDetection
+	if !flap.Enabled() {
+		return 0
+	}
+	return max(flap.Window, flap.RecoveryPasses)
+}
+
+// updateFlapping flags the services of the head node that flap, caller must hold the timeserie lock
+// A service starts flapping when it changed status too often over the flap window
+// and stops once it passed enough consecutive reports
+func (p *probeObject) updateFlapping() {
+	flap := config.Server.FlapDetection
+	if !flap.Enabled() {
+		return
+	}
+
+	for service, status := range p.timeSerie.head.services {
+		if p.wasFlapping(service) {
+			recoveryPasses := max(flap.RecoveryPasses, 1)
+			recovered := status.status == pass && p.consecutivePasses(service, recoveryPasses) >= recoveryPasses
+			status.flapping = !recovered
+			if recovered {
+				log.WithFields(log.Fields{
+					"probe":   p.name,
+					"service": service,
+				}).Info("Service recovered from flapping")
+				p.recordTransition(service, "flapping", status.status.String(), status.count, p.serviceLastNormal(service))
+			}
+			continue
+		}
+
+		changes := p.statusChanges(service, flap.Window)
+		if changes >= flap.Threshold {
+			status.flapping = true
+			log.WithFields(log.Fields{
+				"probe":   p.name,
+				"service": service,
+				"changes": changes,
+				"window":  flap.Window,
+			}).Warn("Service is flapping")
+			p.recordTransition(service, status.status.String(), "flapping", status.count, p.serviceLastNormal(service))
+		}
+	}
+}
+
+// wasFlapping returns true when service was flapping on the previous report, caller must hold the timeserie lock
+func (p *probeObject) wasFlapping(service string) bool {
+	if p.timeSerie.head == nil || p.timeSerie.head.previous == nil {
+		return false
+	}
+	previous, ok := p.timeSerie.head.previous.services[service]
+	return ok && previous.flapping
+}
+
+// statusChanges counts the status changes of service over its last window reports, caller must hold the timeserie lock
+func (p *probeObject) statusChanges(service string, window int) int {
+	changes := 0
+	seen := 0
+	var last *serviceStatus
+
+	for node := p.timeSerie.head; node != nil && seen < window; node = node.previous {
+		status, ok := node.services[service]
+		if !ok {
+			continue
+		}
+		if last != nil && last.status != status.status {
+			changes++
+		}
+		last = status
+		seen++
+	}
+	return changes
+}
+
+// consecutivePasses counts the latest consecutive pass reports of service up to limit, caller must hold the timeserie lock
+func (p *probeObject) consecutivePasses(service string, limit int) int {
+	passes := 0
+	for node := p.timeSerie.head; node != nil && passes < limit; node = node.previous {
+		status, ok := node.services[service]
+		if !ok || status.status != pass {
+			break
+		}
+		passes++
+	}
+	return passes
+}
@@ -0,0 +1,82 @@
+package monitoring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/equals215/deepsentinel/config"
+	"github.com/equals215/deepsentinel/journal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlapping(t *testing.T) {
+	config.Server = &config.ServerConfig{
+		ProbeInactivityDelay:             "2s",
+		DegradedToFailedThreshold:        10,
+		FailedToAlertedLowThreshold:      20,
+		AlertedLowToAlertedHighThreshold: 30,
+		FlapDetection: config.FlapDetectionConfig{
+			Window:         6,
+			Threshold:      4,
+			RecoveryPasses: 3,
+		},
+	}
+	assert.Nil(t, journal.Init(100, ""))
+	defer journal.Close()
+
+	probe := makeProbe(&Payload{Machine: "machine1", Timestamp: time.Now()})
+	report := func(status string) {
+		probe.workServices(&Payload{Machine: "machine1", Timestamp: time.Now(), Services: map[string]string{"nginx": status}})
+	}
+	alerts := func() []journal.Event {
+		events := make([]journal.Event, 0)
+		waitDeliveries()
+		for _, event := range journal.Query(journal.Filter{Service: "nginx"}) {
+			if event.Alert != nil {
+				events = append(events, event)
+			}
+		}
+		return events
+	}
+
+	// Test case 1: a service alternating pass and fail flaps once it reaches the threshold
+	for _, status := range []string{"pass", "fail", "pass", "fail"} {
+		report(status)
+	}
+	assert.Equal(t, 3, probe.statusChanges("nginx", 6))
+	assert.False(t, probe.timeSerie.head.services["nginx"].flapping)
+	assert.Len(t, alerts(), 0)
+	report("pass")
+	assert.True(t, probe.timeSerie.head.services["nginx"].flapping)
+	events := alerts()
+	assert.Len(t, events, 1)
+	assert.Equal(t, journal.KindAlert, events[0].Kind)
+	assert.Equal(t, "flapping", events[0].Alert.Reason)
+	assert.Equal(t, "low", events[0].Alert.Severity)
+	assert.Equal(t, "deepsentinel-service-machine1-nginx-flapping", probe.flappingAlert("nginx").DedupKey())
+
+	// Test case 2: a flapping service stays flapping, without new alerts, until enough consecutive passes
+	report("fail")
+	report("pass")
+	report("pass")
+	assert.True(t, probe.timeSerie.head.services["nginx"].flapping)
+	assert.Len(t, alerts(), 1)
+	info := probe.info()
+	assert.True(t, info.Services["nginx"].Flapping)
+
+	// Test case 3: the recovery resolves the flapping alert
+	report("pass")
+	assert.False(t, probe.timeSerie.head.services["nginx"].flapping)
+	events = alerts()
+	assert.Len(t, events, 2)
+	assert.Equal(t, journal.KindResolution, events[1].Kind)
+	assert.Equal(t, "flapping", events[1].Alert.Reason)
+
+	// Test case 4: trimming keeps the reports flap detection looks back at
+	for i := 0; i < trimTimeSeriesThreshold; i++ {
+		probe.storePayload(&Payload{Machine: "machine1", Timestamp: time.Now(), Services: map[string]string{"nginx": "pass"}})
+	}
+	probe.trimTimeSerie()
+	assert.Equal(t, 6, probe.timeSerie.size)
+	assert.Equal(t, 3, probe.consecutivePasses("nginx", 3))
+}
@@ -0,0 +1,243 @@
+package monitoring
+
+import (
+	"maps"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/equals215/deepsentinel/alerting"
+	"github.com/equals215/deepsentinel/alerting/alert"
+	"github.com/equals215/deepsentinel/config"
+	"github.com/equals215/deepsentinel/journal"
+	log "github.com/sirupsen/logrus"
+)
+
+// groupState is the latest evaluation of a group rule
+type groupState struct {
+	triggered bool
+	since     time.Time
+	probes    []string
+	affected  []string
+}
+
+// groups holds the state of the group rules, evaluated by the monitoring loop
+var groups = struct {
+	sync.Mutex
+	states map[string]*groupState
+}{
+	states: make(map[string]*groupState),
+}
+
+// GroupInfo is a snapshot of a group rule and of the probes it selects
+// Silenced probes aren't part of any group
+type GroupInfo struct {
+	Name      string            `json:"name"`
+	Selector  map[string]string `json:"selector"`
+	Status    string            `json:"status"`
+	Percent   int               `json:"percent"`
+	Severity  string            `json:"severity"`
+	Probes    []string          `json:"probes"`
+	Affected  []string          `json:"affected"`
+	Triggered bool              `json:"triggered"`
+	Since     time.Time         `json:"since,omitempty"`
+}
+
+// resolveLabels returns the labels of machine
+// Labels of every matching machine-labels entry are merged, then overridden by the labels declared by the agent
+func resolveLabels(machine string, declared map[string]string) map[string]string {
+	resolved := make(map[string]string)
+	for _, machineLabels := range config.Server.MachineLabels {
+		if matched, _ := path.Match(machineLabels.Match, machine); matched {
+			maps.Copy(resolved, machineLabels.Labels)
+		}
+	}
+	maps.Copy(resolved, declared)
+	return resolved
+}
+
+// label updates the labels declared by the agent, caller must hold the probe lock
+// It returns true when the declared labels changed and must be persisted
+func (p *probeObject) label(declared map[string]string) bool {
+	declared = config.NormalizeLabels(declared)
+	if maps.Equal(declared, p.declaredLabels) {
+		return false
+	}
+
+	p.declaredLabels = declared
+	p.labels = resolveLabels(p.name, declared)
+	log.WithFields(log.Fields{
+		"probe":  p.name,
+		"labels": p.labels,
+	}).Info("Probe labels changed")
+	return true
+}
+
+// selects returns true when labels hold every label of selector
+func selects(selector, labels map[string]string) bool {
+	for name, value := range selector {
+		if labelValue, ok := labels[name]; !ok || labelValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+// groupMember is the part of a probe the group rules look at
+type groupMember struct {
+	name   string
+	status probeStatus
+	labels map[string]string
+}
+
+// affected returns true when the member is in the status counted by rule
+func (m groupMember) affected(rule config.GroupRuleConfig) bool {
+	if rule.Status == config.GroupStatusSilent {
+		return m.status >= degraded
+	}
+	return m.status >= failed
+}
+
+// evaluateGroups computes the state of every group rule and alerts or resolves the ones that changed
+func evaluateGroups() {
+	if len(config.Server.GroupRules) == 0 {
+		return
+	}
+
+	members := make([]groupMember, 0)
+	for _, probe := range registeredProbes() {
+		probe.Lock()
+		if !probe.silenced("") {
+			members = append(members, groupMember{
+				name:   probe.name,
+				status: probe.status,
+				labels: probe.labels,
+			})
+		}
+		probe.Unlock()
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].name < members[j].name
+	})
+
+	transitions := updateGroups(members)
+	if len(transitions) > 0 {
+		deliverInBackground("groups", func() {
+			for _, transition := range transitions {
+				transition.deliver()
+			}
+		})
+	}
+}
+
+// groupTransition is a group rule that changed state, its alert or resolution is delivered outside of the groups lock
+type groupTransition struct {
+	rule      config.GroupRuleConfig
+	triggered bool
+	affected  int
+}
+
+// updateGroups computes the state of every group rule from members and returns the rules that changed state
+func updateGroups(members []groupMember) []*groupTransition {
+	groups.Lock()
+	defer groups.Unlock()
+
+	transitions := make([]*groupTransition, 0)
+	for _, rule := range config.Server.GroupRules {
+		state, ok := groups.states[rule.Name]
+		if !ok {
+			state = &groupState{}
+			groups.states[rule.Name] = state
+		}
+
+		state.probes = make([]string, 0)
+		state.affected = make([]string, 0)
+		for _, member := range members {
+			if !selects(rule.Selector, member.labels) {
+				continue
+			}
+			state.probes = append(state.probes, member.name)
+			if member.affected(rule) {
+				state.affected = append(state.affected, member.name)
+			}
+		}
+
+		triggered := len(state.probes) > 0 && len(state.affected)*100 >= rule.Percent*len(state.probes)
+		if triggered == state.triggered {
+			continue
+		}
+		state.triggered = triggered
+		state.since = time.Now()
+		transitions = append(transitions, state.transition(rule))
+	}
+	return transitions
+}
+
+// transition logs and journals a group rule that changed state, caller must hold the groups lock
+func (s *groupState) transition(rule config.GroupRuleConfig) *groupTransition {
+	from, to := "normal", "triggered"
+	if !s.triggered {
+		from, to = to, from
+	}
+	log.WithFields(log.Fields{
+		"group":    rule.Name,
+		"status":   rule.Status,
+		"probes":   len(s.probes),
+		"affected": len(s.affected),
+	}).Warnf("Group is now %s", to)
+	journal.Record(journal.Event{
+		Kind:    journal.KindTransition,
+		Group:   rule.Name,
+		From:    from,
+		To:      to,
+		Counter: len(s.affected),
+	})
+	return &groupTransition{rule: rule, triggered: s.triggered, affected: len(s.affected)}
+}
+
+// deliver alerts or resolves the group rule and journals the outcome
+func (t *groupTransition) deliver() {
+	a := alert.New("group", t.rule.Name, t.rule.Severity)
+	kind := journal.KindAlert
+	var delivery *alerting.Delivery
+	if t.triggered {
+		delivery = alerting.ServerAlert(a)
+	} else {
+		kind = journal.KindResolution
+		delivery = alerting.ServerResolve(a)
+	}
+	journal.Record(journal.Event{
+		Kind:    kind,
+		Group:   t.rule.Name,
+		Counter: t.affected,
+		Alert:   deliveryRecord(a, delivery),
+	})
+}
+
+// Groups returns a snapshot of every group rule in the configuration order
+func Groups() []*GroupInfo {
+	groups.Lock()
+	defer groups.Unlock()
+
+	infos := make([]*GroupInfo, 0, len(config.Server.GroupRules))
+	for _, rule := range config.Server.GroupRules {
+		info := &GroupInfo{
+			Name:     rule.Name,
+			Selector: rule.Selector,
+			Status:   rule.Status,
+			Percent:  rule.Percent,
+			Severity: rule.Severity,
+			Probes:   []string{},
+			Affected: []string{},
+		}
+		if state, ok := groups.states[rule.Name]; ok {
+			info.Probes = append(info.Probes, state.probes...)
+			info.Affected = append(info.Affected, state.affected...)
+			info.Triggered = state.triggered
+			info.Since = state.since
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
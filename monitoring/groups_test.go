@@ -0,0 +1,116 @@
+package monitoring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/equals215/deepsentinel/config"
+	"github.com/equals215/deepsentinel/journal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabels(t *testing.T) {
+	config.Server = &config.ServerConfig{
+		ProbeInactivityDelay: "2s",
+		MachineLabels: []config.MachineLabelsConfig{
+			{Match: "web-*", Labels: map[string]string{"role": "web", "dc": "par"}},
+			{Match: "web-2", Labels: map[string]string{"dc": "ams"}},
+		},
+	}
+
+	// Test case 1: labels of every matching entry are merged
+	probe := makeProbe(&Payload{Machine: "web-2", Timestamp: time.Now()})
+	assert.Equal(t, map[string]string{"role": "web", "dc": "ams"}, probe.labels)
+
+	// Test case 2: labels declared by the agent override the configured ones
+	assert.True(t, probe.label(map[string]string{"DC": "fra", "env": " prod "}))
+	assert.Equal(t, map[string]string{"role": "web", "dc": "fra", "env": "prod"}, probe.labels)
+	assert.False(t, probe.label(map[string]string{"dc": "fra", "env": "prod"}))
+
+	// Test case 3: declared labels are persisted and restored
+	state := probe.state()
+	assert.Equal(t, map[string]string{"dc": "fra", "env": "prod"}, state.Labels)
+	restored, err := restoreProbe(state)
+	assert.NoError(t, err)
+	assert.Equal(t, probe.labels, restored.labels)
+
+	// Test case 4: a label without name is rejected
+	assert.ErrorIs(t, (&Payload{Labels: map[string]string{" ": "web"}}).Validate(), ErrEmptyLabelName)
+}
+
+func TestGroups(t *testing.T) {
+	config.Server = &config.ServerConfig{
+		ProbeInactivityDelay: "2s",
+		GroupRules: []config.GroupRuleConfig{
+			{Name: "web", Selector: map[string]string{"role": "web"}, Status: config.GroupStatusFailed, Percent: 30, Severity: "high"},
+			{Name: "par", Selector: map[string]string{"dc": "par"}, Status: config.GroupStatusSilent, Percent: 100, Severity: "low"},
+		},
+	}
+	assert.Nil(t, journal.Init(100, ""))
+	defer journal.Close()
+	groups.states = make(map[string]*groupState)
+
+	machines := map[string]map[string]string{
+		"web-1": {"role": "web", "dc": "par"},
+		"web-2": {"role": "web", "dc": "par"},
+		"web-3": {"role": "web", "dc": "ams"},
+		"db-1":  {"role": "db", "dc": "par"},
+	}
+	probes := make(map[string]*probeObject)
+	for machine, labels := range machines {
+		probes[machine] = makeProbe(&Payload{Machine: machine, Timestamp: time.Now(), Labels: labels})
+		assert.True(t, registerProbe(probes[machine]))
+	}
+	defer func() {
+		for machine := range machines {
+			unregisterProbe(machine)
+		}
+	}()
+
+	// Test case 1: groups select the probes holding every label of their selector
+	evaluateGroups()
+	infos := Groups()
+	assert.Len(t, infos, 2)
+	assert.Equal(t, []string{"web-1", "web-2", "web-3"}, infos[0].Probes)
+	assert.Equal(t, []string{"db-1", "web-1", "web-2"}, infos[1].Probes)
+	assert.False(t, infos[0].Triggered)
+
+	// Test case 2: a failed probe out of three triggers the 30% rule
+	probes["web-1"].status = failed
+	evaluateGroups()
+	waitDeliveries()
+	infos = Groups()
+	assert.True(t, infos[0].Triggered)
+	assert.Equal(t, []string{"web-1"}, infos[0].Affected)
+	events := journal.Query(journal.Filter{Group: "web"})
+	assert.Len(t, events, 2)
+	assert.Equal(t, "triggered", events[0].To)
+	assert.Equal(t, journal.KindAlert, events[1].Kind)
+	assert.Equal(t, "high", events[1].Alert.Severity)
+
+	// Test case 3: the silent rule only triggers once every probe of the group is silent
+	probes["web-2"].status = degraded
+	evaluateGroups()
+	assert.False(t, Groups()[1].Triggered)
+	probes["db-1"].status = alertedLow
+	evaluateGroups()
+	assert.True(t, Groups()[1].Triggered)
+
+	// Test case 4: silenced probes leave their groups
+	probes["db-1"].silences[""] = &Silence{Machine: "db-1"}
+	probes["db-1"].status = normal
+	evaluateGroups()
+	assert.Equal(t, []string{"web-1", "web-2"}, Groups()[1].Probes)
+	assert.True(t, Groups()[1].Triggered)
+
+	// Test case 5: a recovered group is resolved
+	probes["web-1"].status = normal
+	evaluateGroups()
+	waitDeliveries()
+	infos = Groups()
+	assert.False(t, infos[0].Triggered)
+	assert.False(t, infos[1].Triggered)
+	events = journal.Query(journal.Filter{Group: "web", Limit: 2})
+	assert.Equal(t, "normal", events[0].To)
+	assert.Equal(t, journal.KindResolution, events[1].Kind)
+}
@@ -2,13 +2,19 @@
 package monitoring
 
 import (
+	"errors"
+	"maps"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/equals215/deepsentinel/alerting"
+	"github.com/equals215/deepsentinel/alerting/alert"
 	"github.com/equals215/deepsentinel/config"
 	"github.com/equals215/deepsentinel/dashboard"
+	"github.com/equals215/deepsentinel/journal"
+	"github.com/equals215/deepsentinel/store"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -33,35 +39,128 @@ func (s probeStatus) String() string {
 	return statusStr[s]
 }
 
+// ProbeStatusLevel returns the escalation level of a probe status, from 0 (normal) to 4 (alertedHigh)
+// Unknown statuses return -1
+func ProbeStatusLevel(status string) int {
+	parsed, err := stringToProbeStatus(status)
+	if err != nil {
+		return -1
+	}
+	return int(parsed)
+}
+
+func stringToProbeStatus(str string) (probeStatus, error) {
+	for status := normal; status <= alertedHigh; status++ {
+		if status.String() == str {
+			return status, nil
+		}
+	}
+	return normal, errors.New("invalid probe status string")
+}
+
+var reportsReceived atomic.Uint64
+
+// loopInterval is the delay between two ticks of the monitoring loop
+const loopInterval = 5 * time.Second
+
+// lastLoop is the Unix time in nanoseconds of the latest iteration of the monitoring loop
+var lastLoop atomic.Int64
+
+// workers tracks the running probe goroutines
+var workers sync.WaitGroup
+
+// LoopHealthy returns true when the monitoring loop is running and didn't stall
+func LoopHealthy() bool {
+	last := lastLoop.Load()
+	return last != 0 && time.Since(time.Unix(0, last)) < 3*loopInterval
+}
+
+// ReportsReceived returns the number of reports handled since the server started
+func ReportsReceived() uint64 {
+	return reportsReceived.Load()
+}
+
+// ErrInvalidMachineStatus is returned when a report carries an unknown machine status
+var ErrInvalidMachineStatus = errors.New("machineStatus must be pass, warn or fail")
+
+// ErrEmptyServiceName is returned when a report carries a service without name
+var ErrEmptyServiceName = errors.New("service names can't be empty")
+
+// ErrEmptyLabelName is returned when a report carries a label without name
+var ErrEmptyLabelName = errors.New("label names can't be empty")
+
 // Payload is the structure of the payload received from the API server
+// MachineStatus is the status reported by the agent for the whole machine, empty when not reported
 type Payload struct {
-	MachineStatus string            `json:"machineStatus,omitempty"`
-	Services      map[string]string `json:"services"`
-	Timestamp     time.Time         `json:"-"`
-	Machine       string            `json:"-"`
+	MachineStatus string                   `json:"machineStatus,omitempty"`
+	Services      map[string]string        `json:"services"`
+	Thresholds    *config.ThresholdsConfig `json:"thresholds,omitempty"`
+	Labels        map[string]string        `json:"labels,omitempty"`
+	Timestamp     time.Time                `json:"-"`
+	Machine       string                   `json:"-"`
+}
+
+// Validate checks a payload reported by an agent
+func (p *Payload) Validate() error {
+	switch p.MachineStatus {
+	case "", "pass", "warn", "fail":
+	default:
+		return ErrInvalidMachineStatus
+	}
+	if _, ok := p.Services[machineStatusKey]; ok {
+		return ErrEmptyServiceName
+	}
+	if _, ok := config.NormalizeLabels(p.Labels)[""]; ok {
+		return ErrEmptyLabelName
+	}
+	return nil
 }
 
 type probeObject struct {
 	sync.Mutex
-	name       string
-	data       chan *Payload
-	stop       chan bool
-	status     probeStatus
-	counter    int
-	lastNormal time.Time
-	timeSerie  *probeTimeSerie
+	name           string
+	data           chan *Payload
+	stop           chan bool
+	status         probeStatus
+	counter        int
+	lastNormal     time.Time
+	lastReport     time.Time
+	reports        uint64
+	lastPersist    time.Time
+	declared       config.ThresholdsConfig
+	thresholds     thresholds
+	declaredLabels map[string]string
+	labels         map[string]string
+	published      atomic.Int32
+	recovered      atomic.Int64
+	silences       map[string]*Silence
+	suppressed     map[string]*suppressedAlert
+	sent           map[string]bool
+	timeSerie      *probeTimeSerie
 }
 
 // Handle function handles the payload from the API server
-func Handle(channel chan *Payload, dashboardOperator *dashboard.Operator) {
+// Probes found in stateStore are restored before handling any payload, stateStore can be nil
+// It returns once channel is closed and every probe handled its pending payloads and saved its state
+func Handle(channel chan *Payload, dashboardOperator *dashboard.Operator, stateStore store.Store) {
 	log.Debug("Starting monitoring.Handle")
-	var probeMap sync.Map
-	var probeList = make([]string, 0)
-	var timer = time.NewTimer(5 * time.Second)
+	var timer = time.NewTimer(loopInterval)
+
+	persistence = stateStore
+	restoreApprovals()
+	initCheckins(time.Now())
+	for _, probe := range restoreProbes() {
+		registerProbe(probe)
+		probe.start()
+	}
 
 	for {
+		lastLoop.Store(time.Now().UnixNano())
 		select {
 		case <-timer.C:
+			timer.Reset(loopInterval)
+			evaluateGroups()
+			evaluateCheckins(time.Now())
 			if dashboardOperator == nil {
 				continue
 			}
@@ -69,85 +168,133 @@ func Handle(channel chan *Payload, dashboardOperator *dashboard.Operator) {
 			dashboardPayload := &dashboard.Data{
 				Probes: make([]*dashboard.Probe, 0),
 			}
+			if alertingHealth := alerting.Health(); !alertingHealth.Healthy {
+				dashboardPayload.AlertingError = alertingHealth.LastError
+			}
 
-			for _, probe := range probeList {
-				if loaded, ok := probeMap.Load(probe); ok {
-					probe := loaded.(*probeObject)
-					probe.Lock()
-					dashboardProbe := &dashboard.Probe{
-						Name:   strings.Clone(probe.name),
-						Status: strings.Clone(probe.status.String()),
-					}
-					probe.Unlock()
-					dashboardPayload.Probes = append(dashboardPayload.Probes, dashboardProbe)
+			for _, probe := range registeredProbes() {
+				probe.Lock()
+				dashboardProbe := &dashboard.Probe{
+					Name:   strings.Clone(probe.name),
+					Status: strings.Clone(probe.status.String()),
+					Labels: maps.Clone(probe.labels),
 				}
+				if probe.status > normal {
+					dashboardProbe.UnreachableVia = probe.unreachableVia("")
+				}
+				for _, silence := range probe.activeSilences() {
+					dashboardProbe.Silences = append(dashboardProbe.Silences, &dashboard.Silence{
+						Service: silence.Service,
+						Until:   silence.Until,
+						Reason:  silence.Reason,
+					})
+				}
+				probe.Unlock()
+				dashboardPayload.Probes = append(dashboardPayload.Probes, dashboardProbe)
+			}
+			for _, pending := range PendingMachines() {
+				dashboardPayload.Pending = append(dashboardPayload.Pending, pending.Machine)
+			}
+			for _, checkin := range Checkins() {
+				dashboardPayload.Checkins = append(dashboardPayload.Checkins, &dashboard.Checkin{
+					Name:    checkin.Name,
+					Status:  checkin.Status,
+					NextRun: checkin.NextRun,
+				})
+			}
+			for _, group := range Groups() {
+				dashboardPayload.Groups = append(dashboardPayload.Groups, &dashboard.Group{
+					Name:      group.Name,
+					Probes:    len(group.Probes),
+					Affected:  len(group.Affected),
+					Triggered: group.Triggered,
+				})
 			}
 
 			dashboardOperator.In <- dashboardPayload
-			timer.Reset(5 * time.Second)
 			continue
-		case payload := <-channel:
-			if loaded, ok := probeMap.Load(payload.Machine); ok {
-				probe := loaded.(*probeObject)
+		case payload, ok := <-channel:
+			if !ok {
+				timer.Stop()
+				stopProbes()
+				waitDeliveries()
+				waitStateWrites()
+				return
+			}
+			if payload.MachineStatus != "delete" {
+				reportsReceived.Add(1)
+			}
+			if probe, ok := lookupProbe(payload.Machine); ok {
 				if payload.MachineStatus == "delete" {
 					// Delete the probe
-					probe.Lock()
-					for i, name := range probeList {
-						if name == payload.Machine {
-							probeList = append(probeList[:i], probeList[i+1:]...)
-							break
-						}
-					}
+					unregisterProbe(payload.Machine)
+					forgetApproval(payload.Machine)
 					probe.delete()
-					probeMap.Delete(payload.Machine)
+					journal.Record(journal.Event{
+						Kind:    journal.KindDelete,
+						Machine: payload.Machine,
+					})
 				} else {
 					// Send the payload to the probe
 					probe.data <- payload
 				}
-			} else {
+			} else if payload.MachineStatus != "delete" && admit(payload) {
 				// Create a new probe
-				newProbe := makeProbe(payload)
-
-				value, loaded := probeMap.LoadOrStore(payload.Machine, newProbe)
-				if loaded {
+				probe := makeProbe(payload)
+				if !registerProbe(probe) {
 					log.WithFields(log.Fields{
 						"machine": payload.Machine,
 					}).Fatal("Machine already exists")
 				}
 
-				probe, ok := value.(*probeObject)
-				if !ok {
-					log.WithFields(log.Fields{
-						"machine": payload.Machine,
-					}).Fatal("Failed to load probe")
-				}
-
 				log.WithFields(log.Fields{
 					"probe":   probe.name,
 					"machine": payload.Machine,
 					"status":  probe.status,
 				}).Info("Starting probe thread")
 
-				probeList = append(probeList, payload.Machine)
-				go probe.work()
+				probe.start()
 				probe.data <- payload
 			}
 		}
 	}
 }
 
-func (p *probeObject) work() {
-	inactivityDelay, err := time.ParseDuration(config.Server.ProbeInactivityDelay)
-	if err != nil {
-		log.WithError(err).Fatal("Failed to parse inactivity delay")
+// start runs the probe goroutine
+func (p *probeObject) start() {
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		p.work()
+	}()
+}
+
+// stopProbes stops every probe once it handled its pending payloads, alerts being sent included
+func stopProbes() {
+	probes := registeredProbes()
+	for _, probe := range probes {
+		close(probe.data)
 	}
-	timer := time.NewTimer(inactivityDelay)
+	workers.Wait()
+	log.WithField("probes", len(probes)).Info("Probes stopped")
+}
+
+func (p *probeObject) work() {
+	p.Lock()
+	timer := time.NewTimer(p.thresholds.inactivityDelay)
+	p.Unlock()
 	for {
 		select {
 		case <-p.stop:
 			return
-		case payload := <-p.data:
+		case payload, ok := <-p.data:
 			p.Lock()
+			if !ok {
+				// The server is shutting down
+				p.persist()
+				p.Unlock()
+				return
+			}
 			if payload.Machine != p.name {
 				log.WithFields(log.Fields{
 					"probe":   p.name,
@@ -159,14 +306,24 @@ func (p *probeObject) work() {
 				"machine": payload.Machine,
 				"status":  p.status,
 			}).Trace("Received report")
+			p.expireSilences()
+			p.lastReport = payload.Timestamp
+			p.reports++
+			declared := p.declare(payload.Thresholds)
+			labeled := p.label(payload.Labels)
+			if declared || labeled {
+				p.persist()
+			}
 			p.workServices(payload)
 			p.reset()
-			timer.Reset(inactivityDelay)
+			timer.Reset(p.thresholds.inactivityDelay)
 			p.Unlock()
 		case <-timer.C:
 			p.Lock()
+			p.expireSilences()
+			p.sendSuppressed()
 			p.timerIncrement()
-			timer.Reset(inactivityDelay)
+			timer.Reset(p.thresholds.inactivityDelay)
 			p.Unlock()
 		}
 	}
@@ -178,22 +335,22 @@ func (p *probeObject) timerIncrement() {
 		p.updateStatus()
 	case degraded:
 		p.counter++
-		if p.counter >= config.Server.DegradedToFailedThreshold {
+		if p.counter >= p.thresholds.degradedToFailed {
 			p.updateStatus()
 			break
 		}
 	case failed:
 		p.counter++
-		if p.counter >= config.Server.FailedToAlertedLowThreshold {
+		if p.counter >= p.thresholds.failedToAlertedLow {
 			p.updateStatus()
-			alerting.ServerAlert("machine", p.name, "low")
+			p.alert("", alert.New("machine", p.name, "low").WithLastNormal(p.lastNormal))
 			break
 		}
 	case alertedLow:
 		p.counter++
-		if p.counter >= config.Server.AlertedLowToAlertedHighThreshold {
+		if p.counter >= p.thresholds.alertedLowToAlertedHigh {
 			p.updateStatus()
-			alerting.ServerAlert("machine", p.name, "high")
+			p.alert("", alert.New("machine", p.name, "high").WithLastNormal(p.lastNormal))
 			break
 		}
 	case alertedHigh:
@@ -202,20 +359,35 @@ func (p *probeObject) timerIncrement() {
 			log.Warnf("Machine %s is still in %s state\n", p.name, "alertedHigh")
 		}
 	}
+	p.persist()
 }
 
 func (p *probeObject) reset() {
-	if p.status > normal {
+	wasNormal := p.status == normal
+	if !wasNormal {
 		log.Infof("Machine %s is back in normal state\n", p.name)
+		p.recordTransition("", p.status.String(), normal.String(), p.counter, p.lastNormal)
+	}
+	if p.status == alertedLow {
+		p.resolve("", alert.New("machine", p.name, "low").WithLastNormal(p.lastNormal))
+	} else if p.status == alertedHigh {
+		p.resolve("", alert.New("machine", p.name, "high").WithLastNormal(p.lastNormal))
 	}
 	p.status = normal
 	p.counter = 0
 	p.lastNormal = time.Now()
+	p.publishStatus()
+	if !wasNormal || time.Since(p.lastPersist) >= persistInterval {
+		p.persist()
+	}
 }
 
 func (p *probeObject) updateStatus() {
+	p.recordTransition("", p.status.String(), (p.status + 1).String(), p.counter, p.lastNormal)
 	p.status++
 	p.counter = 0
+	p.publishStatus()
+	p.persist()
 	if p.status > normal {
 		duration := time.Since(p.lastNormal)
 		log.Warnf("No payload received for %s. Machine %s is now in %s state\n", duration.String(), p.name, p.status.String())
@@ -231,16 +403,31 @@ func (p *probeObject) delete() {
 	p.stop <- true
 	close(p.data)
 	close(p.stop)
+	p.forget()
 }
 
 func makeProbe(originPayload *Payload) *probeObject {
+	declared := config.ThresholdsConfig{}
+	if originPayload.Thresholds != nil {
+		declared = *originPayload.Thresholds
+	}
+	declaredLabels := config.NormalizeLabels(originPayload.Labels)
+
 	return &probeObject{
-		name:       originPayload.Machine,
-		data:       make(chan *Payload, 1),
-		stop:       make(chan bool),
-		status:     normal,
-		counter:    0,
-		lastNormal: time.Now(),
+		name:           originPayload.Machine,
+		data:           make(chan *Payload, 1),
+		stop:           make(chan bool),
+		status:         normal,
+		counter:        0,
+		lastNormal:     time.Now(),
+		lastReport:     originPayload.Timestamp,
+		declared:       declared,
+		thresholds:     resolveThresholds(originPayload.Machine, declared),
+		declaredLabels: declaredLabels,
+		labels:         resolveLabels(originPayload.Machine, declaredLabels),
+		silences:       make(map[string]*Silence),
+		suppressed:     make(map[string]*suppressedAlert),
+		sent:           make(map[string]bool),
 		timeSerie: &probeTimeSerie{
 			head: &timeSerieNode{
 				timestamp: originPayload.Timestamp,
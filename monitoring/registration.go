@@ -0,0 +1,244 @@
+package monitoring
+
+import (
+	"errors"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/equals215/deepsentinel/config"
+	"github.com/equals215/deepsentinel/journal"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxPending bounds the pending machines so that random names can't exhaust the memory
+const maxPending = 1000
+
+// Registration rejection reasons
+const (
+	RejectedPending     = "pending"
+	RejectedMaxProbes   = "max-probes"
+	RejectedPendingFull = "pending-full"
+)
+
+var (
+	// ErrRegistrationPending is returned for a machine waiting for approval
+	ErrRegistrationPending = errors.New("machine registration is pending approval")
+	// ErrTooManyProbes is returned for a new machine once the maximum number of probes is reached
+	ErrTooManyProbes = errors.New("maximum number of probes reached")
+	// ErrPendingNotFound is returned when rejecting a machine that isn't pending
+	ErrPendingNotFound = errors.New("pending machine not found")
+	// ErrMachineNameRequired is returned when approving an empty machine name
+	ErrMachineNameRequired = errors.New("machine name is required")
+)
+
+// PendingMachine is a machine that reported without being approved
+type PendingMachine struct {
+	Machine   string    `json:"machine"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+	Reports   uint64    `json:"reports"`
+}
+
+// registration holds the pending and approved machines and counts the rejected reports by reason
+var registration = struct {
+	sync.Mutex
+	pending  map[string]*PendingMachine
+	approved map[string]bool
+	rejected map[string]uint64
+}{
+	pending:  make(map[string]*PendingMachine),
+	approved: make(map[string]bool),
+	rejected: make(map[string]uint64),
+}
+
+// Admission returns why a report of machine won't be handled, nil when it will
+// It only reads the registration state, Handle takes the actual decision
+func Admission(machine string) error {
+	if _, ok := lookupProbe(machine); ok {
+		return nil
+	}
+	registration.Lock()
+	defer registration.Unlock()
+	return admission(machine)
+}
+
+// admission is Admission for a machine without probe, caller must hold the registration lock
+func admission(machine string) error {
+	limit := config.Server.Registration.MaxProbes
+	if limit > 0 && probeCount() >= limit {
+		return ErrTooManyProbes
+	}
+	if config.Server.Registration.Policy != config.RegistrationApproval || registration.approved[machine] {
+		return nil
+	}
+	for _, pattern := range config.Server.Registration.Allowlist {
+		if matched, _ := path.Match(pattern, machine); matched {
+			return nil
+		}
+	}
+	return ErrRegistrationPending
+}
+
+// admit returns true when payload of a machine without probe may create it, called by Handle only
+// Other payloads are logged and counted, machines needing approval are kept as pending
+func admit(payload *Payload) bool {
+	registration.Lock()
+	defer registration.Unlock()
+
+	err := admission(payload.Machine)
+	switch err {
+	case nil:
+		delete(registration.pending, payload.Machine)
+		return true
+	case ErrTooManyProbes:
+		registration.rejected[RejectedMaxProbes]++
+		log.WithFields(log.Fields{
+			"machine": payload.Machine,
+			"limit":   config.Server.Registration.MaxProbes,
+		}).Warn("Maximum number of probes reached, report rejected")
+		return false
+	}
+
+	pending, ok := registration.pending[payload.Machine]
+	if !ok {
+		if len(registration.pending) >= maxPending {
+			registration.rejected[RejectedPendingFull]++
+			log.WithField("machine", payload.Machine).Warn("Too many pending machines, report rejected")
+			return false
+		}
+		pending = &PendingMachine{Machine: payload.Machine, FirstSeen: payload.Timestamp}
+		registration.pending[payload.Machine] = pending
+		log.WithField("machine", payload.Machine).Warn("New machine pending approval")
+		journal.Record(journal.Event{
+			Kind:    journal.KindRegistration,
+			Machine: payload.Machine,
+			Message: "pending approval",
+		})
+	}
+	pending.LastSeen = payload.Timestamp
+	pending.Reports++
+	registration.rejected[RejectedPending]++
+	log.WithField("machine", payload.Machine).Debug("Machine pending approval, report rejected")
+	return false
+}
+
+// ApproveMachine lets machine register, its next report creates its probe
+// Machines can be approved before they report, approvals are kept in the state store if any
+func ApproveMachine(machine string) error {
+	if strings.TrimSpace(machine) == "" {
+		return ErrMachineNameRequired
+	}
+
+	updateApprovals(func() {
+		registration.approved[machine] = true
+	})
+	log.WithField("machine", machine).Info("Machine approved")
+	journal.Record(journal.Event{
+		Kind:    journal.KindRegistration,
+		Machine: machine,
+		Message: "approved",
+	})
+	return nil
+}
+
+// RejectMachine drops a pending machine, its next report makes it pending again
+func RejectMachine(machine string) error {
+	registration.Lock()
+	defer registration.Unlock()
+
+	if _, ok := registration.pending[machine]; !ok {
+		return ErrPendingNotFound
+	}
+	delete(registration.pending, machine)
+	log.WithField("machine", machine).Info("Pending machine rejected")
+	journal.Record(journal.Event{
+		Kind:    journal.KindRegistration,
+		Machine: machine,
+		Message: "rejected",
+	})
+	return nil
+}
+
+// forgetApproval makes a deleted machine go through the registration policy again
+func forgetApproval(machine string) {
+	updateApprovals(func() {
+		delete(registration.approved, machine)
+	})
+}
+
+// approvalWrites orders the saves of the approvals so that an older list never overwrites a newer one
+var approvalWrites sync.Mutex
+
+// updateApprovals applies update to the approved machines then saves them if a store is configured
+// The store is written without holding the registration lock so that slow disks don't hold the reports
+func updateApprovals(update func()) {
+	approvalWrites.Lock()
+	defer approvalWrites.Unlock()
+
+	registration.Lock()
+	update()
+	machines := make([]string, 0, len(registration.approved))
+	for machine := range registration.approved {
+		machines = append(machines, machine)
+	}
+	registration.Unlock()
+
+	if persistence == nil {
+		return
+	}
+	sort.Strings(machines)
+	if err := persistence.SaveApprovals(machines); err != nil {
+		log.WithError(err).Error("Failed to persist machine approvals")
+	}
+}
+
+// restoreApprovals loads the approvals saved in the store
+func restoreApprovals() {
+	if persistence == nil {
+		return
+	}
+
+	machines, err := persistence.LoadApprovals()
+	if err != nil {
+		log.WithError(err).Error("Failed to load machine approvals, approve the pending machines again")
+		return
+	}
+
+	registration.Lock()
+	defer registration.Unlock()
+	for _, machine := range machines {
+		registration.approved[machine] = true
+	}
+	log.WithField("machines", len(machines)).Debug("Restored machine approvals")
+}
+
+// PendingMachines returns the machines waiting for approval sorted by name
+func PendingMachines() []*PendingMachine {
+	registration.Lock()
+	defer registration.Unlock()
+
+	machines := make([]*PendingMachine, 0, len(registration.pending))
+	for _, pending := range registration.pending {
+		pendingCopy := *pending
+		machines = append(machines, &pendingCopy)
+	}
+	sort.Slice(machines, func(i, j int) bool {
+		return machines[i].Machine < machines[j].Machine
+	})
+	return machines
+}
+
+// RejectedRegistrations returns the number of reports rejected by the registration policy by reason
+func RejectedRegistrations() map[string]uint64 {
+	registration.Lock()
+	defer registration.Unlock()
+
+	rejected := make(map[string]uint64, len(registration.rejected))
+	for reason, count := range registration.rejected {
+		rejected[reason] = count
+	}
+	return rejected
+}
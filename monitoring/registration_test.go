@@ -0,0 +1,108 @@
+package monitoring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/equals215/deepsentinel/config"
+	"github.com/equals215/deepsentinel/journal"
+	"github.com/equals215/deepsentinel/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistration(t *testing.T) {
+	config.Server = &config.ServerConfig{
+		ProbeInactivityDelay: "2s",
+		Registration: config.RegistrationConfig{
+			Policy:    config.RegistrationApproval,
+			Allowlist: []string{"web-*"},
+			MaxProbes: 2,
+		},
+	}
+	assert.Nil(t, journal.Init(100, ""))
+	defer journal.Close()
+	defer func() {
+		for _, machine := range []string{"web-1", "db-1"} {
+			unregisterProbe(machine)
+			forgetApproval(machine)
+		}
+	}()
+	report := func(machine string) *Payload {
+		return &Payload{Machine: machine, Timestamp: time.Now()}
+	}
+
+	// Test case 1: allowlisted machines register right away
+	assert.NoError(t, Admission("web-1"))
+	assert.True(t, admit(report("web-1")))
+	assert.True(t, registerProbe(makeProbe(report("web-1"))))
+
+	// Test case 2: other machines are pending until approved
+	assert.ErrorIs(t, Admission("db-1"), ErrRegistrationPending)
+	assert.False(t, admit(report("db-1")))
+	assert.False(t, admit(report("db-1")))
+	pending := PendingMachines()
+	assert.Len(t, pending, 1)
+	assert.Equal(t, "db-1", pending[0].Machine)
+	assert.Equal(t, uint64(2), pending[0].Reports)
+	assert.Equal(t, uint64(2), RejectedRegistrations()[RejectedPending])
+	events := journal.Query(journal.Filter{Machine: "db-1"})
+	assert.Len(t, events, 1)
+	assert.Equal(t, journal.KindRegistration, events[0].Kind)
+
+	assert.NoError(t, ApproveMachine("db-1"))
+	assert.True(t, admit(report("db-1")))
+	assert.Len(t, PendingMachines(), 0)
+	assert.True(t, registerProbe(makeProbe(report("db-1"))))
+
+	// Test case 3: the number of probes is capped, even for allowlisted machines
+	assert.ErrorIs(t, Admission("web-2"), ErrTooManyProbes)
+	assert.False(t, admit(report("web-2")))
+	assert.Equal(t, uint64(1), RejectedRegistrations()[RejectedMaxProbes])
+	assert.NoError(t, Admission("db-1"))
+
+	// Test case 4: a rejected machine is dropped from the pending machines
+	unregisterProbe("db-1")
+	forgetApproval("db-1")
+	assert.False(t, admit(report("db-1")))
+	assert.NoError(t, RejectMachine("db-1"))
+	assert.Len(t, PendingMachines(), 0)
+	assert.ErrorIs(t, RejectMachine("db-1"), ErrPendingNotFound)
+
+	// Test case 5: the open policy registers every machine
+	config.Server.Registration = config.RegistrationConfig{Policy: config.RegistrationOpen}
+	assert.True(t, admit(report("db-1")))
+
+	// Test case 6: an empty machine name can't be approved
+	assert.ErrorIs(t, ApproveMachine(""), ErrMachineNameRequired)
+	assert.ErrorIs(t, ApproveMachine("  "), ErrMachineNameRequired)
+}
+
+func TestApprovalsPersistence(t *testing.T) {
+	config.Server = &config.ServerConfig{
+		ProbeInactivityDelay: "2s",
+		Registration:         config.RegistrationConfig{Policy: config.RegistrationApproval},
+	}
+	assert.Nil(t, journal.Init(100, ""))
+	defer journal.Close()
+	stateStore, err := store.NewJSONStore(t.TempDir())
+	assert.Nil(t, err)
+	persistence = stateStore
+	defer func() { persistence = nil }()
+	defer forgetApproval("db-2")
+
+	// Test case 1: approvals are saved with the state store and restored at startup
+	assert.NoError(t, ApproveMachine("db-1"))
+	assert.NoError(t, ApproveMachine("db-2"))
+	forgetApproval("db-1")
+	approved, err := stateStore.LoadApprovals()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"db-2"}, approved)
+
+	registration.Lock()
+	delete(registration.approved, "db-2")
+	registration.Unlock()
+	assert.ErrorIs(t, Admission("db-2"), ErrRegistrationPending)
+	restoreApprovals()
+	assert.NoError(t, Admission("db-2"))
+	assert.ErrorIs(t, Admission("db-1"), ErrRegistrationPending)
+}
@@ -0,0 +1,169 @@
+package monitoring
+
+import (
+	"maps"
+	"sort"
+	"sync"
+	"time"
+)
+
+// registry holds the probes handled by Handle in their creation order
+// Handle is the only writer, readers such as the API only take snapshots
+var registry = struct {
+	sync.RWMutex
+	probes map[string]*probeObject
+	order  []string
+}{
+	probes: make(map[string]*probeObject),
+}
+
+// ProbeInfo is a snapshot of a probe
+// UnreachableVia is the failed parent of a probe that stopped reporting, its alerts are suppressed
+type ProbeInfo struct {
+	Name           string                  `json:"name"`
+	Status         string                  `json:"status"`
+	Counter        int                     `json:"counter"`
+	LastNormal     time.Time               `json:"lastNormal"`
+	LastReport     time.Time               `json:"lastReport"`
+	Reports        uint64                  `json:"reports"`
+	TimeSerieSize  int                     `json:"timeSerieSize"`
+	Labels         map[string]string       `json:"labels"`
+	UnreachableVia string                  `json:"unreachableVia,omitempty"`
+	MachineStatus  *ServiceInfo            `json:"machineStatus,omitempty"`
+	Services       map[string]*ServiceInfo `json:"services"`
+	Thresholds     ThresholdsInfo          `json:"thresholds"`
+	Silences       []*Silence              `json:"silences,omitempty"`
+}
+
+// ServiceInfo is a snapshot of the latest status of a service
+type ServiceInfo struct {
+	Status   string `json:"status"`
+	Count    int    `json:"count"`
+	Flapping bool   `json:"flapping,omitempty"`
+}
+
+// ThresholdsInfo is a snapshot of the resolved thresholds of a probe
+type ThresholdsInfo struct {
+	ProbeInactivityDelay             string `json:"probeInactivityDelay"`
+	DegradedToFailedThreshold        int    `json:"degradedToFailed"`
+	FailedToAlertedLowThreshold      int    `json:"failedToAlertLow"`
+	AlertedLowToAlertedHighThreshold int    `json:"alertLowToAlertHigh"`
+}
+
+func registerProbe(probe *probeObject) bool {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if _, ok := registry.probes[probe.name]; ok {
+		return false
+	}
+	registry.probes[probe.name] = probe
+	registry.order = append(registry.order, probe.name)
+	return true
+}
+
+func unregisterProbe(name string) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	delete(registry.probes, name)
+	for i, registered := range registry.order {
+		if registered == name {
+			registry.order = append(registry.order[:i], registry.order[i+1:]...)
+			break
+		}
+	}
+}
+
+func probeCount() int {
+	registry.RLock()
+	defer registry.RUnlock()
+	return len(registry.probes)
+}
+
+func lookupProbe(name string) (*probeObject, bool) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	probe, ok := registry.probes[name]
+	return probe, ok
+}
+
+// registeredProbes returns the probes in their creation order
+func registeredProbes() []*probeObject {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	probes := make([]*probeObject, 0, len(registry.order))
+	for _, name := range registry.order {
+		probes = append(probes, registry.probes[name])
+	}
+	return probes
+}
+
+// Probes returns a snapshot of every probe sorted by name
+func Probes() []*ProbeInfo {
+	probes := registeredProbes()
+	infos := make([]*ProbeInfo, 0, len(probes))
+	for _, probe := range probes {
+		infos = append(infos, probe.info())
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+// Probe returns a snapshot of the probe of machine
+func Probe(machine string) (*ProbeInfo, bool) {
+	probe, ok := lookupProbe(machine)
+	if !ok {
+		return nil, false
+	}
+	return probe.info(), true
+}
+
+func (p *probeObject) info() *ProbeInfo {
+	p.Lock()
+	defer p.Unlock()
+
+	info := &ProbeInfo{
+		Name:       p.name,
+		Status:     p.status.String(),
+		Counter:    p.counter,
+		LastNormal: p.lastNormal,
+		LastReport: p.lastReport,
+		Reports:    p.reports,
+		Labels:     maps.Clone(p.labels),
+		Services:   make(map[string]*ServiceInfo),
+		Thresholds: ThresholdsInfo{
+			ProbeInactivityDelay:             p.thresholds.inactivityDelay.String(),
+			DegradedToFailedThreshold:        p.thresholds.degradedToFailed,
+			FailedToAlertedLowThreshold:      p.thresholds.failedToAlertedLow,
+			AlertedLowToAlertedHighThreshold: p.thresholds.alertedLowToAlertedHigh,
+		},
+		Silences: p.activeSilences(),
+	}
+	if p.status > normal {
+		info.UnreachableVia = p.unreachableVia("")
+	}
+
+	p.timeSerie.Lock()
+	defer p.timeSerie.Unlock()
+	info.TimeSerieSize = p.timeSerie.size
+	if p.timeSerie.head != nil {
+		for service, status := range p.timeSerie.head.services {
+			serviceInfo := &ServiceInfo{
+				Status:   status.status.String(),
+				Count:    status.count,
+				Flapping: status.flapping,
+			}
+			if service == machineStatusKey {
+				info.MachineStatus = serviceInfo
+				continue
+			}
+			info.Services[service] = serviceInfo
+		}
+	}
+	return info
+}
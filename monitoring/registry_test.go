@@ -0,0 +1,54 @@
+package monitoring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/equals215/deepsentinel/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProbes(t *testing.T) {
+	config.Server = &config.ServerConfig{
+		ProbeInactivityDelay:             "2s",
+		DegradedToFailedThreshold:        10,
+		FailedToAlertedLowThreshold:      20,
+		AlertedLowToAlertedHighThreshold: 30,
+	}
+	defer func() {
+		unregisterProbe("machine1")
+		unregisterProbe("machine2")
+	}()
+
+	// Test case 1: registered probes are listed sorted by name
+	reportedAt := time.Now().Round(0)
+	machine2 := makeProbe(&Payload{Machine: "machine2", Timestamp: reportedAt})
+	machine1 := makeProbe(&Payload{Machine: "machine1", Timestamp: reportedAt})
+	assert.True(t, registerProbe(machine2))
+	assert.True(t, registerProbe(machine1))
+	assert.False(t, registerProbe(makeProbe(&Payload{Machine: "machine1"})))
+
+	probes := Probes()
+	assert.Len(t, probes, 2)
+	assert.Equal(t, "machine1", probes[0].Name)
+	assert.Equal(t, "machine2", probes[1].Name)
+
+	// Test case 2: a probe snapshot holds its state, services and thresholds
+	machine1.status = failed
+	machine1.counter = 3
+	machine1.timeSerie.head.services["nginx"] = &serviceStatus{status: warn, count: 2}
+	info, ok := Probe("machine1")
+	assert.True(t, ok)
+	assert.Equal(t, "failed", info.Status)
+	assert.Equal(t, 3, info.Counter)
+	assert.True(t, reportedAt.Equal(info.LastReport))
+	assert.Equal(t, 1, info.TimeSerieSize)
+	assert.Equal(t, &ServiceInfo{Status: "warn", Count: 2}, info.Services["nginx"])
+	assert.Equal(t, ThresholdsInfo{"2s", 10, 20, 30}, info.Thresholds)
+
+	// Test case 3: an unregistered probe can't be inspected
+	unregisterProbe("machine1")
+	_, ok = Probe("machine1")
+	assert.False(t, ok)
+	assert.Len(t, Probes(), 1)
+}
@@ -0,0 +1,258 @@
+package monitoring
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/equals215/deepsentinel/alerting"
+	"github.com/equals215/deepsentinel/alerting/alert"
+	"github.com/equals215/deepsentinel/journal"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrProbeNotFound is returned when acting on a machine that has no probe
+	ErrProbeNotFound = errors.New("probe not found")
+	// ErrSilenceNotFound is returned when clearing a silence that doesn't exist
+	ErrSilenceNotFound = errors.New("silence not found")
+)
+
+// Silence is a maintenance window of a machine or of one of its services
+// A silence without Until lasts until it is cleared
+type Silence struct {
+	Machine   string     `json:"machine"`
+	Service   string     `json:"service,omitempty"`
+	Until     *time.Time `json:"until,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+func (s *Silence) active(now time.Time) bool {
+	return s.Until == nil || now.Before(*s.Until)
+}
+
+// end returns the end of the silence, zero when it lasts until it is cleared
+func (s *Silence) end() time.Time {
+	if s.Until == nil {
+		return time.Time{}
+	}
+	return *s.Until
+}
+
+// untilPointer returns nil for a zero until so that silences until cleared have no end in JSON
+func untilPointer(until time.Time) *time.Time {
+	if until.IsZero() {
+		return nil
+	}
+	return &until
+}
+
+// suppressedAlert is an alert that wasn't sent because its component was silenced
+type suppressedAlert struct {
+	service string
+	alert   *alert.Alert
+}
+
+// SilenceProbe silences the machine, or only service when it isn't empty, until the given time
+// A zero until silences until the silence is cleared
+func SilenceProbe(machine, service string, until time.Time, reason string) (*Silence, error) {
+	probe, ok := lookupProbe(machine)
+	if !ok {
+		return nil, ErrProbeNotFound
+	}
+
+	probe.Lock()
+	defer probe.Unlock()
+
+	silence := &Silence{
+		Machine:   machine,
+		Service:   service,
+		Until:     untilPointer(until),
+		Reason:    reason,
+		CreatedAt: time.Now(),
+	}
+	probe.silences[service] = silence
+	probe.persist()
+	probe.recordSilence(service, silenceMessage(silence))
+
+	log.WithFields(log.Fields{
+		"probe":   machine,
+		"service": service,
+		"until":   until,
+		"reason":  reason,
+	}).Info("Probe silenced")
+
+	silenceCopy := *silence
+	return &silenceCopy, nil
+}
+
+// ClearSilence ends the silence of the machine, or of service when it isn't empty
+// Alerts suppressed during the silence are sent in the background if their component is still failing
+func ClearSilence(machine, service string) error {
+	probe, ok := lookupProbe(machine)
+	if !ok {
+		return ErrProbeNotFound
+	}
+
+	probe.Lock()
+	defer probe.Unlock()
+
+	if _, ok := probe.silences[service]; !ok {
+		return ErrSilenceNotFound
+	}
+	delete(probe.silences, service)
+	probe.persist()
+	probe.recordSilence(service, "silence cleared")
+
+	log.WithFields(log.Fields{
+		"probe":   machine,
+		"service": service,
+	}).Info("Probe silence cleared")
+	probe.sendSuppressed()
+	return nil
+}
+
+// Silences returns every active silence sorted by machine and service
+func Silences() []*Silence {
+	now := time.Now()
+	silences := make([]*Silence, 0)
+	for _, probe := range registeredProbes() {
+		probe.Lock()
+		for _, silence := range probe.silences {
+			if silence.active(now) {
+				silenceCopy := *silence
+				silences = append(silences, &silenceCopy)
+			}
+		}
+		probe.Unlock()
+	}
+
+	sort.Slice(silences, func(i, j int) bool {
+		if silences[i].Machine != silences[j].Machine {
+			return silences[i].Machine < silences[j].Machine
+		}
+		return silences[i].Service < silences[j].Service
+	})
+	return silences
+}
+
+func silenceMessage(silence *Silence) string {
+	message := "silenced until cleared"
+	if silence.Until != nil {
+		message = "silenced until " + silence.Until.Format(time.RFC3339)
+	}
+	if silence.Reason != "" {
+		message += ": " + silence.Reason
+	}
+	return message
+}
+
+// silenced returns true when the machine or the given service is silenced, caller must hold the probe lock
+// An empty service only checks the machine silence
+func (p *probeObject) silenced(service string) bool {
+	now := time.Now()
+	if silence, ok := p.silences[""]; ok && silence.active(now) {
+		return true
+	}
+	if service == "" {
+		return false
+	}
+	silence, ok := p.silences[service]
+	return ok && silence.active(now)
+}
+
+// activeSilences returns copies of the active silences of the probe, caller must hold the probe lock
+func (p *probeObject) activeSilences() []*Silence {
+	now := time.Now()
+	silences := make([]*Silence, 0, len(p.silences))
+	for _, silence := range p.silences {
+		if silence.active(now) {
+			silenceCopy := *silence
+			silences = append(silences, &silenceCopy)
+		}
+	}
+	sort.Slice(silences, func(i, j int) bool {
+		return silences[i].Service < silences[j].Service
+	})
+	return silences
+}
+
+// expireSilences removes the silences that ended, caller must hold the probe lock
+func (p *probeObject) expireSilences() {
+	now := time.Now()
+	expired := false
+	for service, silence := range p.silences {
+		if !silence.active(now) {
+			log.WithFields(log.Fields{
+				"probe":   p.name,
+				"service": service,
+			}).Info("Probe silence expired")
+			delete(p.silences, service)
+			p.recordSilence(service, "silence expired")
+			expired = true
+		}
+	}
+	if expired {
+		p.persist()
+		p.sendSuppressed()
+	}
+}
+
+// alert sends a unless the machine or service is silenced or unreachable, caller must hold the probe lock
+// An empty service stands for the machine itself
+func (p *probeObject) alert(service string, a *alert.Alert) {
+	if parent := p.unreachableVia(service); parent != "" {
+		p.suppressUnreachable(service, parent, a)
+		return
+	}
+	if p.silenced(service) {
+		log.WithFields(log.Fields{
+			"probe":     p.name,
+			"category":  a.Category,
+			"component": a.Component,
+			"severity":  a.Severity,
+		}).Info("Probe is silenced, alert suppressed")
+		p.suppressed[a.DedupKey()] = &suppressedAlert{service: service, alert: a}
+		p.recordDelivery(journal.KindAlert, service, a, nil)
+		return
+	}
+	p.sent[a.DedupKey()] = true
+	p.deliver(journal.KindAlert, service, a, alerting.ServerAlert)
+}
+
+// resolve resolves a, an alert that was only suppressed is dropped without sending the resolution, caller must hold the probe lock
+// An empty service stands for the machine itself
+func (p *probeObject) resolve(service string, a *alert.Alert) {
+	key := a.DedupKey()
+	_, suppressed := p.suppressed[key]
+	delete(p.suppressed, key)
+	if suppressed && !p.sent[key] {
+		log.WithFields(log.Fields{
+			"probe":     p.name,
+			"component": a.Component,
+		}).Info("Alert was never sent, resolution suppressed")
+		p.recordDelivery(journal.KindResolution, service, a, nil)
+		return
+	}
+	delete(p.sent, key)
+	p.deliver(journal.KindResolution, service, a, alerting.ServerResolve)
+}
+
+// sendSuppressed sends the suppressed alerts whose component isn't silenced nor unreachable anymore, caller must hold the probe lock
+// Suppressed alerts that recovered in the meantime were already dropped by resolve
+func (p *probeObject) sendSuppressed() {
+	for key, suppressed := range p.suppressed {
+		if p.silenced(suppressed.service) || p.unreachableVia(suppressed.service) != "" {
+			continue
+		}
+		delete(p.suppressed, key)
+		log.WithFields(log.Fields{
+			"probe":     p.name,
+			"component": suppressed.alert.Component,
+			"severity":  suppressed.alert.Severity,
+		}).Warn("Alert no longer suppressed, sending it")
+		p.sent[key] = true
+		p.deliver(journal.KindAlert, suppressed.service, suppressed.alert, alerting.ServerAlert)
+	}
+}
@@ -0,0 +1,90 @@
+package monitoring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/equals215/deepsentinel/alerting/alert"
+	"github.com/equals215/deepsentinel/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSilences(t *testing.T) {
+	config.Server = &config.ServerConfig{ProbeInactivityDelay: "2s"}
+	probe := makeProbe(&Payload{Machine: "machine1", Timestamp: time.Now()})
+	assert.True(t, registerProbe(probe))
+	defer unregisterProbe("machine1")
+
+	// Test case 1: an unknown machine can't be silenced
+	_, err := SilenceProbe("unknown", "", time.Time{}, "")
+	assert.ErrorIs(t, err, ErrProbeNotFound)
+	assert.ErrorIs(t, ClearSilence("machine1", ""), ErrSilenceNotFound)
+
+	// Test case 2: a service silence only suppresses the alerts of this service
+	_, err = SilenceProbe("machine1", "nginx", time.Time{}, "upgrade")
+	assert.Nil(t, err)
+	probe.Lock()
+	assert.True(t, probe.silenced("nginx"))
+	assert.False(t, probe.silenced("redis"))
+	assert.False(t, probe.silenced(""))
+	probe.alert("nginx", alert.New("service", "machine1-nginx", "low"))
+	assert.Contains(t, probe.suppressed, "deepsentinel-service-machine1-nginx")
+	probe.Unlock()
+
+	// Test case 3: a machine silence suppresses every alert of the machine
+	silence, err := SilenceProbe("machine1", "", time.Now().Add(time.Hour), "maintenance")
+	assert.Nil(t, err)
+	assert.Equal(t, "maintenance", silence.Reason)
+	probe.Lock()
+	assert.True(t, probe.silenced("redis"))
+	probe.alert("", alert.New("machine", "machine1", "high"))
+	probe.alert("redis", alert.New("service", "machine1-redis", "low"))
+	assert.Len(t, probe.suppressed, 3)
+	probe.Unlock()
+
+	silences := Silences()
+	assert.Len(t, silences, 2)
+	assert.Equal(t, "", silences[0].Service)
+	assert.Equal(t, "nginx", silences[1].Service)
+
+	// Test case 4: a resolved alert isn't sent when the silence ends, nor its resolution
+	probe.Lock()
+	probe.resolve("redis", alert.New("service", "machine1-redis", "low"))
+	assert.Len(t, probe.suppressed, 2)
+	assert.Empty(t, probe.sent)
+	probe.Unlock()
+
+	// Test case 5: clearing the machine silence sends the alerts that aren't silenced anymore
+	assert.Nil(t, ClearSilence("machine1", ""))
+	assert.Eventually(t, func() bool {
+		probe.Lock()
+		defer probe.Unlock()
+		return len(probe.suppressed) == 1
+	}, time.Second, 10*time.Millisecond)
+	probe.Lock()
+	assert.Contains(t, probe.suppressed, "deepsentinel-service-machine1-nginx")
+	assert.True(t, probe.sent["deepsentinel-machine-machine1"])
+	probe.Unlock()
+
+	// Test case 6: an expired silence is removed and its suppressed alerts are sent
+	probe.Lock()
+	past := time.Now().Add(-time.Second)
+	probe.silences["nginx"].Until = &past
+	assert.False(t, probe.silenced("nginx"))
+	probe.expireSilences()
+	assert.Empty(t, probe.silences)
+	assert.Empty(t, probe.suppressed)
+	probe.Unlock()
+	assert.Empty(t, Silences())
+
+	// Test case 7: an alert sent before a silence is resolved even if its escalation was suppressed
+	_, err = SilenceProbe("machine1", "", time.Time{}, "")
+	assert.Nil(t, err)
+	probe.Lock()
+	probe.alert("", alert.New("machine", "machine1", "high"))
+	assert.Contains(t, probe.suppressed, "deepsentinel-machine-machine1")
+	probe.resolve("", alert.New("machine", "machine1", "high"))
+	assert.Empty(t, probe.suppressed)
+	assert.NotContains(t, probe.sent, "deepsentinel-machine-machine1")
+	probe.Unlock()
+}
@@ -0,0 +1,230 @@
+package monitoring
+
+import (
+	"maps"
+	"sync"
+	"time"
+
+	"github.com/equals215/deepsentinel/store"
+	log "github.com/sirupsen/logrus"
+)
+
+// persistInterval is the minimum delay between two saves of a probe that stays normal
+// Status transitions are always saved right away
+const persistInterval = 30 * time.Second
+
+var persistence store.Store
+
+// state returns the persistable state of the probe, caller must hold the probe lock
+func (p *probeObject) state() *store.ProbeState {
+	state := &store.ProbeState{
+		Name:       p.name,
+		Status:     p.status.String(),
+		Counter:    p.counter,
+		LastNormal: p.lastNormal,
+		Services:   make(map[string]*store.ServiceState),
+	}
+	if !p.declared.IsZero() {
+		declared := p.declared
+		state.Thresholds = &declared
+	}
+	if len(p.declaredLabels) > 0 {
+		state.Labels = maps.Clone(p.declaredLabels)
+	}
+	for _, silence := range p.silences {
+		state.Silences = append(state.Silences, &store.SilenceState{
+			Service:   silence.Service,
+			Until:     silence.end(),
+			Reason:    silence.Reason,
+			CreatedAt: silence.CreatedAt,
+		})
+	}
+
+	p.timeSerie.Lock()
+	defer p.timeSerie.Unlock()
+	if p.timeSerie.head != nil {
+		state.LastReport = p.timeSerie.head.timestamp
+		for service, status := range p.timeSerie.head.services {
+			state.Services[service] = &store.ServiceState{
+				Status:   status.status.String(),
+				Count:    status.count,
+				Flapping: status.flapping,
+			}
+		}
+	}
+	return state
+}
+
+// States returns the persistable state of every probe in their creation order
+func States() []*store.ProbeState {
+	probes := registeredProbes()
+	states := make([]*store.ProbeState, 0, len(probes))
+	for _, probe := range probes {
+		probe.Lock()
+		states = append(states, probe.state())
+		probe.Unlock()
+	}
+	return states
+}
+
+// stateWrites holds the probe states waiting to be written by the background writer, by probe
+// A nil state deletes the probe from the store. A newer state replaces the one still waiting,
+// so a probe is never written back in an older state and slow disks don't hold the probes
+var stateWrites = struct {
+	sync.Mutex
+	pending map[string]*store.ProbeState
+	writing bool
+	idle    *sync.Cond
+}{
+	pending: make(map[string]*store.ProbeState),
+}
+
+func init() {
+	stateWrites.idle = sync.NewCond(&stateWrites.Mutex)
+}
+
+// queueStateWrite queues the state of the probe called name, starting the writer if needed
+func queueStateWrite(name string, state *store.ProbeState) {
+	stateWrites.Lock()
+	defer stateWrites.Unlock()
+
+	stateWrites.pending[name] = state
+	if !stateWrites.writing {
+		stateWrites.writing = true
+		go writeStates()
+	}
+}
+
+// writeStates writes the queued states until none is left
+func writeStates() {
+	for {
+		stateWrites.Lock()
+		if len(stateWrites.pending) == 0 {
+			stateWrites.writing = false
+			stateWrites.idle.Broadcast()
+			stateWrites.Unlock()
+			return
+		}
+		var name string
+		var state *store.ProbeState
+		for name, state = range stateWrites.pending {
+			break
+		}
+		delete(stateWrites.pending, name)
+		stateWrites.Unlock()
+
+		if state == nil {
+			if err := persistence.Delete(name); err != nil {
+				log.WithFields(log.Fields{
+					"probe": name,
+				}).WithError(err).Error("Failed to delete probe state")
+			}
+			continue
+		}
+		if err := persistence.Save(state); err != nil {
+			log.WithFields(log.Fields{
+				"probe": name,
+			}).WithError(err).Error("Failed to persist probe state")
+		}
+	}
+}
+
+// waitStateWrites returns once every state queued so far is written
+func waitStateWrites() {
+	stateWrites.Lock()
+	defer stateWrites.Unlock()
+	for stateWrites.writing {
+		stateWrites.idle.Wait()
+	}
+}
+
+// persist queues the probe state to be saved if a store is configured, caller must hold the probe lock
+func (p *probeObject) persist() {
+	if persistence == nil {
+		return
+	}
+
+	p.lastPersist = time.Now()
+	queueStateWrite(p.name, p.state())
+}
+
+// forget queues the removal of the probe state from the store if one is configured
+func (p *probeObject) forget() {
+	if persistence == nil {
+		return
+	}
+
+	queueStateWrite(p.name, nil)
+}
+
+// restoreProbes loads the probes saved in the store
+func restoreProbes() []*probeObject {
+	if persistence == nil {
+		return nil
+	}
+
+	states, err := persistence.Load()
+	if err != nil {
+		log.WithError(err).Fatal("Failed to load probes state")
+	}
+
+	probes := make([]*probeObject, 0, len(states))
+	for _, state := range states {
+		probe, err := restoreProbe(state)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"probe": state.Name,
+			}).WithError(err).Error("Failed to restore probe, skipping")
+			continue
+		}
+
+		log.WithFields(log.Fields{
+			"probe":      probe.name,
+			"status":     probe.status,
+			"lastNormal": probe.lastNormal,
+		}).Info("Restored probe")
+		probes = append(probes, probe)
+	}
+	return probes
+}
+
+func restoreProbe(state *store.ProbeState) (*probeObject, error) {
+	status, err := stringToProbeStatus(state.Status)
+	if err != nil {
+		return nil, err
+	}
+
+	probe := makeProbe(&Payload{
+		Machine:    state.Name,
+		Timestamp:  state.LastReport,
+		Thresholds: state.Thresholds,
+		Labels:     state.Labels,
+	})
+	probe.status = status
+	probe.publishStatus()
+	probe.counter = state.Counter
+	probe.lastNormal = state.LastNormal
+	probe.lastPersist = time.Now()
+	for _, silence := range state.Silences {
+		probe.silences[silence.Service] = &Silence{
+			Machine:   state.Name,
+			Service:   silence.Service,
+			Until:     untilPointer(silence.Until),
+			Reason:    silence.Reason,
+			CreatedAt: silence.CreatedAt,
+		}
+	}
+
+	for service, serviceState := range state.Services {
+		status, err := stringtoStatusType(serviceState.Status)
+		if err != nil {
+			return nil, err
+		}
+		probe.timeSerie.head.services[service] = &serviceStatus{
+			status:   status,
+			count:    serviceState.Count,
+			flapping: serviceState.Flapping,
+		}
+	}
+	return probe, nil
+}
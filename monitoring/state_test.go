@@ -0,0 +1,90 @@
+package monitoring
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/equals215/deepsentinel/config"
+	"github.com/equals215/deepsentinel/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestoreProbes(t *testing.T) {
+	config.Server = &config.ServerConfig{ProbeInactivityDelay: "2s"}
+	dir := t.TempDir()
+	stateStore, err := store.NewJSONStore(dir)
+	assert.Nil(t, err)
+	persistence = stateStore
+	defer func() { persistence = nil }()
+
+	// Test case 1: a persisted probe is restored with its status, counter and services
+	probe := makeProbe(&Payload{Machine: "machine1", Timestamp: time.Now()})
+	probe.status = alertedLow
+	probe.counter = 4
+	probe.lastNormal = time.Now().Add(-time.Hour).Round(0)
+	probe.timeSerie.head.services["nginx"] = &serviceStatus{status: fail, count: 12}
+	probe.declare(&config.ThresholdsConfig{ProbeInactivityDelay: "1h"})
+	probe.silences["nginx"] = &Silence{Machine: "machine1", Service: "nginx", Reason: "upgrade"}
+	probe.persist()
+	waitStateWrites()
+
+	restored := restoreProbes()
+	assert.Len(t, restored, 1)
+	assert.Equal(t, "machine1", restored[0].name)
+	assert.Equal(t, alertedLow, restored[0].status)
+	assert.Equal(t, 4, restored[0].counter)
+	assert.True(t, probe.lastNormal.Equal(restored[0].lastNormal))
+	assert.Equal(t, &serviceStatus{status: fail, count: 12}, restored[0].timeSerie.head.services["nginx"])
+	assert.Equal(t, time.Hour, restored[0].thresholds.inactivityDelay)
+	assert.Equal(t, "upgrade", restored[0].silences["nginx"].Reason)
+
+	// Test case 2: a forgotten probe is not restored
+	probe.forget()
+	waitStateWrites()
+	assert.Empty(t, restoreProbes())
+
+	// Test case 3: an invalid state is skipped
+	assert.Nil(t, stateStore.Save(&store.ProbeState{Name: "machine2", Status: "unknown"}))
+	assert.Empty(t, restoreProbes())
+
+	// Test case 4: a corrupt snapshot doesn't prevent restoring the other probes
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "machine3.json"), []byte("{"), 0600))
+	probe.persist()
+	waitStateWrites()
+	restored = restoreProbes()
+	assert.Len(t, restored, 1)
+	assert.Equal(t, "machine1", restored[0].name)
+}
+
+func TestHandleShutdown(t *testing.T) {
+	config.Server = &config.ServerConfig{ProbeInactivityDelay: "1h"}
+	stateStore, err := store.NewJSONStore(t.TempDir())
+	assert.Nil(t, err)
+	defer func() { persistence = nil }()
+	defer unregisterProbe("machine1")
+
+	channel := make(chan *Payload)
+	handled := make(chan struct{})
+	go func() {
+		Handle(channel, nil, stateStore)
+		close(handled)
+	}()
+
+	// Test case 1: Handle returns once the channel is closed and the probes saved their latest report
+	reported := time.Now().Add(time.Minute).Round(0)
+	channel <- &Payload{Machine: "machine1", MachineStatus: "pass", Timestamp: time.Now()}
+	channel <- &Payload{Machine: "machine1", MachineStatus: "pass", Timestamp: reported}
+	close(channel)
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Handle didn't return after the channel was closed")
+	}
+
+	states, err := stateStore.Load()
+	assert.Nil(t, err)
+	assert.Len(t, states, 1)
+	assert.True(t, reported.Equal(states[0].LastReport))
+}
@@ -0,0 +1,155 @@
+package monitoring
+
+import (
+	"path"
+	"time"
+
+	"github.com/equals215/deepsentinel/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// thresholds are the escalation settings of a probe once every source is resolved
+type thresholds struct {
+	inactivityDelay         time.Duration
+	degradedToFailed        int
+	failedToAlertedLow      int
+	alertedLowToAlertedHigh int
+}
+
+// resolveThresholds returns the thresholds of machine
+// The server-wide settings are overridden by the first matching probe override,
+// then by the thresholds declared by the agent, bounded by the threshold limits
+func resolveThresholds(machine string, declared config.ThresholdsConfig) thresholds {
+	inactivityDelay, err := time.ParseDuration(config.Server.ProbeInactivityDelay)
+	if err != nil {
+		log.WithError(err).Fatal("Failed to parse inactivity delay")
+	}
+	resolved := thresholds{
+		inactivityDelay:         inactivityDelay,
+		degradedToFailed:        config.Server.DegradedToFailedThreshold,
+		failedToAlertedLow:      config.Server.FailedToAlertedLowThreshold,
+		alertedLowToAlertedHigh: config.Server.AlertedLowToAlertedHighThreshold,
+	}
+
+	for _, override := range config.Server.ProbeOverrides {
+		if matched, _ := path.Match(override.Match, machine); matched {
+			resolved.apply(machine, override.ThresholdsConfig, false)
+			break
+		}
+	}
+	resolved.apply(machine, declared, true)
+	return resolved
+}
+
+// apply overrides the thresholds with the non-zero values of source
+// Values declared by an agent are bounded by the threshold limits
+func (t *thresholds) apply(machine string, source config.ThresholdsConfig, bounded bool) {
+	if source.ProbeInactivityDelay != "" {
+		delay, err := time.ParseDuration(source.ProbeInactivityDelay)
+		if err != nil || delay <= 0 {
+			log.WithFields(log.Fields{
+				"probe": machine,
+				"delay": source.ProbeInactivityDelay,
+			}).Warn("Ignoring invalid probe inactivity delay")
+		} else {
+			if bounded {
+				delay = clampDelay(delay)
+			}
+			t.inactivityDelay = delay
+		}
+	}
+
+	for _, threshold := range []struct {
+		value  int
+		target *int
+	}{
+		{source.DegradedToFailedThreshold, &t.degradedToFailed},
+		{source.FailedToAlertedLowThreshold, &t.failedToAlertedLow},
+		{source.AlertedLowToAlertedHighThreshold, &t.alertedLowToAlertedHigh},
+	} {
+		if threshold.value <= 0 {
+			continue
+		}
+		if bounded {
+			*threshold.target = clampThreshold(threshold.value)
+		} else {
+			*threshold.target = threshold.value
+		}
+	}
+}
+
+func clampDelay(delay time.Duration) time.Duration {
+	limits := config.Server.ThresholdLimits
+	if minDelay, err := time.ParseDuration(limits.MinProbeInactivityDelay); err == nil && delay < minDelay {
+		return minDelay
+	}
+	if maxDelay, err := time.ParseDuration(limits.MaxProbeInactivityDelay); err == nil && delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+func clampThreshold(threshold int) int {
+	limits := config.Server.ThresholdLimits
+	if limits.MinThreshold > 0 && threshold < limits.MinThreshold {
+		return limits.MinThreshold
+	}
+	if limits.MaxThreshold > 0 && threshold > limits.MaxThreshold {
+		return limits.MaxThreshold
+	}
+	return threshold
+}
+
+// declare updates the thresholds declared by the agent, caller must hold the probe lock
+// It returns true when the resolved thresholds changed
+func (p *probeObject) declare(declared *config.ThresholdsConfig) bool {
+	if declared == nil {
+		declared = &config.ThresholdsConfig{}
+	}
+	if *declared == p.declared {
+		return false
+	}
+
+	p.declared = *declared
+	previous := p.thresholds
+	p.thresholds = resolveThresholds(p.name, p.declared)
+	if p.thresholds == previous {
+		return false
+	}
+
+	log.WithFields(log.Fields{
+		"probe":                   p.name,
+		"inactivityDelay":         p.thresholds.inactivityDelay,
+		"degradedToFailed":        p.thresholds.degradedToFailed,
+		"failedToAlertedLow":      p.thresholds.failedToAlertedLow,
+		"alertedLowToAlertedHigh": p.thresholds.alertedLowToAlertedHigh,
+	}).Info("Probe thresholds changed")
+	return true
+}
+
+// warnThresholds returns the consecutive warn reports of service before alerting low
+// and the warn reports after the low alert before alerting high, zero disables them
+// The server-wide settings are overridden by the first service override matching the service and the machine
+func (p *probeObject) warnThresholds(service string) (int, int) {
+	toLow := config.Server.WarnToAlertedLowThreshold
+	toHigh := config.Server.WarnAlertedLowToAlertedHighThreshold
+
+	for _, override := range config.Server.ServiceOverrides {
+		if matched, _ := path.Match(override.Match, service); !matched {
+			continue
+		}
+		if override.Machine != "" {
+			if matched, _ := path.Match(override.Machine, p.name); !matched {
+				continue
+			}
+		}
+		if override.WarnToAlertedLowThreshold != nil {
+			toLow = *override.WarnToAlertedLowThreshold
+		}
+		if override.WarnAlertedLowToAlertedHighThreshold != nil {
+			toHigh = *override.WarnAlertedLowToAlertedHighThreshold
+		}
+		break
+	}
+	return toLow, toHigh
+}
@@ -0,0 +1,165 @@
+package monitoring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/equals215/deepsentinel/config"
+	"github.com/equals215/deepsentinel/journal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveThresholds(t *testing.T) {
+	config.Server = &config.ServerConfig{
+		ProbeInactivityDelay:             "2s",
+		DegradedToFailedThreshold:        10,
+		FailedToAlertedLowThreshold:      20,
+		AlertedLowToAlertedHighThreshold: 30,
+		ProbeOverrides: []config.ProbeOverrideConfig{
+			{Match: "batch-*", ThresholdsConfig: config.ThresholdsConfig{ProbeInactivityDelay: "10m", FailedToAlertedLowThreshold: 3}},
+			{Match: "batch-01", ThresholdsConfig: config.ThresholdsConfig{ProbeInactivityDelay: "1h"}},
+			{Match: "edge-01", ThresholdsConfig: config.ThresholdsConfig{DegradedToFailedThreshold: 1}},
+		},
+		ThresholdLimits: config.ThresholdLimitsConfig{
+			MinProbeInactivityDelay: "1s",
+			MaxProbeInactivityDelay: "1h",
+			MinThreshold:            1,
+			MaxThreshold:            100,
+		},
+	}
+
+	// Test case 1: no override nor declaration uses the server-wide settings
+	assert.Equal(t, thresholds{2 * time.Second, 10, 20, 30}, resolveThresholds("web-01", config.ThresholdsConfig{}))
+
+	// Test case 2: the first matching override wins
+	assert.Equal(t, thresholds{10 * time.Minute, 10, 3, 30}, resolveThresholds("batch-01", config.ThresholdsConfig{}))
+	assert.Equal(t, thresholds{2 * time.Second, 1, 20, 30}, resolveThresholds("edge-01", config.ThresholdsConfig{}))
+
+	// Test case 3: declared thresholds override the matching override
+	declared := config.ThresholdsConfig{ProbeInactivityDelay: "30m", AlertedLowToAlertedHighThreshold: 5}
+	assert.Equal(t, thresholds{30 * time.Minute, 10, 3, 5}, resolveThresholds("batch-02", declared))
+
+	// Test case 4: declared thresholds are bounded by the limits
+	declared = config.ThresholdsConfig{ProbeInactivityDelay: "100ms", DegradedToFailedThreshold: 1000}
+	assert.Equal(t, thresholds{time.Second, 100, 20, 30}, resolveThresholds("web-01", declared))
+	declared = config.ThresholdsConfig{ProbeInactivityDelay: "48h"}
+	assert.Equal(t, time.Hour, resolveThresholds("web-01", declared).inactivityDelay)
+
+	// Test case 5: an invalid declared delay is ignored
+	declared = config.ThresholdsConfig{ProbeInactivityDelay: "soon"}
+	assert.Equal(t, 2*time.Second, resolveThresholds("web-01", declared).inactivityDelay)
+}
+
+func TestProbeDeclare(t *testing.T) {
+	config.Server = &config.ServerConfig{
+		ProbeInactivityDelay:             "2s",
+		DegradedToFailedThreshold:        10,
+		FailedToAlertedLowThreshold:      20,
+		AlertedLowToAlertedHighThreshold: 30,
+	}
+
+	// Test case 1: thresholds declared in the first report are applied
+	probe := makeProbe(&Payload{
+		Machine:    "edge-01",
+		Timestamp:  time.Now(),
+		Thresholds: &config.ThresholdsConfig{ProbeInactivityDelay: "500ms", DegradedToFailedThreshold: 2},
+	})
+	assert.Equal(t, thresholds{500 * time.Millisecond, 2, 20, 30}, probe.thresholds)
+
+	// Test case 2: the same declaration changes nothing
+	assert.False(t, probe.declare(&config.ThresholdsConfig{ProbeInactivityDelay: "500ms", DegradedToFailedThreshold: 2}))
+
+	// Test case 3: a new declaration is applied
+	assert.True(t, probe.declare(&config.ThresholdsConfig{ProbeInactivityDelay: "1s"}))
+	assert.Equal(t, thresholds{time.Second, 10, 20, 30}, probe.thresholds)
+
+	// Test case 4: reports without thresholds fall back to the server settings
+	assert.True(t, probe.declare(nil))
+	assert.Equal(t, thresholds{2 * time.Second, 10, 20, 30}, probe.thresholds)
+
+	// Test case 5: the probe escalates following its own thresholds
+	probe.declare(&config.ThresholdsConfig{DegradedToFailedThreshold: 2})
+	probe.timerIncrement()
+	assert.Equal(t, degraded, probe.status)
+	probe.timerIncrement()
+	assert.Equal(t, degraded, probe.status)
+	probe.timerIncrement()
+	assert.Equal(t, failed, probe.status)
+}
+
+func TestWarnEscalation(t *testing.T) {
+	disabled, one := 0, 1
+	config.Server = &config.ServerConfig{
+		ProbeInactivityDelay:                 "2s",
+		DegradedToFailedThreshold:            1,
+		FailedToAlertedLowThreshold:          1,
+		AlertedLowToAlertedHighThreshold:     1,
+		WarnToAlertedLowThreshold:            2,
+		WarnAlertedLowToAlertedHighThreshold: 0,
+		ServiceOverrides: []config.ServiceOverrideConfig{
+			{Match: "cron-*", WarnToAlertedLowThreshold: &disabled},
+			{Match: "db", Machine: "machine1", WarnToAlertedLowThreshold: &one, WarnAlertedLowToAlertedHighThreshold: &one},
+		},
+	}
+	assert.Nil(t, journal.Init(100, ""))
+	defer journal.Close()
+
+	probe := makeProbe(&Payload{Machine: "machine1", Timestamp: time.Now()})
+	other := makeProbe(&Payload{Machine: "machine2", Timestamp: time.Now()})
+
+	// Test case 1: service overrides match the service, then the machine
+	low, high := probe.warnThresholds("nginx")
+	assert.Equal(t, []int{2, 0}, []int{low, high})
+	low, high = probe.warnThresholds("cron-backup")
+	assert.Equal(t, []int{0, 0}, []int{low, high})
+	low, high = probe.warnThresholds("db")
+	assert.Equal(t, []int{1, 1}, []int{low, high})
+	low, high = other.warnThresholds("db")
+	assert.Equal(t, []int{2, 0}, []int{low, high})
+
+	alerts := func() []journal.Event {
+		waitDeliveries()
+		events := make([]journal.Event, 0)
+		for _, event := range journal.Query(journal.Filter{Machine: "machine1"}) {
+			if event.Alert != nil {
+				events = append(events, event)
+			}
+		}
+		return events
+	}
+	report := func(services map[string]string) {
+		probe.workServices(&Payload{Machine: "machine1", Timestamp: time.Now(), Services: services})
+	}
+
+	// Test case 2: a persistent warn alerts low with the warn reason, disabled services never alert
+	report(map[string]string{"nginx": "warn", "cron-backup": "warn"})
+	report(map[string]string{"nginx": "warn", "cron-backup": "warn"})
+	assert.Len(t, alerts(), 0)
+	report(map[string]string{"nginx": "warn", "cron-backup": "warn"})
+	events := alerts()
+	assert.Len(t, events, 1)
+	assert.Equal(t, journal.KindAlert, events[0].Kind)
+	assert.Equal(t, "nginx", events[0].Service)
+	assert.Equal(t, "low", events[0].Alert.Severity)
+	assert.Equal(t, "warn", events[0].Alert.Reason)
+
+	// Test case 3: consecutive counts don't leak between services
+	report(map[string]string{"nginx": "warn", "db": "warn"})
+	assert.Equal(t, 3, probe.timeSerie.head.services["nginx"].count)
+	assert.Equal(t, 0, probe.timeSerie.head.services["db"].count)
+	assert.Len(t, alerts(), 1, "a fourth warn report must not alert again")
+
+	// Test case 4: a warn override escalates to high
+	report(map[string]string{"db": "warn"})
+	report(map[string]string{"db": "warn"})
+	events = alerts()
+	assert.Equal(t, "db", events[len(events)-1].Service)
+	assert.Equal(t, "high", events[len(events)-1].Alert.Severity)
+
+	// Test case 5: a warn alert is resolved when the service changes status
+	report(map[string]string{"db": "fail"})
+	events = alerts()
+	assert.Equal(t, journal.KindResolution, events[len(events)-1].Kind)
+	assert.Equal(t, "db", events[len(events)-1].Service)
+	assert.Equal(t, "warn", events[len(events)-1].Alert.Reason)
+}
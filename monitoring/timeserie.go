@@ -2,11 +2,11 @@ package monitoring
 
 import (
 	"errors"
+	"math"
 	"sync"
 	"time"
 
-	"github.com/equals215/deepsentinel/alerting"
-	"github.com/equals215/deepsentinel/config"
+	"github.com/equals215/deepsentinel/alerting/alert"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -18,6 +18,20 @@ const (
 	fail
 )
 
+func (s statusType) String() string {
+	return [...]string{"pass", "warn", "fail"}[s]
+}
+
+// ServiceStatusLevel returns the level of a service status, 0 (pass), 1 (warn) or 2 (fail)
+// Unknown statuses return -1
+func ServiceStatusLevel(status string) int {
+	parsed, err := stringtoStatusType(status)
+	if err != nil {
+		return -1
+	}
+	return int(parsed)
+}
+
 func stringtoStatusType(str string) (statusType, error) {
 	strStatus := map[string]statusType{
 		"pass": pass,
@@ -31,9 +45,14 @@ func stringtoStatusType(str string) (statusType, error) {
 	return fail, errors.New("invalid status string")
 }
 
+// machineStatusKey is the time serie entry of the status reported for the whole machine
+// It escalates like a service status but alerts about the machine
+const machineStatusKey = ""
+
 type serviceStatus struct {
-	status statusType
-	count  int
+	status   statusType
+	count    int
+	flapping bool
 }
 
 type timeSerieNode struct {
@@ -60,9 +79,18 @@ func (p *probeObject) workServices(payload *Payload) {
 
 func (p *probeObject) storePayload(payload *Payload) {
 	tempServiceStatus := make(map[string]*serviceStatus)
-	tempCount := 0
 
-	for service, state := range payload.Services {
+	reported := payload.Services
+	if payload.MachineStatus != "" {
+		reported = make(map[string]string, len(payload.Services)+1)
+		for service, state := range payload.Services {
+			reported[service] = state
+		}
+		reported[machineStatusKey] = payload.MachineStatus
+	}
+
+	for service, state := range reported {
+		tempCount := 0
 		parsedStatus, err := stringtoStatusType(state)
 
 		if err != nil {
@@ -81,6 +109,15 @@ func (p *probeObject) storePayload(payload *Payload) {
 			}
 		}
 
+		if p.timeSerie.head != nil {
+			previous, ok := p.timeSerie.head.services[service]
+			if ok && previous.status != parsedStatus {
+				p.recordTransition(service, previous.status.String(), parsedStatus.String(), previous.count, p.serviceLastNormal(service))
+			} else if !ok && parsedStatus != pass {
+				p.recordTransition(service, "", parsedStatus.String(), 0, time.Time{})
+			}
+		}
+
 		tempServiceStatus[service] = &serviceStatus{
 			status: parsedStatus,
 			count:  tempCount,
@@ -103,6 +140,7 @@ func (p *probeObject) storePayload(payload *Payload) {
 
 	p.timeSerie.head = newNode
 	p.timeSerie.size++
+	p.updateFlapping()
 
 	log.WithFields(log.Fields{
 		"probe":   p.name,
@@ -118,40 +156,141 @@ func (p *probeObject) checkAlert() {
 	}
 
 	for service, status := range p.timeSerie.head.services {
-		var alertingStatus string
-		alert := false
+		if status.flapping && !p.wasFlapping(service) {
+			log.WithFields(log.Fields{
+				"probe":   p.name,
+				"machine": p.name,
+				"service": service,
+			}).Warn("Service is flapping. Alerting low")
+			p.alert(service, p.flappingAlert(service))
+		}
 
-		lowThreshhold := config.Server.FailedToAlertedLowThreshold
-		highThreshhold := config.Server.FailedToAlertedLowThreshold + config.Server.AlertedLowToAlertedHighThreshold
+		lowThreshhold, highThreshhold, reason, ok := p.escalation(service, status.status)
+		if !ok || status.count < lowThreshhold {
+			continue
+		}
 
-		if status.status == fail && status.count >= lowThreshhold && status.count < highThreshhold {
-			alertingStatus = "low"
-			alert = true
-		} else if status.status == fail && status.count == highThreshhold {
+		// Each severity is only sent once, on the report reaching its threshold
+		var alertingStatus string
+		switch status.count {
+		case highThreshhold:
 			alertingStatus = "high"
-			alert = true
-		} else if status.status == fail && status.count > highThreshhold {
-			alert = false
-		} else {
+		case lowThreshhold:
+			alertingStatus = "low"
+		default:
+			if status.count%10 == 0 {
+				log.WithFields(log.Fields{
+					"probe":   p.name,
+					"machine": p.name,
+					"service": service,
+					"status":  status.status,
+				}).Warnf("Service still in %s status. Alerady alerted", status.status)
+			}
 			continue
 		}
 
-		if alert {
-			log.WithFields(log.Fields{
-				"probe":   p.name,
-				"machine": p.name,
-				"service": service,
-				"status":  fail,
-			}).Warnf("Service in fail status. Alerting %s", alertingStatus)
-			service = p.name + "-" + service
-			alerting.ServerAlert("service", service, alertingStatus)
-		} else if !alert && status.count%10 == 0 {
+		log.WithFields(log.Fields{
+			"probe":   p.name,
+			"machine": p.name,
+			"service": service,
+			"status":  status.status,
+		}).Warnf("Service in %s status. Alerting %s", status.status, alertingStatus)
+		p.alert(service, p.statusAlert(service, alertingStatus, reason))
+	}
+
+	p.checkResolve()
+}
+
+// escalation returns the consecutive reports of a service in status before alerting low and high,
+// and the reason of these alerts. ok is false when status never alerts
+func (p *probeObject) escalation(service string, status statusType) (lowThreshhold, highThreshhold int, reason string, ok bool) {
+	switch status {
+	case fail:
+		lowThreshhold = p.thresholds.failedToAlertedLow
+		return lowThreshhold, lowThreshhold + p.thresholds.alertedLowToAlertedHigh, alert.ReasonFail, true
+	case warn:
+		toLow, toHigh := p.warnThresholds(service)
+		if toLow == 0 {
+			return 0, 0, "", false
+		}
+		if toHigh == 0 {
+			return toLow, math.MaxInt, alert.ReasonWarn, true
+		}
+		return toLow, toLow + toHigh, alert.ReasonWarn, true
+	default:
+		return 0, 0, "", false
+	}
+}
+
+// checkResolve resolves the alerts of the services that were alerted on the previous report
+// and either changed status or aren't reported anymore
+// A service going from an alerted warn to fail is resolved then escalates on the fail thresholds
+// The flapping alert of a service is resolved once it recovered from flapping
+func (p *probeObject) checkResolve() {
+	if p.timeSerie.head.previous == nil {
+		return
+	}
+
+	for service, previous := range p.timeSerie.head.previous.services {
+		current, reported := p.timeSerie.head.services[service]
+		if previous.flapping && (!reported || !current.flapping) {
 			log.WithFields(log.Fields{
 				"probe":   p.name,
 				"machine": p.name,
 				"service": service,
-				"status":  fail,
-			}).Warn("Service still in fail status. Alerady alerted")
+			}).Info("Service stopped flapping. Resolving alert")
+			p.resolve(service, p.flappingAlert(service))
+		}
+
+		lowThreshhold, highThreshhold, reason, ok := p.escalation(service, previous.status)
+		if !ok || previous.count < lowThreshhold {
+			continue
+		}
+		if reported && current.status == previous.status {
+			continue
+		}
+
+		severity := "low"
+		if previous.count >= highThreshhold {
+			severity = "high"
+		}
+
+		log.WithFields(log.Fields{
+			"probe":   p.name,
+			"machine": p.name,
+			"service": service,
+		}).Info("Service recovered. Resolving alert")
+		p.resolve(service, p.statusAlert(service, severity, reason))
+	}
+}
+
+// statusAlert creates the alert about service, or about the status reported for the machine
+// The machine status alert has its own component so it doesn't share the inactivity alerts incident
+func (p *probeObject) statusAlert(service, severity, reason string) *alert.Alert {
+	category, component := "service", p.name+"-"+service
+	if service == machineStatusKey {
+		category, component = "machine", p.name+"-status"
+	}
+	return alert.New(category, component, severity).WithReason(reason).WithLastNormal(p.serviceLastNormal(service))
+}
+
+// flappingAlert returns the alert of a flapping service, its own component keeps it apart from the status alerts
+func (p *probeObject) flappingAlert(service string) *alert.Alert {
+	a := p.statusAlert(service, "low", alert.ReasonFlapping)
+	a.Component += "-flapping"
+	return a
+}
+
+// serviceLastNormal returns the timestamp of the latest report where the service passed
+// or the oldest report kept if it never passed since, caller must hold the timeserie lock
+func (p *probeObject) serviceLastNormal(service string) time.Time {
+	var oldest time.Time
+
+	for node := p.timeSerie.head; node != nil; node = node.previous {
+		if status, ok := node.services[service]; ok && status.status == pass {
+			return node.timestamp
 		}
+		oldest = node.timestamp
 	}
+	return oldest
 }
@@ -0,0 +1,78 @@
+package monitoring
+
+import (
+	"testing"
+	"time"
+
+	"github.com/equals215/deepsentinel/config"
+	"github.com/equals215/deepsentinel/journal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMachineStatus(t *testing.T) {
+	config.Server = &config.ServerConfig{
+		ProbeInactivityDelay:             "2s",
+		DegradedToFailedThreshold:        1,
+		FailedToAlertedLowThreshold:      1,
+		AlertedLowToAlertedHighThreshold: 1,
+	}
+	assert.Nil(t, journal.Init(100, ""))
+	defer journal.Close()
+
+	probe := makeProbe(&Payload{Machine: "machine1", Timestamp: time.Now()})
+	report := func(machineStatus string) {
+		probe.workServices(&Payload{
+			Machine:       "machine1",
+			Timestamp:     time.Now(),
+			MachineStatus: machineStatus,
+			Services:      map[string]string{"nginx": "pass"},
+		})
+	}
+
+	// Test case 1: the reported machine status is stored apart from the services
+	report("pass")
+	info := probe.info()
+	assert.Equal(t, &ServiceInfo{Status: "pass", Count: 0}, info.MachineStatus)
+	assert.Len(t, info.Services, 1)
+
+	// Test case 2: a failing machine escalates on the fail thresholds and alerts about the machine
+	report("fail")
+	report("fail")
+	report("fail")
+	waitDeliveries()
+	alerts := journal.Query(journal.Filter{Machine: "machine1"})
+	var severities []string
+	for _, event := range alerts {
+		if event.Kind == journal.KindAlert {
+			assert.Equal(t, "", event.Service)
+			assert.Equal(t, "fail", event.Alert.Reason)
+			severities = append(severities, event.Alert.Severity)
+		}
+	}
+	assert.Equal(t, []string{"low", "high"}, severities)
+	a := probe.statusAlert(machineStatusKey, "low", "fail")
+	assert.Equal(t, "machine", a.Category)
+	assert.Equal(t, "deepsentinel-machine-machine1-status", a.DedupKey())
+
+	// Test case 3: a machine that stops reporting its status is resolved
+	report("")
+	waitDeliveries()
+	events := journal.Query(journal.Filter{Machine: "machine1", Limit: 1})
+	assert.Equal(t, journal.KindResolution, events[0].Kind)
+	assert.Equal(t, "high", events[0].Alert.Severity)
+	assert.Nil(t, probe.info().MachineStatus)
+}
+
+func TestPayloadValidate(t *testing.T) {
+	// Test case 1: known machine statuses are accepted
+	for _, status := range []string{"", "pass", "warn", "fail"} {
+		assert.Nil(t, (&Payload{MachineStatus: status}).Validate())
+	}
+
+	// Test case 2: unknown machine statuses, including delete, are rejected
+	assert.Equal(t, ErrInvalidMachineStatus, (&Payload{MachineStatus: "delete"}).Validate())
+	assert.Equal(t, ErrInvalidMachineStatus, (&Payload{MachineStatus: "ok"}).Validate())
+
+	// Test case 3: a service without name is rejected
+	assert.Equal(t, ErrEmptyServiceName, (&Payload{Services: map[string]string{"": "pass"}}).Validate())
+}
@@ -33,6 +33,12 @@ func (p *probeObject) trimTimeSerie() {
 		currentNode = currentNode.previous
 	}
 
+	// Flap detection looks back at the latest reports whatever their status
+	if keep := flapHistory(); keep > 1 && (historicalAllPass || count < keep) {
+		p.trimToNode(min(keep, p.timeSerie.size))
+		return
+	}
+
 	if historicalAllPass {
 		// If all historical data is pass, keep only the latest node
 		p.trimToLastNode()
@@ -71,7 +77,7 @@ func (p *probeObject) trimToNode(count int) {
 		currentNode = currentNode.previous
 	}
 	currentNode.previous = nil
-	p.timeSerie.size = trimTimeSeriesThreshold
+	p.timeSerie.size = count
 	log.WithFields(log.Fields{
 		"probe": p.name,
 		"size":  p.timeSerie.size,
@@ -7,6 +7,8 @@ import (
 	"strings"
 
 	"github.com/equals215/deepsentinel/config"
+	"github.com/equals215/deepsentinel/tlsconfig"
+	"github.com/equals215/deepsentinel/tokens"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/basicauth"
 	"github.com/gofiber/fiber/v2/middleware/keyauth"
@@ -15,31 +17,55 @@ import (
 var (
 	apiProtectedURLs = []*regexp.Regexp{
 		regexp.MustCompile("^/probe(/.*)?$"),
+		regexp.MustCompile("^/probes/?$"),
+		regexp.MustCompile("^/silences/?$"),
+		regexp.MustCompile("^/events/?$"),
+		regexp.MustCompile("^/groups/?$"),
+		regexp.MustCompile("^/checkins?(/.*)?$"),
+		regexp.MustCompile("^/pending(/.*)?$"),
+		regexp.MustCompile("^/tokens(/.*)?$"),
+		regexp.MustCompile("^/cluster(/.*)?$"),
 	}
 	dashboardProtectedURLs = []*regexp.Regexp{
-		regexp.MustCompile("^/dashboard$"),
+		regexp.MustCompile("^/dashboard/?$"),
 	}
 	dashboardWSprotectedURLs = []*regexp.Regexp{
 		regexp.MustCompile("^/dashws(/.*)?$"),
 	}
+	metricsProtectedURLs = []*regexp.Regexp{
+		regexp.MustCompile("^/metrics/?$"),
+	}
+	// machineURL matches the routes a machine token may access, the probe of its machines
+	machineURL = regexp.MustCompile("^/probe/([^/]+)(/.*)?$")
 )
 
 func authFilterAPI(c *fiber.Ctx) bool {
-	originalURL := strings.ToLower(c.OriginalURL())
+	path := strings.ToLower(c.Path())
 
 	for _, pattern := range apiProtectedURLs {
-		if pattern.MatchString(originalURL) {
-			return false
+		if pattern.MatchString(path) {
+			return validateClientCert(c)
 		}
 	}
 	return true
 }
 
 func authFilterDashboardWS(c *fiber.Ctx) bool {
-	originalURL := strings.ToLower(c.OriginalURL())
+	path := strings.ToLower(c.Path())
 
 	for _, pattern := range dashboardWSprotectedURLs {
-		if pattern.MatchString(originalURL) {
+		if pattern.MatchString(path) {
+			return false
+		}
+	}
+	return true
+}
+
+func authFilterMetrics(c *fiber.Ctx) bool {
+	path := strings.ToLower(c.Path())
+
+	for _, pattern := range metricsProtectedURLs {
+		if pattern.MatchString(path) {
 			return false
 		}
 	}
@@ -47,26 +73,64 @@ func authFilterDashboardWS(c *fiber.Ctx) bool {
 }
 
 func authFilterDashboard(c *fiber.Ctx) bool {
-	originalURL := strings.ToLower(c.OriginalURL())
+	path := strings.ToLower(c.Path())
 
 	for _, pattern := range dashboardProtectedURLs {
-		if pattern.MatchString(originalURL) {
+		if pattern.MatchString(path) {
 			return false
 		}
 	}
 	return true
 }
 
-func validateAuth(_ *fiber.Ctx, givenKey string) (bool, error) {
-	hashedKey := sha256.Sum256([]byte(config.Server.AuthToken))
-	hashedGivenKey := sha256.Sum256([]byte(givenKey))
-
-	if subtle.ConstantTimeCompare(hashedKey[:], hashedGivenKey[:]) == 1 {
+// validateAuth accepts the global token on every route
+// and machine tokens on the routes of the probes of their machines
+func validateAuth(c *fiber.Ctx, givenKey string) (bool, error) {
+	if validateAdminToken(givenKey) {
 		return true, nil
 	}
+	if c != nil {
+		if match := machineURL.FindStringSubmatch(c.Path()); match != nil {
+			token, ok := tokens.Lookup(givenKey)
+			if ok && token.Allows(strings.TrimSpace(match[1])) {
+				return true, nil
+			}
+		}
+	}
 	return false, keyauth.ErrMissingOrMalformedAPIKey
 }
 
+// validateClientCert returns true when the verified client certificate names the machine of a probe route
+// Such requests don't need a token
+func validateClientCert(c *fiber.Ctx) bool {
+	match := machineURL.FindStringSubmatch(c.Path())
+	if match == nil {
+		return false
+	}
+	machine := strings.TrimSpace(match[1])
+	for _, identity := range tlsconfig.Identities(c.Context().TLSConnectionState()) {
+		if identity == machine {
+			return true
+		}
+	}
+	return false
+}
+
+// validateAdminToken returns true when givenKey is the global token, which is disabled when empty
+func validateAdminToken(givenKey string) bool {
+	if config.Server.AuthToken == "" {
+		return false
+	}
+	hashedKey := sha256.Sum256([]byte(config.Server.AuthToken))
+	hashedGivenKey := sha256.Sum256([]byte(givenKey))
+
+	return subtle.ConstantTimeCompare(hashedKey[:], hashedGivenKey[:]) == 1
+}
+
+func validateAdminAuth(user, pass string) bool {
+	return user == "admin" && validateAdminToken(pass)
+}
+
 func fiberSetAuth(app *fiber.App) {
 	app.Use(keyauth.New(keyauth.Config{
 		Next:      authFilterAPI,
@@ -75,18 +139,15 @@ func fiberSetAuth(app *fiber.App) {
 	}))
 
 	app.Use(basicauth.New(basicauth.Config{
-		Next:  authFilterDashboardWS,
-		Realm: "Dashboard",
-		Authorizer: func(user, pass string) bool {
-			if user == "admin" {
-				ok, err := validateAuth(nil, pass)
-				if err != nil {
-					return false
-				}
-				return ok
-			}
-			return false
-		},
+		Next:       authFilterDashboardWS,
+		Realm:      "Dashboard",
+		Authorizer: validateAdminAuth,
+	}))
+
+	app.Use(basicauth.New(basicauth.Config{
+		Next:       authFilterMetrics,
+		Realm:      "Metrics",
+		Authorizer: validateAdminAuth,
 	}))
 
 	app.Use(keyauth.New(keyauth.Config{
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/equals215/deepsentinel/cluster"
+	"github.com/equals215/deepsentinel/monitoring"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+func getCheckinsHandler(c *fiber.Ctx) error {
+	return c.JSON(monitoring.Checkins())
+}
+
+// checkinHandler handles the signals of the jobs, /checkin/<name> alone is a success
+// GET and POST are both accepted so that a job can check in with a bare curl
+func checkinHandler(c *fiber.Ctx) error {
+	if c.Method() != fiber.MethodGet && c.Method() != fiber.MethodPost {
+		return c.SendStatus(fiber.StatusMethodNotAllowed)
+	}
+	name := strings.TrimSpace(utils.CopyString(c.Params("name")))
+	signal := utils.CopyString(c.Params("signal", monitoring.SignalSuccess))
+
+	at := time.Now()
+	err := monitoring.Checkin(name, signal, at)
+	if errors.Is(err, monitoring.ErrCheckinNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "fail",
+			"checkin": name,
+			"error":   err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "fail",
+			"checkin": name,
+			"error":   err.Error(),
+		})
+	}
+
+	cluster.Replicate(&cluster.Message{
+		Kind:      cluster.KindCheckin,
+		Machine:   name,
+		Timestamp: at,
+		Signal:    signal,
+	})
+	return c.JSON(fiber.Map{
+		"status":  "pass",
+		"checkin": name,
+		"signal":  signal,
+	})
+}
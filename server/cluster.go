@@ -0,0 +1,119 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/equals215/deepsentinel/cluster"
+	"github.com/equals215/deepsentinel/monitoring"
+	"github.com/equals215/deepsentinel/tokens"
+	"github.com/gofiber/fiber/v2"
+)
+
+func getClusterHandler(c *fiber.Ctx) error {
+	return c.JSON(cluster.CurrentStatus())
+}
+
+func getClusterStateHandler(c *fiber.Ctx) error {
+	return c.JSON(monitoring.States())
+}
+
+// postClusterReplicateHandler applies an operation replicated by a peer
+// Replicated operations aren't replicated again
+func postClusterReplicateHandler(c *fiber.Ctx, payloadChannel chan *monitoring.Payload) error {
+	msg := &cluster.Message{}
+	if err := json.Unmarshal(c.Body(), msg); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "fail",
+			"error":  err.Error(),
+		})
+	}
+	if msg.Machine == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "fail",
+			"error":  "machine name is required",
+		})
+	}
+
+	var err error
+	switch msg.Kind {
+	case cluster.KindReport:
+		payload := &monitoring.Payload{}
+		if err := json.Unmarshal(msg.Report, payload); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "fail",
+				"machine": msg.Machine,
+				"error":   err.Error(),
+			})
+		}
+		if err := payload.Validate(); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "fail",
+				"machine": msg.Machine,
+				"error":   err.Error(),
+			})
+		}
+		payload.Machine = msg.Machine
+		payload.Timestamp = msg.Timestamp
+		if reportTracker != nil && msg.Sequence > 0 {
+			// The report can't be replayed to this server either
+			reportTracker.Observe(msg.Machine, msg.Sequence)
+		}
+		payloadChannel <- payload
+	case cluster.KindDelete:
+		payloadChannel <- &monitoring.Payload{
+			Machine:       msg.Machine,
+			MachineStatus: "delete",
+			Timestamp:     msg.Timestamp,
+		}
+	case cluster.KindSilence:
+		_, err = monitoring.SilenceProbe(msg.Machine, msg.Service, msg.Until, msg.Reason)
+	case cluster.KindClearSilence:
+		err = monitoring.ClearSilence(msg.Machine, msg.Service)
+	case cluster.KindApprove:
+		err = monitoring.ApproveMachine(msg.Machine)
+	case cluster.KindReject:
+		err = monitoring.RejectMachine(msg.Machine)
+	case cluster.KindToken:
+		if msg.Token == nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status": "fail",
+				"error":  "token is required",
+			})
+		}
+		err = tokens.Add(msg.Token)
+	case cluster.KindRevokeToken:
+		err = tokens.Revoke(msg.Machine)
+	case cluster.KindCheckin:
+		err = monitoring.Checkin(msg.Machine, msg.Signal, msg.Timestamp)
+	default:
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "fail",
+			"machine": msg.Machine,
+			"error":   "unknown message kind " + msg.Kind,
+		})
+	}
+
+	if errors.Is(err, monitoring.ErrInvalidSignal) || errors.Is(err, monitoring.ErrMachineNameRequired) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "fail",
+			"machine": msg.Machine,
+			"error":   err.Error(),
+		})
+	}
+	if errors.Is(err, monitoring.ErrProbeNotFound) || errors.Is(err, monitoring.ErrSilenceNotFound) || errors.Is(err, monitoring.ErrCheckinNotFound) ||
+		errors.Is(err, monitoring.ErrPendingNotFound) || errors.Is(err, tokens.ErrTokenNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "fail",
+			"machine": msg.Machine,
+			"error":   err.Error(),
+		})
+	}
+	if err != nil && (msg.Kind == cluster.KindToken || msg.Kind == cluster.KindRevokeToken) {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status": "fail",
+			"error":  err.Error(),
+		})
+	}
+	return c.SendStatus(fiber.StatusAccepted)
+}
@@ -1,12 +1,24 @@
 package server
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/equals215/deepsentinel/alerting"
+	"github.com/equals215/deepsentinel/alerting/alert"
+	"github.com/equals215/deepsentinel/cluster"
 	"github.com/equals215/deepsentinel/config"
 	"github.com/equals215/deepsentinel/dashboard"
+	"github.com/equals215/deepsentinel/heartbeat"
+	"github.com/equals215/deepsentinel/journal"
 	"github.com/equals215/deepsentinel/monitoring"
+	"github.com/equals215/deepsentinel/store"
+	"github.com/equals215/deepsentinel/tlsconfig"
+	"github.com/equals215/deepsentinel/tokens"
 	"github.com/grongor/panicwatch"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -16,6 +28,7 @@ import (
 func Cmd(rootCmd *cobra.Command) {
 	var noAlerting bool
 	var noDash bool
+	var noMetrics bool
 	payloadChannel := make(chan *monitoring.Payload)
 
 	serverCmd := &cobra.Command{
@@ -29,50 +42,201 @@ func Cmd(rootCmd *cobra.Command) {
 			alerting.Init(config.Server, noAlerting)
 		},
 		Run: func(cmd *cobra.Command, args []string) {
-			var dashboardOperator *dashboard.Operator
-			config.PrintServerConfig()
-
-			if !noDash {
-				dashboardOperator = dashboard.Handle()
-			} else {
-				log.Warn("Dashboard disabled")
-			}
-			go monitoring.Handle(payloadChannel, dashboardOperator)
-
-			addr := fmt.Sprintf("%s:%d", config.Server.ListeningAddress, config.Server.Port)
-			newServer(payloadChannel, dashboardOperator).Listen(addr)
-			// Start panicwatch to catch panics
+			// Start panicwatch first so that it catches the panics of the whole run
 			err := panicwatch.Start(panicwatch.Config{
 				OnPanic: func(p panicwatch.Panic) {
-					alerting.ServerAlert("deepsentinel", "server", "panic")
+					alerting.ServerAlert(alert.New("deepsentinel", "server", "panic"))
 				},
 				OnWatcherDied: func(err error) {
 					log.Error("panic watcher process died")
-					alerting.ServerAlert("deepsentinel", "panicwatcher", "low")
+					alerting.ServerAlert(alert.New("deepsentinel", "panicwatcher", "low"))
 				},
 			})
 			if err != nil {
 				log.Fatalf("failed to start panicwatch: %s", err.Error())
 			}
 			log.Info("Panicwatch started")
+
+			var dashboardOperator *dashboard.Operator
+			config.PrintServerConfig()
+
+			if !noDash {
+				dashboardOperator = dashboard.Handle()
+			} else {
+				log.Warn("Dashboard disabled")
+			}
+
+			if err := journal.Init(config.Server.Journal.Capacity, config.Server.Journal.Path); err != nil {
+				log.Fatalf("failed to open events journal: %s", err.Error())
+			}
+
+			if err := tokens.Init(config.Server.Tokens.Path); err != nil {
+				log.Fatalf("failed to open machine tokens: %s", err.Error())
+			}
+
+			stateStore, err := store.New(config.Server.State.Backend, config.Server.State.Path)
+			if err != nil {
+				log.Fatalf("failed to open state store: %s", err.Error())
+			}
+
+			var tlsFiles *tlsconfig.Files
+			if config.Server.TLS.Enabled() {
+				tlsFiles, err = tlsconfig.Load(config.Server.TLS.CertFile, config.Server.TLS.KeyFile, config.Server.TLS.ClientCAFile)
+				if err != nil {
+					log.Fatalf("failed to load TLS files: %s", err.Error())
+				}
+			}
+
+			running := &lifecycle{
+				payloads:   payloadChannel,
+				handled:    make(chan struct{}),
+				stateStore: stateStore,
+			}
+
+			if config.Server.Cluster.Enabled() {
+				node, err := cluster.Init(config.Server.Cluster, config.Server.AuthToken)
+				if err != nil {
+					log.Fatalf("failed to join cluster: %s", err.Error())
+				}
+				if tlsFiles != nil || config.Server.TLS.PeerCAFile != "" {
+					// Peers are verified against their own CA bundle, the client one only verifies the agents,
+					// and peers requiring client certificates are presented the server certificate
+					peerFiles, err := tlsconfig.Load(config.Server.TLS.CertFile, config.Server.TLS.KeyFile, config.Server.TLS.PeerCAFile)
+					if err != nil {
+						log.Fatalf("failed to load TLS files: %s", err.Error())
+					}
+					node.SetTLSConfig(tlsconfig.Client(peerFiles))
+				}
+				states, err := node.FetchState()
+				if err != nil {
+					log.WithError(err).Warn("Failed to fetch probes state from the cluster peers, starting from the local state")
+				} else {
+					stateStore = cluster.SeedStore(stateStore, states)
+				}
+				node.Start()
+				running.node = node
+			}
+			go func() {
+				monitoring.Handle(payloadChannel, dashboardOperator, stateStore)
+				close(running.handled)
+			}()
+
+			if config.Server.Heartbeat.Enabled() {
+				pinger, err := heartbeat.New(config.Server.Heartbeat, heartbeat.Healthy)
+				if err != nil {
+					log.Fatalf("failed to configure heartbeat: %s", err.Error())
+				}
+				pinger.Start()
+				running.pinger = pinger
+			}
+
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+			addr := fmt.Sprintf("%s:%d", config.Server.ListeningAddress, config.Server.Port)
+			running.app = newServer(payloadChannel, dashboardOperator, noMetrics)
+			served := make(chan error, 1)
+			go func() {
+				if tlsFiles == nil {
+					served <- running.app.Listen(addr)
+					return
+				}
+				listener, err := net.Listen("tcp", addr)
+				if err != nil {
+					served <- err
+					return
+				}
+				served <- running.app.Listener(tls.NewListener(listener, tlsconfig.Server(tlsFiles, config.Server.TLS.RequireClientCert)))
+			}()
+
+			select {
+			case sig := <-signals:
+				log.WithField("signal", sig.String()).Info("Signal received")
+			case err := <-served:
+				log.Fatalf("failed to serve on %s: %s", addr, err.Error())
+			}
+			// The timeout was validated with the configuration
+			timeout, _ := config.Server.ParsedShutdownTimeout()
+			running.shutdown(timeout)
 		},
 	}
 	serverCmd.Flags().BoolVarP(&noAlerting, "no-alert", "", false, "Disable alerting")
 	serverCmd.Flags().BoolVarP(&noDash, "no-dashboard", "", false, "Disable dashboard")
+	serverCmd.Flags().BoolVarP(&noMetrics, "no-metrics", "", false, "Disable the /metrics endpoint")
 	serverCmd.Flags().String("address", "0.0.0.0", "Listening address\nEnvironment variable: DEEPSENTINEL_ADDRESS\n\b")
 	serverCmd.Flags().String("port", "5000", "Listening port\nEnvironment variable: DEEPSENTINEL_PORT\n\b")
 	serverCmd.Flags().String("probe-inactivity-delay", "2s", "Delay before considering a probe inactive\nEnvironment variable: DEEPSENTINEL_PROBE_INACTIVITY_DELAY\n\b")
 	serverCmd.Flags().Int("degraded-to-failed", 10, "Number of degraded event before considering a probe or service as failed\nEnvironment variable: DEEPSENTINEL_DEGRADED_TO_FAILED\n\b")
 	serverCmd.Flags().Int("failed-to-alertLow", 20, "Number of failed event before alerting low\nEnvironment variable: DEEPSENTINEL_FAILED_TO_ALERT_LOW\n\b")
 	serverCmd.Flags().Int("alertLow-to-alertHigh", 30, "Number of alertLow event before alerting high\nEnvironment variable: DEEPSENTINEL_ALERT_LOW_TO_ALERT_HIGH\n\b")
+	serverCmd.Flags().Int("warn-to-alertLow", 0, "Number of consecutive warn reports of a service before alerting low, 0 never alerts on warn\nEnvironment variable: DEEPSENTINEL_WARN_TO_ALERT_LOW\n\b")
+	serverCmd.Flags().Int("warn-alertLow-to-alertHigh", 0, "Number of warn reports after the low alert before alerting high, 0 never escalates warn to high\nEnvironment variable: DEEPSENTINEL_WARN_ALERT_LOW_TO_ALERT_HIGH\n\b")
+	serverCmd.Flags().Int("flap-detection.window", 0, "Number of latest reports of a service checked for flapping, 0 disables flap detection\nEnvironment variable: DEEPSENTINEL_FLAP_DETECTION_WINDOW\n\b")
+	serverCmd.Flags().Int("flap-detection.threshold", 8, "Number of status changes within the window marking a service as flapping, 0 disables flap detection\nEnvironment variable: DEEPSENTINEL_FLAP_DETECTION_THRESHOLD\n\b")
+	serverCmd.Flags().Int("flap-detection.recovery-passes", 10, "Number of consecutive pass reports before a flapping service recovers\nEnvironment variable: DEEPSENTINEL_FLAP_DETECTION_RECOVERY_PASSES\n\b")
+	serverCmd.Flags().String("threshold-limits.min-probe-inactivity-delay", "1s", "Minimum probe inactivity delay an agent can declare\nEnvironment variable: DEEPSENTINEL_THRESHOLD_LIMITS_MIN_PROBE_INACTIVITY_DELAY\n\b")
+	serverCmd.Flags().String("threshold-limits.max-probe-inactivity-delay", "24h", "Maximum probe inactivity delay an agent can declare\nEnvironment variable: DEEPSENTINEL_THRESHOLD_LIMITS_MAX_PROBE_INACTIVITY_DELAY\n\b")
+	serverCmd.Flags().Int("threshold-limits.min-threshold", 1, "Minimum escalation threshold an agent can declare\nEnvironment variable: DEEPSENTINEL_THRESHOLD_LIMITS_MIN_THRESHOLD\n\b")
+	serverCmd.Flags().Int("threshold-limits.max-threshold", 1000, "Maximum escalation threshold an agent can declare\nEnvironment variable: DEEPSENTINEL_THRESHOLD_LIMITS_MAX_THRESHOLD\n\b")
+	serverCmd.Flags().String("shutdown-timeout", "30s", "Delay given to the server to stop gracefully on SIGINT or SIGTERM\nEnvironment variable: DEEPSENTINEL_SHUTDOWN_TIMEOUT\n\b")
 	serverCmd.Flags().String("logging-level", "info", "Logging level\nEnvironment variable: DEEPSENTINEL_LOGGING_LEVEL\n\b")
-	serverCmd.Flags().String("low-alert-provider", "", "Low alert provider name\nEnvironment variable: DEEPSENTINEL_LOW_ALERT_PROVIDER\n\b")
-	serverCmd.Flags().String("high-alert-provider", "", "High alert provider name\nEnvironment variable: DEEPSENTINEL_HIGH_ALERT_PROVIDER\n\b")
+	serverCmd.Flags().String("state.backend", "", "State backend used to persist probes across restarts (json)\nEnvironment variable: DEEPSENTINEL_STATE_BACKEND\n\b")
+	serverCmd.Flags().String("state.path", "/etc/deepsentinel/state", "Path used by the state backend\nEnvironment variable: DEEPSENTINEL_STATE_PATH\n\b")
+	serverCmd.Flags().Int("journal.capacity", journal.DefaultCapacity, "Number of events kept in the events journal\nEnvironment variable: DEEPSENTINEL_JOURNAL_CAPACITY\n\b")
+	serverCmd.Flags().String("journal.path", "", "JSON lines file persisting the events journal, kept in memory when empty\nEnvironment variable: DEEPSENTINEL_JOURNAL_PATH\n\b")
+	serverCmd.Flags().String("tls.cert-file", "", "Certificate served over HTTPS, reloaded once rotated, plain HTTP is served when empty\nEnvironment variable: DEEPSENTINEL_TLS_CERT_FILE\n\b")
+	serverCmd.Flags().String("tls.key-file", "", "Key of the served certificate\nEnvironment variable: DEEPSENTINEL_TLS_KEY_FILE\n\b")
+	serverCmd.Flags().String("tls.client-ca-file", "", "CA bundle verifying the client certificates, whose names authenticate as the machine of the same name\nEnvironment variable: DEEPSENTINEL_TLS_CLIENT_CA_FILE\n\b")
+	serverCmd.Flags().Bool("tls.require-client-cert", false, "Reject the connections without a valid client certificate\nEnvironment variable: DEEPSENTINEL_TLS_REQUIRE_CLIENT_CERT\n\b")
+	serverCmd.Flags().String("tls.peer-ca-file", "", "CA bundle verifying the certificates of the cluster peers served over HTTPS, the system roots when empty\nEnvironment variable: DEEPSENTINEL_TLS_PEER_CA_FILE\n\b")
+	serverCmd.Flags().String("signed-reports.mode", "off", "Report signatures check (off, optional or required)\nEnvironment variable: DEEPSENTINEL_SIGNED_REPORTS_MODE\n\b")
+	serverCmd.Flags().String("signed-reports.secret", "", "Secret shared with the agents signing the reports\nEnvironment variable: DEEPSENTINEL_SIGNED_REPORTS_SECRET\n\b")
+	serverCmd.Flags().String("signed-reports.max-skew", "30s", "Clock skew tolerated between the report signature and the server\nEnvironment variable: DEEPSENTINEL_SIGNED_REPORTS_MAX_SKEW\n\b")
+	serverCmd.Flags().String("tokens.path", tokens.DefaultPath, "JSON file holding the hashed machine tokens\nEnvironment variable: DEEPSENTINEL_TOKENS_PATH\n\b")
+	serverCmd.Flags().String("cluster.node-id", "", "Unique ID of this server in the cluster, the lowest alive ID is the leader\nEnvironment variable: DEEPSENTINEL_CLUSTER_NODE_ID\n\b")
+	serverCmd.Flags().String("cluster.peers", "", "Base URLs of the other servers of the cluster, comma separated\nEnvironment variable: DEEPSENTINEL_CLUSTER_PEERS\n\b")
+	serverCmd.Flags().String("cluster.heartbeat-interval", "1s", "Delay between two heartbeats to the cluster peers\nEnvironment variable: DEEPSENTINEL_CLUSTER_HEARTBEAT_INTERVAL\n\b")
+	serverCmd.Flags().String("cluster.peer-timeout", "5s", "Delay without heartbeat answer before a cluster peer is lost\nEnvironment variable: DEEPSENTINEL_CLUSTER_PEER_TIMEOUT\n\b")
+	serverCmd.Flags().String("registration.policy", "open", "Registration policy of new machines (open or approval)\nEnvironment variable: DEEPSENTINEL_REGISTRATION_POLICY\n\b")
+	serverCmd.Flags().String("registration.allowlist", "", "Machine names or globs registered without approval, comma separated\nEnvironment variable: DEEPSENTINEL_REGISTRATION_ALLOWLIST\n\b")
+	serverCmd.Flags().Int("registration.max-probes", 0, "Maximum number of probes, 0 doesn't cap them\nEnvironment variable: DEEPSENTINEL_REGISTRATION_MAX_PROBES\n\b")
+	serverCmd.Flags().String("heartbeat.url", "", "URL of an external dead man's switch checked in while monitoring and alerting are healthy, disabled when empty\nEnvironment variable: DEEPSENTINEL_HEARTBEAT_URL\n\b")
+	serverCmd.Flags().String("heartbeat.method", "GET", "Heartbeat HTTP method\nEnvironment variable: DEEPSENTINEL_HEARTBEAT_METHOD\n\b")
+	serverCmd.Flags().StringToString("heartbeat.headers", nil, "Heartbeat custom headers (key=value,...)\nEnvironment variable: DEEPSENTINEL_HEARTBEAT_HEADERS (JSON object)\n\b")
+	serverCmd.Flags().String("heartbeat.body", "", "Heartbeat request body\nEnvironment variable: DEEPSENTINEL_HEARTBEAT_BODY\n\b")
+	serverCmd.Flags().String("heartbeat.interval", "1m", "Delay between two heartbeats\nEnvironment variable: DEEPSENTINEL_HEARTBEAT_INTERVAL\n\b")
+	serverCmd.Flags().String("low-alert-provider", "", "Low alert provider names, comma separated\nEnvironment variable: DEEPSENTINEL_LOW_ALERT_PROVIDER\n\b")
+	serverCmd.Flags().String("high-alert-provider", "", "High alert provider names, comma separated\nEnvironment variable: DEEPSENTINEL_HIGH_ALERT_PROVIDER\n\b")
+	serverCmd.Flags().String("low-alert-policy", "all", "Low alert delivery policy (all, failover or quorum)\nEnvironment variable: DEEPSENTINEL_LOW_ALERT_POLICY\n\b")
+	serverCmd.Flags().String("high-alert-policy", "all", "High alert delivery policy (all, failover or quorum)\nEnvironment variable: DEEPSENTINEL_HIGH_ALERT_POLICY\n\b")
+	serverCmd.Flags().Int("low-alert-quorum", 0, "Number of low alert providers that must succeed with the quorum policy, defaults to a majority\nEnvironment variable: DEEPSENTINEL_LOW_ALERT_QUORUM\n\b")
+	serverCmd.Flags().Int("high-alert-quorum", 0, "Number of high alert providers that must succeed with the quorum policy, defaults to a majority\nEnvironment variable: DEEPSENTINEL_HIGH_ALERT_QUORUM\n\b")
 	serverCmd.Flags().String("pagerduty.api-key", "", "PagerDuty API key\nEnvironment variable: DEEPSENTINEL_PAGERDUTY_API_KEY\n\b")
 	serverCmd.Flags().String("pagerduty.integration-key", "", "PagerDuty integration key\nEnvironment variable: DEEPSENTINEL_PAGERDUTY_INTEGRATION_KEY\n\b")
 	serverCmd.Flags().String("pagerduty.integration-url", "", "PagerDuty integration URL\nEnvironment variable: DEEPSENTINEL_PAGERDUTY_INTEGRATION_URL\n\b")
+	serverCmd.Flags().String("keephq.api-key", "", "KeepHQ API key\nEnvironment variable: DEEPSENTINEL_KEEPHQ_API_KEY\n\b")
+	serverCmd.Flags().String("keephq.api-url", "https://api.keephq.dev", "KeepHQ API URL\nEnvironment variable: DEEPSENTINEL_KEEPHQ_API_URL\n\b")
+	serverCmd.Flags().String("webhook.url", "", "Webhook URL\nEnvironment variable: DEEPSENTINEL_WEBHOOK_URL\n\b")
+	serverCmd.Flags().String("webhook.method", "POST", "Webhook HTTP method\nEnvironment variable: DEEPSENTINEL_WEBHOOK_METHOD\n\b")
+	serverCmd.Flags().StringToString("webhook.headers", nil, "Webhook custom headers (key=value,...)\nEnvironment variable: DEEPSENTINEL_WEBHOOK_HEADERS (JSON object)\n\b")
+	serverCmd.Flags().String("webhook.template", "", "Webhook body Go text/template, defaults to a JSON object\nEnvironment variable: DEEPSENTINEL_WEBHOOK_TEMPLATE\n\b")
+	serverCmd.Flags().String("webhook.template-file", "", "File containing the webhook body template\nEnvironment variable: DEEPSENTINEL_WEBHOOK_TEMPLATE_FILE\n\b")
+	serverCmd.Flags().String("webhook.hmac-secret", "", "Secret used to sign webhook bodies with HMAC-SHA256\nEnvironment variable: DEEPSENTINEL_WEBHOOK_HMAC_SECRET\n\b")
+	serverCmd.Flags().String("webhook.hmac-header", "X-DeepSentinel-Signature", "Header carrying the webhook signature\nEnvironment variable: DEEPSENTINEL_WEBHOOK_HMAC_HEADER\n\b")
+	serverCmd.Flags().Int("webhook.retries", 3, "Number of retries of a failed webhook\nEnvironment variable: DEEPSENTINEL_WEBHOOK_RETRIES\n\b")
+	serverCmd.Flags().String("webhook.retry-backoff", "1s", "Initial delay between webhook retries, doubled on each retry\nEnvironment variable: DEEPSENTINEL_WEBHOOK_RETRY_BACKOFF\n\b")
+	serverCmd.Flags().String("smtp.host", "", "SMTP server host\nEnvironment variable: DEEPSENTINEL_SMTP_HOST\n\b")
+	serverCmd.Flags().Int("smtp.port", 587, "SMTP server port\nEnvironment variable: DEEPSENTINEL_SMTP_PORT\n\b")
+	serverCmd.Flags().String("smtp.username", "", "SMTP username, authentication is skipped when empty\nEnvironment variable: DEEPSENTINEL_SMTP_USERNAME\n\b")
+	serverCmd.Flags().String("smtp.password", "", "SMTP password\nEnvironment variable: DEEPSENTINEL_SMTP_PASSWORD\n\b")
+	serverCmd.Flags().String("smtp.from", "", "Sender address of the alert emails\nEnvironment variable: DEEPSENTINEL_SMTP_FROM\n\b")
+	serverCmd.Flags().String("smtp.to", "", "Comma-separated recipients of the alert emails\nEnvironment variable: DEEPSENTINEL_SMTP_TO\n\b")
+	serverCmd.Flags().String("smtp.tls", "starttls", "SMTP TLS mode (none, starttls, tls)\nEnvironment variable: DEEPSENTINEL_SMTP_TLS\n\b")
+	serverCmd.Flags().String("smtp.low-subject", "", "Go text/template of the low alert email subject\nEnvironment variable: DEEPSENTINEL_SMTP_LOW_SUBJECT\n\b")
+	serverCmd.Flags().String("smtp.high-subject", "", "Go text/template of the high alert email subject\nEnvironment variable: DEEPSENTINEL_SMTP_HIGH_SUBJECT\n\b")
 
 	config.BindFlags(serverCmd.Flags())
 
 	rootCmd.AddCommand(serverCmd)
+	rootCmd.AddCommand(tokenCmd())
 }
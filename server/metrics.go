@@ -0,0 +1,215 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/equals215/deepsentinel/alerting"
+	"github.com/equals215/deepsentinel/cluster"
+	"github.com/equals215/deepsentinel/dashboard"
+	"github.com/equals215/deepsentinel/monitoring"
+	"github.com/gofiber/fiber/v2"
+)
+
+var rejectedReports atomic.Uint64
+
+// metricsWriter writes metrics in the Prometheus text exposition format
+type metricsWriter struct {
+	strings.Builder
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (w *metricsWriter) family(name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes a sample of name, labels are name and value pairs
+func (w *metricsWriter) sample(name string, value interface{}, labels ...string) {
+	w.WriteString(name)
+	if len(labels) > 0 {
+		w.WriteString("{")
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.WriteString(",")
+			}
+			fmt.Fprintf(w, `%s="%s"`, labels[i], labelValueEscaper.Replace(labels[i+1]))
+		}
+		w.WriteString("}")
+	}
+	fmt.Fprintf(w, " %v\n", value)
+}
+
+func getMetricsHandler(c *fiber.Ctx, dashboardOperator *dashboard.Operator) error {
+	w := &metricsWriter{}
+	probes := monitoring.Probes()
+
+	w.family("deepsentinel_reports_total", "counter", "Reports handled by the server.")
+	w.sample("deepsentinel_reports_total", monitoring.ReportsReceived())
+	w.family("deepsentinel_reports_rejected_total", "counter", "Reports rejected because of an invalid payload.")
+	w.sample("deepsentinel_reports_rejected_total", rejectedReports.Load())
+
+	w.family("deepsentinel_registrations_rejected_total", "counter", "Reports of unregistered machines rejected by the registration policy.")
+	rejected := monitoring.RejectedRegistrations()
+	for _, reason := range []string{monitoring.RejectedPending, monitoring.RejectedMaxProbes, monitoring.RejectedPendingFull} {
+		w.sample("deepsentinel_registrations_rejected_total", rejected[reason], "reason", reason)
+	}
+	w.family("deepsentinel_pending_machines", "gauge", "Machines waiting for registration approval.")
+	w.sample("deepsentinel_pending_machines", len(monitoring.PendingMachines()))
+
+	w.family("deepsentinel_probes", "gauge", "Probes known by the server.")
+	w.sample("deepsentinel_probes", len(probes))
+
+	w.family("deepsentinel_probe_status", "gauge", "Probe status: 0 normal, 1 degraded, 2 failed, 3 alertedLow, 4 alertedHigh.")
+	for _, probe := range probes {
+		w.sample("deepsentinel_probe_status", monitoring.ProbeStatusLevel(probe.Status), "machine", probe.Name)
+	}
+	w.family("deepsentinel_probe_counter", "gauge", "Inactivity ticks spent by the probe in its current status.")
+	for _, probe := range probes {
+		w.sample("deepsentinel_probe_counter", probe.Counter, "machine", probe.Name)
+	}
+	w.family("deepsentinel_probe_reports_total", "counter", "Reports received from the machine.")
+	for _, probe := range probes {
+		w.sample("deepsentinel_probe_reports_total", probe.Reports, "machine", probe.Name)
+	}
+	w.family("deepsentinel_probe_last_report_timestamp_seconds", "gauge", "Unix time of the last report of the machine.")
+	for _, probe := range probes {
+		if !probe.LastReport.IsZero() {
+			w.sample("deepsentinel_probe_last_report_timestamp_seconds", probe.LastReport.Unix(), "machine", probe.Name)
+		}
+	}
+	w.family("deepsentinel_probe_silenced", "gauge", "Whether the machine is silenced.")
+	for _, probe := range probes {
+		silenced := 0
+		for _, silence := range probe.Silences {
+			if silence.Service == "" {
+				silenced = 1
+			}
+		}
+		w.sample("deepsentinel_probe_silenced", silenced, "machine", probe.Name)
+	}
+
+	w.family("deepsentinel_probe_unreachable", "gauge", "Whether the machine stopped reporting while a machine it depends on is failed.")
+	for _, probe := range probes {
+		unreachable := 0
+		if probe.UnreachableVia != "" {
+			unreachable = 1
+		}
+		w.sample("deepsentinel_probe_unreachable", unreachable, "machine", probe.Name)
+	}
+
+	w.family("deepsentinel_machine_reported_status", "gauge", "Latest status reported by the agent for the whole machine: 0 pass, 1 warn, 2 fail.")
+	for _, probe := range probes {
+		if probe.MachineStatus != nil {
+			w.sample("deepsentinel_machine_reported_status", monitoring.ServiceStatusLevel(probe.MachineStatus.Status), "machine", probe.Name)
+		}
+	}
+
+	w.family("deepsentinel_service_status", "gauge", "Latest service status: 0 pass, 1 warn, 2 fail.")
+	forEachService(probes, func(probe *monitoring.ProbeInfo, service string, info *monitoring.ServiceInfo) {
+		w.sample("deepsentinel_service_status", monitoring.ServiceStatusLevel(info.Status), "machine", probe.Name, "service", service)
+	})
+	w.family("deepsentinel_service_consecutive_count", "gauge", "Consecutive reports of the service in its current warn or fail status.")
+	forEachService(probes, func(probe *monitoring.ProbeInfo, service string, info *monitoring.ServiceInfo) {
+		w.sample("deepsentinel_service_consecutive_count", info.Count, "machine", probe.Name, "service", service)
+	})
+
+	w.family("deepsentinel_service_flapping", "gauge", "Whether the service is flapping.")
+	forEachService(probes, func(probe *monitoring.ProbeInfo, service string, info *monitoring.ServiceInfo) {
+		flapping := 0
+		if info.Flapping {
+			flapping = 1
+		}
+		w.sample("deepsentinel_service_flapping", flapping, "machine", probe.Name, "service", service)
+	})
+
+	groups := monitoring.Groups()
+	w.family("deepsentinel_group_probes", "gauge", "Probes selected by the group rule.")
+	for _, group := range groups {
+		w.sample("deepsentinel_group_probes", len(group.Probes), "group", group.Name)
+	}
+	w.family("deepsentinel_group_affected", "gauge", "Probes of the group in the status counted by the rule.")
+	for _, group := range groups {
+		w.sample("deepsentinel_group_affected", len(group.Affected), "group", group.Name)
+	}
+	w.family("deepsentinel_group_triggered", "gauge", "Whether the group rule is triggered.")
+	for _, group := range groups {
+		triggered := 0
+		if group.Triggered {
+			triggered = 1
+		}
+		w.sample("deepsentinel_group_triggered", triggered, "group", group.Name)
+	}
+
+	checkins := monitoring.Checkins()
+	w.family("deepsentinel_checkin_alerting", "gauge", "Whether the job missed its run, failed or ran too long.")
+	for _, checkin := range checkins {
+		alerted := 0
+		if monitoring.CheckinAlerting(checkin.Status) {
+			alerted = 1
+		}
+		w.sample("deepsentinel_checkin_alerting", alerted, "checkin", checkin.Name)
+	}
+	w.family("deepsentinel_checkin_last_success_timestamp_seconds", "gauge", "Unix time of the last successful run of the job.")
+	for _, checkin := range checkins {
+		if !checkin.LastSuccess.IsZero() {
+			w.sample("deepsentinel_checkin_last_success_timestamp_seconds", checkin.LastSuccess.Unix(), "checkin", checkin.Name)
+		}
+	}
+
+	w.family("deepsentinel_alert_deliveries_total", "counter", "Alerts and resolutions sent or failed per provider.")
+	for _, count := range alerting.ProviderCounts() {
+		w.sample("deepsentinel_alert_deliveries_total", count.Count,
+			"provider", count.Provider, "severity", count.Severity, "kind", count.Kind, "result", count.Result)
+	}
+	healthy := 0
+	if alerting.Health().Healthy {
+		healthy = 1
+	}
+	w.family("deepsentinel_alerting_healthy", "gauge", "Whether the last alert delivery succeeded.")
+	w.sample("deepsentinel_alerting_healthy", healthy)
+
+	if cluster.Enabled() {
+		status := cluster.CurrentStatus()
+		leader := 0
+		if status.Leader == status.ID {
+			leader = 1
+		}
+		w.family("deepsentinel_cluster_leader", "gauge", "Whether this server is the cluster leader.")
+		w.sample("deepsentinel_cluster_leader", leader, "node", status.ID)
+		w.family("deepsentinel_cluster_peer_up", "gauge", "Whether the cluster peer answers the heartbeats.")
+		for _, peer := range status.Peers {
+			up := 0
+			if peer.Alive {
+				up = 1
+			}
+			w.sample("deepsentinel_cluster_peer_up", up, "peer", peer.Name())
+		}
+	}
+
+	clients := 0
+	if dashboardOperator != nil {
+		clients = dashboardOperator.Clients()
+	}
+	w.family("deepsentinel_dashboard_clients", "gauge", "Dashboard websocket clients connected.")
+	w.sample("deepsentinel_dashboard_clients", clients)
+
+	c.Set(fiber.HeaderContentType, "text/plain; version=0.0.4; charset=utf-8")
+	return c.SendString(w.String())
+}
+
+// forEachService calls fn for every service of every probe sorted by name
+func forEachService(probes []*monitoring.ProbeInfo, fn func(*monitoring.ProbeInfo, string, *monitoring.ServiceInfo)) {
+	for _, probe := range probes {
+		services := make([]string, 0, len(probe.Services))
+		for service := range probe.Services {
+			services = append(services, service)
+		}
+		sort.Strings(services)
+		for _, service := range services {
+			fn(probe, service, probe.Services[service])
+		}
+	}
+}
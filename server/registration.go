@@ -0,0 +1,54 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/equals215/deepsentinel/cluster"
+	"github.com/equals215/deepsentinel/monitoring"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+func getPendingHandler(c *fiber.Ctx) error {
+	return c.JSON(monitoring.PendingMachines())
+}
+
+func postPendingApproveHandler(c *fiber.Ctx) error {
+	machine := strings.TrimSpace(utils.CopyString(c.Params("machine")))
+
+	if err := monitoring.ApproveMachine(machine); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "fail",
+			"machine": machine,
+			"error":   err.Error(),
+		})
+	}
+	cluster.Replicate(&cluster.Message{
+		Kind:      cluster.KindApprove,
+		Machine:   machine,
+		Timestamp: time.Now(),
+	})
+	return c.JSON(fiber.Map{
+		"status":  "pass",
+		"machine": machine,
+	})
+}
+
+func deletePendingHandler(c *fiber.Ctx) error {
+	machine := strings.TrimSpace(utils.CopyString(c.Params("machine")))
+
+	if err := monitoring.RejectMachine(machine); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "fail",
+			"machine": machine,
+			"error":   err.Error(),
+		})
+	}
+	cluster.Replicate(&cluster.Message{
+		Kind:      cluster.KindReject,
+		Machine:   machine,
+		Timestamp: time.Now(),
+	})
+	return c.SendStatus(fiber.StatusOK)
+}
@@ -7,26 +7,66 @@ import (
 	"strings"
 	"time"
 
+	"github.com/equals215/deepsentinel/alerting"
+	"github.com/equals215/deepsentinel/cluster"
 	"github.com/equals215/deepsentinel/dashboard"
+	"github.com/equals215/deepsentinel/journal"
 	"github.com/equals215/deepsentinel/monitoring"
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/filesystem"
 	"github.com/gofiber/fiber/v2/utils"
+	log "github.com/sirupsen/logrus"
 )
 
 //go:embed static/*
 var dashboardStatic embed.FS
 
-func newServer(payloadChannel chan *monitoring.Payload, dashboardOperator *dashboard.Operator) *fiber.App {
+func newServer(payloadChannel chan *monitoring.Payload, dashboardOperator *dashboard.Operator, noMetrics bool) *fiber.App {
 	app := fiber.New(fiber.Config{
 		AppName: "DeepSentinel API",
 	})
 
 	fiberSetAuth(app)
+	initSignedReports()
 
 	app.Get("/health", getHealthHandler)
 
+	if !noMetrics {
+		app.Get("/metrics", func(c *fiber.Ctx) error {
+			return getMetricsHandler(c, dashboardOperator)
+		})
+	}
+
+	app.Get("/probes", getProbesHandler)
+
+	app.Get("/probe/:machine", getProbeHandler)
+
+	app.Post("/probe/:machine/silence", postProbeSilenceHandler)
+
+	app.Delete("/probe/:machine/silence", deleteProbeSilenceHandler)
+
+	app.Get("/silences", getSilencesHandler)
+
+	app.Get("/events", getEventsHandler)
+	app.Get("/groups", getGroupsHandler)
+
+	app.Get("/checkins", getCheckinsHandler)
+
+	app.Get("/pending", getPendingHandler)
+
+	app.Post("/pending/:machine/approve", postPendingApproveHandler)
+
+	app.Delete("/pending/:machine", deletePendingHandler)
+
+	app.Get("/tokens", getTokensHandler)
+
+	app.Post("/tokens", postTokenHandler)
+
+	app.Delete("/tokens/:id", deleteTokenHandler)
+
+	app.All("/checkin/:name/:signal?", checkinHandler)
+
 	app.Post("/probe/:machine/report", func(c *fiber.Ctx) error {
 		return postProbeReportHandler(c, payloadChannel)
 	})
@@ -35,6 +75,14 @@ func newServer(payloadChannel chan *monitoring.Payload, dashboardOperator *dashb
 		return deleteProbeHandler(c, payloadChannel)
 	})
 
+	app.Get("/cluster", getClusterHandler)
+
+	app.Get("/cluster/state", getClusterStateHandler)
+
+	app.Post("/cluster/replicate", func(c *fiber.Ctx) error {
+		return postClusterReplicateHandler(c, payloadChannel)
+	})
+
 	if dashboardOperator != nil {
 		app.Use("/dashws", func(c *fiber.Ctx) error {
 			if websocket.IsWebSocketUpgrade(c) {
@@ -69,11 +117,159 @@ func newServer(payloadChannel chan *monitoring.Payload, dashboardOperator *dashb
 }
 
 func getHealthHandler(c *fiber.Ctx) error {
+	alertingHealth := alerting.Health()
+	if !alertingHealth.Healthy {
+		return c.JSON(fiber.Map{
+			"status":   "warn",
+			"alerting": alertingHealth,
+		})
+	}
 	return c.JSON(fiber.Map{
 		"status": "pass",
 	})
 }
 
+func getProbesHandler(c *fiber.Ctx) error {
+	return c.JSON(monitoring.Probes())
+}
+
+func getProbeHandler(c *fiber.Ctx) error {
+	machine := strings.TrimSpace(utils.CopyString(c.Params("machine")))
+
+	probe, ok := monitoring.Probe(machine)
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "fail",
+			"machine": machine,
+			"error":   "probe not found",
+		})
+	}
+	return c.JSON(probe)
+}
+
+// silenceRequest is the body of a silence request
+// For is a duration and Until a date, a silence without any of them lasts until it is cleared
+type silenceRequest struct {
+	Service string    `json:"service"`
+	For     string    `json:"for"`
+	Until   time.Time `json:"until"`
+	Reason  string    `json:"reason"`
+}
+
+func postProbeSilenceHandler(c *fiber.Ctx) error {
+	machine := strings.TrimSpace(utils.CopyString(c.Params("machine")))
+
+	request := &silenceRequest{}
+	if len(c.Body()) > 0 {
+		if err := json.Unmarshal(c.Body(), request); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "fail",
+				"machine": machine,
+				"error":   err.Error(),
+			})
+		}
+	}
+
+	until := request.Until
+	if request.For != "" {
+		duration, err := time.ParseDuration(request.For)
+		if err != nil || duration <= 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status":  "fail",
+				"machine": machine,
+				"error":   "for must be a positive duration",
+			})
+		}
+		until = time.Now().Add(duration)
+	} else if !until.IsZero() && until.Before(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "fail",
+			"machine": machine,
+			"error":   "until is in the past",
+		})
+	}
+
+	silence, err := monitoring.SilenceProbe(machine, strings.TrimSpace(request.Service), until, request.Reason)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "fail",
+			"machine": machine,
+			"error":   err.Error(),
+		})
+	}
+	cluster.Replicate(&cluster.Message{
+		Kind:      cluster.KindSilence,
+		Machine:   machine,
+		Timestamp: silence.CreatedAt,
+		Service:   silence.Service,
+		Until:     until,
+		Reason:    silence.Reason,
+	})
+	return c.Status(fiber.StatusCreated).JSON(silence)
+}
+
+func deleteProbeSilenceHandler(c *fiber.Ctx) error {
+	machine := strings.TrimSpace(utils.CopyString(c.Params("machine")))
+
+	service := strings.TrimSpace(c.Query("service"))
+	err := monitoring.ClearSilence(machine, service)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status":  "fail",
+			"machine": machine,
+			"error":   err.Error(),
+		})
+	}
+	cluster.Replicate(&cluster.Message{
+		Kind:      cluster.KindClearSilence,
+		Machine:   machine,
+		Timestamp: time.Now(),
+		Service:   service,
+	})
+	return c.SendStatus(fiber.StatusOK)
+}
+
+func getSilencesHandler(c *fiber.Ctx) error {
+	return c.JSON(monitoring.Silences())
+}
+
+func getGroupsHandler(c *fiber.Ctx) error {
+	return c.JSON(monitoring.Groups())
+}
+
+func getEventsHandler(c *fiber.Ctx) error {
+	filter := journal.Filter{
+		Machine: c.Query("machine"),
+		Service: c.Query("service"),
+		Group:   c.Query("group"),
+		Checkin: c.Query("checkin"),
+		Limit:   c.QueryInt("limit", 100),
+	}
+
+	for _, bound := range []struct {
+		name  string
+		value *time.Time
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	} {
+		raw := c.Query(bound.name)
+		if raw == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"status": "fail",
+				"error":  bound.name + " must be a RFC 3339 date",
+			})
+		}
+		*bound.value = parsed
+	}
+
+	return c.JSON(journal.Query(filter))
+}
+
 func postProbeReportHandler(c *fiber.Ctx, payloadChannel chan *monitoring.Payload) error {
 	machine := utils.CopyString(c.Params("machine"))
 
@@ -85,6 +281,7 @@ func postProbeReportHandler(c *fiber.Ctx, payloadChannel chan *monitoring.Payloa
 		})
 	}
 	if c.Body() == nil {
+		rejectedReports.Add(1)
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"status":  "fail",
 			"machine": machine,
@@ -95,6 +292,7 @@ func postProbeReportHandler(c *fiber.Ctx, payloadChannel chan *monitoring.Payloa
 	parsedPayload := &monitoring.Payload{}
 	err := json.Unmarshal(c.Body(), parsedPayload)
 	if err != nil {
+		rejectedReports.Add(1)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"status":  "fail",
 			"machine": machine,
@@ -102,10 +300,53 @@ func postProbeReportHandler(c *fiber.Ctx, payloadChannel chan *monitoring.Payloa
 		})
 	}
 
+	if err := parsedPayload.Validate(); err != nil {
+		rejectedReports.Add(1)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status":  "fail",
+			"machine": machine,
+			"error":   err.Error(),
+		})
+	}
+
 	parsedPayload.Timestamp = time.Now()
 	parsedPayload.Machine = strings.TrimSpace(machine)
 
+	sequence, err := verifyReportSignature(c, parsedPayload.Machine, parsedPayload.Timestamp)
+	if err != nil {
+		rejectedReports.Add(1)
+		log.WithField("machine", parsedPayload.Machine).WithError(err).Warn("Report signature rejected")
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"status":  "fail",
+			"machine": parsedPayload.Machine,
+			"error":   err.Error(),
+		})
+	}
+
+	// Reports of unregistered machines still go through so that they are counted and kept as pending
+	admission := monitoring.Admission(parsedPayload.Machine)
 	payloadChannel <- parsedPayload
+	cluster.Replicate(&cluster.Message{
+		Kind:      cluster.KindReport,
+		Machine:   parsedPayload.Machine,
+		Timestamp: parsedPayload.Timestamp,
+		Report:    append(json.RawMessage{}, c.Body()...),
+		Sequence:  sequence,
+	})
+
+	switch admission {
+	case monitoring.ErrTooManyProbes:
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"status":  "fail",
+			"machine": parsedPayload.Machine,
+			"error":   admission.Error(),
+		})
+	case monitoring.ErrRegistrationPending:
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"status":  "pending",
+			"machine": parsedPayload.Machine,
+		})
+	}
 	return c.SendStatus(fiber.StatusAccepted)
 }
 
@@ -127,5 +368,10 @@ func deleteProbeHandler(c *fiber.Ctx, payloadChannel chan *monitoring.Payload) e
 	}
 
 	payloadChannel <- parsedPayload
+	cluster.Replicate(&cluster.Message{
+		Kind:      cluster.KindDelete,
+		Machine:   parsedPayload.Machine,
+		Timestamp: parsedPayload.Timestamp,
+	})
 	return c.SendStatus(fiber.StatusAccepted)
 }
@@ -3,14 +3,20 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"io"
 	"net"
 	"net/http"
+	"path/filepath"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/equals215/deepsentinel/config"
 	"github.com/equals215/deepsentinel/dashboard"
 	"github.com/equals215/deepsentinel/monitoring"
+	"github.com/equals215/deepsentinel/signing"
+	"github.com/equals215/deepsentinel/tokens"
+	"github.com/gofiber/fiber/v2"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -37,14 +43,20 @@ func TestNewServer(t *testing.T) {
 			_ = payload
 		}
 	}()
-	s := newServer(payloadTestChan, dashboardOperator)
+	s := newServer(payloadTestChan, dashboardOperator, false)
 	// Test if the server is created
 	assert.NotNil(t, s, "newServer() returned nil")
 
 	// Test if the server is created with the correct name
 	assert.Equal(t, "DeepSentinel API", s.Config().AppName, "newServer() returned a server with incorrect name")
 
+	listening := make(chan struct{})
+	s.Hooks().OnListen(func(fiber.ListenData) error {
+		close(listening)
+		return nil
+	})
 	go s.Listen("localhost:8486")
+	<-listening
 	defer s.Shutdown()
 
 	// Test if the server is running
@@ -83,3 +95,295 @@ func TestNewServer(t *testing.T) {
 	assert.Nil(t, err, "Failed to send DELETE request to server")
 	assert.Equal(t, http.StatusAccepted, resp.StatusCode, "Server returned incorrect status code for DELETE /probe/:machine")
 }
+
+func TestServerAPI(t *testing.T) {
+	var payloadTestChan = make(chan *monitoring.Payload)
+	var dashboardOperator *dashboard.Operator
+
+	var testClient = &http.Client{
+		Timeout: time.Second * 10,
+	}
+	config.Server = &config.ServerConfig{
+		AuthToken: "test-auth-token",
+	}
+	assert.Nil(t, tokens.Init(filepath.Join(t.TempDir(), "tokens.json")), "Failed to open the tokens store")
+
+	go func() {
+		for payload := range payloadTestChan {
+			_ = payload
+		}
+	}()
+	s := newServer(payloadTestChan, dashboardOperator, false)
+
+	// Bind before serving so the requests below can't race the listener
+	listener, err := net.Listen("tcp", "localhost:8487")
+	assert.Nil(t, err, "Failed to listen")
+	go s.Listener(listener)
+	defer s.Shutdown()
+
+	payloadBytes, err := json.Marshal(&monitoring.Payload{MachineStatus: "pass"})
+	assert.Nil(t, err, "Failed to marshal payload")
+	var req *http.Request
+	var resp *http.Response
+
+	// Test POST /probe/:machine/report rejects an invalid machine status
+	for _, body := range []string{`{"machineStatus":"delete"}`, `{"machineStatus":"broken"}`, `{"services":{"":"pass"}}`} {
+		req, _ = http.NewRequest("POST", "http://localhost:8487/probe/testmachine/report", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "test-auth-token")
+		resp, err = testClient.Do(req)
+		assert.Nil(t, err, "Failed to send POST request to server")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Server returned incorrect status code for report %s", body)
+	}
+
+	// Test GET /probes requires authentication
+	resp, err = testClient.Get("http://localhost:8487/probes")
+	assert.Nil(t, err, "Failed to send GET request to server")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Server returned incorrect status code for unauthenticated GET /probes")
+
+	// Test a query string doesn't bypass the authentication
+	resp, err = testClient.Get("http://localhost:8487/probes?machine=testmachine")
+	assert.Nil(t, err, "Failed to send GET request to server")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Server returned incorrect status code for unauthenticated GET /probes with a query")
+
+	// Test GET /probes
+	req, _ = http.NewRequest("GET", "http://localhost:8487/probes", nil)
+	req.Header.Set("Authorization", "test-auth-token")
+	resp, err = testClient.Do(req)
+	assert.Nil(t, err, "Failed to send GET request to server")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Server returned incorrect status code for GET /probes")
+	var probes []*monitoring.ProbeInfo
+	err = json.NewDecoder(resp.Body).Decode(&probes)
+	assert.Nil(t, err, "Failed to decode response body")
+	assert.Empty(t, probes, "Server returned unknown probes")
+
+	// Test GET /probe/:machine on an unknown machine
+	req, _ = http.NewRequest("GET", "http://localhost:8487/probe/unknown", nil)
+	req.Header.Set("Authorization", "test-auth-token")
+	resp, err = testClient.Do(req)
+	assert.Nil(t, err, "Failed to send GET request to server")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Server returned incorrect status code for GET /probe/:machine")
+
+	// Test POST /probe/:machine/silence with an invalid duration
+	req, _ = http.NewRequest("POST", "http://localhost:8487/probe/unknown/silence", bytes.NewBufferString(`{"for":"soon"}`))
+	req.Header.Set("Authorization", "test-auth-token")
+	resp, err = testClient.Do(req)
+	assert.Nil(t, err, "Failed to send POST request to server")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Server returned incorrect status code for an invalid silence")
+
+	// Test POST /probe/:machine/silence on an unknown machine
+	req, _ = http.NewRequest("POST", "http://localhost:8487/probe/unknown/silence", bytes.NewBufferString(`{"for":"2h"}`))
+	req.Header.Set("Authorization", "test-auth-token")
+	resp, err = testClient.Do(req)
+	assert.Nil(t, err, "Failed to send POST request to server")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Server returned incorrect status code for POST /probe/:machine/silence")
+
+	// Test GET /metrics requires basic authentication
+	resp, err = testClient.Get("http://localhost:8487/metrics")
+	assert.Nil(t, err, "Failed to send GET request to server")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Server returned incorrect status code for unauthenticated GET /metrics")
+
+	// Test GET /metrics
+	req, _ = http.NewRequest("GET", "http://localhost:8487/metrics", nil)
+	req.SetBasicAuth("admin", "test-auth-token")
+	resp, err = testClient.Do(req)
+	assert.Nil(t, err, "Failed to send GET request to server")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Server returned incorrect status code for GET /metrics")
+	metrics, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err, "Failed to read response body")
+	assert.Contains(t, string(metrics), "# TYPE deepsentinel_reports_total counter\n")
+	assert.Contains(t, string(metrics), "deepsentinel_dashboard_clients 0\n")
+
+	// Test GET /cluster outside of a cluster
+	req, _ = http.NewRequest("GET", "http://localhost:8487/cluster", nil)
+	req.Header.Set("Authorization", "test-auth-token")
+	resp, err = testClient.Do(req)
+	assert.Nil(t, err, "Failed to send GET request to server")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Server returned incorrect status code for GET /cluster")
+
+	// Test POST /cluster/replicate requires authentication
+	resp, err = testClient.Post("http://localhost:8487/cluster/replicate", "application/json", bytes.NewBufferString(`{"kind":"delete","machine":"testmachine"}`))
+	assert.Nil(t, err, "Failed to send POST request to server")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Server returned incorrect status code for unauthenticated POST /cluster/replicate")
+
+	// Test POST /cluster/replicate with an unknown kind
+	req, _ = http.NewRequest("POST", "http://localhost:8487/cluster/replicate", bytes.NewBufferString(`{"kind":"reboot","machine":"testmachine"}`))
+	req.Header.Set("Authorization", "test-auth-token")
+	resp, err = testClient.Do(req)
+	assert.Nil(t, err, "Failed to send POST request to server")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Server returned incorrect status code for an unknown replicated message")
+
+	// Test POST /cluster/replicate approving a blank machine name
+	req, _ = http.NewRequest("POST", "http://localhost:8487/cluster/replicate", bytes.NewBufferString(`{"kind":"approve","machine":"  "}`))
+	req.Header.Set("Authorization", "test-auth-token")
+	resp, err = testClient.Do(req)
+	assert.Nil(t, err, "Failed to send POST request to server")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Server returned incorrect status code for a blank approved machine")
+
+	// Test GET /events with an invalid date
+	req, _ = http.NewRequest("GET", "http://localhost:8487/events?since=yesterday", nil)
+	req.Header.Set("Authorization", "test-auth-token")
+	resp, err = testClient.Do(req)
+	assert.Nil(t, err, "Failed to send GET request to server")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Server returned incorrect status code for an invalid GET /events")
+
+	// Test GET /events
+	req, _ = http.NewRequest("GET", "http://localhost:8487/events?machine=testmachine&since=2024-01-01T00:00:00Z&limit=10", nil)
+	req.Header.Set("Authorization", "test-auth-token")
+	resp, err = testClient.Do(req)
+	assert.Nil(t, err, "Failed to send GET request to server")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Server returned incorrect status code for GET /events")
+
+	// Test GET /groups requires authentication
+	resp, err = testClient.Get("http://localhost:8487/groups")
+	assert.Nil(t, err, "Failed to send GET request to server")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Server returned incorrect status code for unauthenticated GET /groups")
+
+	// Test GET /groups
+	req, _ = http.NewRequest("GET", "http://localhost:8487/groups", nil)
+	req.Header.Set("Authorization", "test-auth-token")
+	resp, err = testClient.Do(req)
+	assert.Nil(t, err, "Failed to send GET request to server")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Server returned incorrect status code for GET /groups")
+
+	// Test GET /checkin/<name> with an unknown check-in
+	req, _ = http.NewRequest("GET", "http://localhost:8487/checkin/backup", nil)
+	req.Header.Set("Authorization", "test-auth-token")
+	resp, err = testClient.Do(req)
+	assert.Nil(t, err, "Failed to send GET request to server")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Server returned incorrect status code for an unknown check-in")
+
+	// Test GET /checkins
+	req, _ = http.NewRequest("GET", "http://localhost:8487/checkins", nil)
+	req.Header.Set("Authorization", "test-auth-token")
+	resp, err = testClient.Do(req)
+	assert.Nil(t, err, "Failed to send GET request to server")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Server returned incorrect status code for GET /checkins")
+
+	// Test GET /pending
+	req, _ = http.NewRequest("GET", "http://localhost:8487/pending", nil)
+	req.Header.Set("Authorization", "test-auth-token")
+	resp, err = testClient.Do(req)
+	assert.Nil(t, err, "Failed to send GET request to server")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Server returned incorrect status code for GET /pending")
+
+	// Test DELETE /pending/<machine> for a machine that isn't pending
+	req, _ = http.NewRequest("DELETE", "http://localhost:8487/pending/unknown", nil)
+	req.Header.Set("Authorization", "test-auth-token")
+	resp, err = testClient.Do(req)
+	assert.Nil(t, err, "Failed to send DELETE request to server")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Server returned incorrect status code for DELETE /pending/<machine>")
+
+	// Test POST /tokens
+	req, _ = http.NewRequest("POST", "http://localhost:8487/tokens", bytes.NewBufferString(`{"name":"test","machines":["tokenmachine-*"]}`))
+	req.Header.Set("Authorization", "test-auth-token")
+	resp, err = testClient.Do(req)
+	assert.Nil(t, err, "Failed to send POST request to server")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode, "Server returned incorrect status code for POST /tokens")
+	var created struct {
+		Token  tokens.Token `json:"token"`
+		Secret string       `json:"secret"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	assert.Nil(t, err, "Failed to decode response body")
+	assert.Empty(t, created.Token.Hash, "POST /tokens returned the token hash")
+
+	// Test machine tokens are only accepted for their machines
+	for _, test := range []struct {
+		method string
+		url    string
+		status int
+	}{
+		{"POST", "http://localhost:8487/probe/tokenmachine-1/report", http.StatusAccepted},
+		{"POST", "http://localhost:8487/probe/othermachine/report", http.StatusUnauthorized},
+		{"GET", "http://localhost:8487/probes", http.StatusUnauthorized},
+		{"GET", "http://localhost:8487/tokens", http.StatusUnauthorized},
+	} {
+		req, _ = http.NewRequest(test.method, test.url, bytes.NewBuffer(payloadBytes))
+		req.Header.Set("Authorization", created.Secret)
+		resp, err = testClient.Do(req)
+		assert.Nil(t, err, "Failed to send request to server")
+		assert.Equal(t, test.status, resp.StatusCode, "Server returned incorrect status code for %s %s with a machine token", test.method, test.url)
+	}
+
+	// Test DELETE /tokens/<id> revokes the token
+	req, _ = http.NewRequest("DELETE", "http://localhost:8487/tokens/"+created.Token.ID, nil)
+	req.Header.Set("Authorization", "test-auth-token")
+	resp, err = testClient.Do(req)
+	assert.Nil(t, err, "Failed to send DELETE request to server")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Server returned incorrect status code for DELETE /tokens/<id>")
+	req, _ = http.NewRequest("POST", "http://localhost:8487/probe/tokenmachine-1/report", bytes.NewBuffer(payloadBytes))
+	req.Header.Set("Authorization", created.Secret)
+	resp, err = testClient.Do(req)
+	assert.Nil(t, err, "Failed to send POST request to server")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "Server accepted a revoked token")
+
+	// Test signed reports are accepted once and unsigned ones rejected when required
+	config.Server.SignedReports = config.SignedReportsConfig{Mode: config.SignedReportsRequired, Secret: "test-secret", MaxSkew: "30s"}
+	initSignedReports()
+	defer func() {
+		config.Server.SignedReports = config.SignedReportsConfig{}
+		initSignedReports()
+	}()
+	now := time.Now().Unix()
+	signedReport := func(sequence uint64) *http.Request {
+		req, _ := http.NewRequest("POST", "http://localhost:8487/probe/testmachine/report", bytes.NewBuffer(payloadBytes))
+		req.Header.Set("Authorization", "test-auth-token")
+		req.Header.Set(signing.HeaderTimestamp, strconv.FormatInt(now, 10))
+		req.Header.Set(signing.HeaderSequence, strconv.FormatUint(sequence, 10))
+		req.Header.Set(signing.HeaderSignature, signing.Sign("test-secret", "testmachine", now, sequence, payloadBytes))
+		return req
+	}
+	unsigned, _ := http.NewRequest("POST", "http://localhost:8487/probe/testmachine/report", bytes.NewBuffer(payloadBytes))
+	unsigned.Header.Set("Authorization", "test-auth-token")
+	for _, test := range []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{"signed", signedReport(2), http.StatusAccepted},
+		{"replayed", signedReport(2), http.StatusUnauthorized},
+		{"out of order", signedReport(1), http.StatusUnauthorized},
+		{"unsigned", unsigned, http.StatusUnauthorized},
+	} {
+		resp, err = testClient.Do(test.req)
+		assert.Nil(t, err, "Failed to send POST request to server")
+		assert.Equal(t, test.status, resp.StatusCode, "Server returned incorrect status code for a %s report", test.name)
+	}
+
+	// Test unsigned reports are accepted when optional until the machine signs one
+	config.Server.SignedReports.Mode = config.SignedReportsOptional
+	initSignedReports()
+	unsignedReport := func() *http.Request {
+		req, _ := http.NewRequest("POST", "http://localhost:8487/probe/testmachine/report", bytes.NewBuffer(payloadBytes))
+		req.Header.Set("Authorization", "test-auth-token")
+		return req
+	}
+	for _, test := range []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{"unsigned", unsignedReport(), http.StatusAccepted},
+		{"signed", signedReport(3), http.StatusAccepted},
+		{"stripped", unsignedReport(), http.StatusUnauthorized},
+	} {
+		resp, err = testClient.Do(test.req)
+		assert.Nil(t, err, "Failed to send POST request to server")
+		assert.Equal(t, test.status, resp.StatusCode, "Server returned incorrect status code for a %s optional report", test.name)
+	}
+}
+
+func TestMetricsWriter(t *testing.T) {
+	w := &metricsWriter{}
+
+	// Test case 1: a family without labels
+	w.family("deepsentinel_probes", "gauge", "Probes known by the server.")
+	w.sample("deepsentinel_probes", 2)
+	assert.Equal(t, "# HELP deepsentinel_probes Probes known by the server.\n# TYPE deepsentinel_probes gauge\ndeepsentinel_probes 2\n", w.String())
+	w.Reset()
+
+	// Test case 2: label values are escaped
+	w.sample("deepsentinel_service_status", 2, "machine", "machine1", "service", "say \"hi\"\\n")
+	assert.Equal(t, "deepsentinel_service_status{machine=\"machine1\",service=\"say \\\"hi\\\"\\\\n\"} 2\n", w.String())
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/equals215/deepsentinel/cluster"
+	"github.com/equals215/deepsentinel/heartbeat"
+	"github.com/equals215/deepsentinel/journal"
+	"github.com/equals215/deepsentinel/monitoring"
+	"github.com/equals215/deepsentinel/store"
+	"github.com/gofiber/fiber/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// lifecycle holds what the server stops on shutdown, nil fields aren't running
+// handled is closed once monitoring.Handle returned
+type lifecycle struct {
+	app        *fiber.App
+	payloads   chan *monitoring.Payload
+	handled    chan struct{}
+	node       *cluster.Node
+	pinger     *heartbeat.Pinger
+	stateStore store.Store
+}
+
+// shutdown stops accepting requests, lets the probes handle the pending payloads and send their alerts,
+// then closes the state store and the journal once the probes saved their state
+// It gives up waiting after timeout
+func (l *lifecycle) shutdown(timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	log.Info("Shutting down...")
+
+	// The external dead man's switch must notice the server is gone
+	if l.pinger != nil {
+		l.pinger.Stop()
+	}
+	if err := l.app.ShutdownWithContext(ctx); err != nil {
+		// Requests may still be sending payloads so the channel can't be closed
+		log.WithError(err).Error("Failed to stop the API server in time, pending payloads are lost")
+		return
+	}
+	log.Info("API server stopped")
+	if l.node != nil {
+		l.node.Stop()
+	}
+
+	close(l.payloads)
+	select {
+	case <-l.handled:
+	case <-ctx.Done():
+		log.Error("Probes didn't stop in time, their latest state may not be saved")
+		return
+	}
+
+	if l.stateStore != nil {
+		if err := l.stateStore.Close(); err != nil {
+			log.WithError(err).Error("Failed to close the state store")
+		}
+	}
+	if err := journal.Close(); err != nil {
+		log.WithError(err).Error("Failed to close the events journal")
+	}
+	log.Info("Server stopped")
+}
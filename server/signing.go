@@ -0,0 +1,50 @@
+package server
+
+import (
+	"time"
+
+	"github.com/equals215/deepsentinel/config"
+	"github.com/equals215/deepsentinel/signing"
+	"github.com/gofiber/fiber/v2"
+	log "github.com/sirupsen/logrus"
+)
+
+// reportTracker rejects the replayed reports, nil when the signatures aren't checked
+var reportTracker *signing.Tracker
+
+// initSignedReports creates the report tracker when the signed reports are enabled
+func initSignedReports() {
+	reportTracker = nil
+	if !config.Server.SignedReports.Enabled() {
+		return
+	}
+	maxSkew, err := config.Server.SignedReports.ParsedMaxSkew()
+	if err != nil {
+		// Checking nothing would accept every report, the configuration is validated beforehand so this is a bug
+		log.WithError(err).Fatal("Invalid signed-reports max-skew")
+	}
+	reportTracker = signing.NewTracker(maxSkew)
+}
+
+// verifyReportSignature checks the signature of a report of machine received at now and returns its sequence
+// Unsigned reports are accepted with a zero sequence unless signatures are required or the machine already signed,
+// so that a signed report can't be replayed with its headers stripped
+func verifyReportSignature(c *fiber.Ctx, machine string, now time.Time) (uint64, error) {
+	if reportTracker == nil {
+		return 0, nil
+	}
+	signature := c.Get(signing.HeaderSignature)
+	if signature == "" {
+		if config.Server.SignedReports.Mode == config.SignedReportsRequired || reportTracker.Signed(machine) {
+			return 0, signing.ErrUnsigned
+		}
+		return 0, nil
+	}
+
+	timestamp, sequence, err := signing.Verify(config.Server.SignedReports.Secret, machine,
+		c.Get(signing.HeaderTimestamp), c.Get(signing.HeaderSequence), signature, c.Body())
+	if err != nil {
+		return 0, err
+	}
+	return sequence, reportTracker.Accept(machine, timestamp, sequence, now)
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/equals215/deepsentinel/cluster"
+	"github.com/equals215/deepsentinel/tokens"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/spf13/cobra"
+)
+
+// tokenRequest is the body of a token creation request
+type tokenRequest struct {
+	Name     string   `json:"name"`
+	Machines []string `json:"machines"`
+}
+
+// withoutHash returns a copy of token that can be shown, the hash is only shared with the cluster peers
+func withoutHash(token *tokens.Token) *tokens.Token {
+	tokenCopy := *token
+	tokenCopy.Hash = ""
+	return &tokenCopy
+}
+
+func getTokensHandler(c *fiber.Ctx) error {
+	list := tokens.List()
+	for i, token := range list {
+		list[i] = withoutHash(token)
+	}
+	return c.JSON(list)
+}
+
+func postTokenHandler(c *fiber.Ctx) error {
+	request := &tokenRequest{}
+	if err := json.Unmarshal(c.Body(), request); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "fail",
+			"error":  err.Error(),
+		})
+	}
+
+	token, secret, err := tokens.Create(strings.TrimSpace(request.Name), request.Machines)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"status": "fail",
+			"error":  err.Error(),
+		})
+	}
+	cluster.Replicate(&cluster.Message{
+		Kind:      cluster.KindToken,
+		Machine:   token.ID,
+		Timestamp: token.CreatedAt,
+		Token:     token,
+	})
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"token":  withoutHash(token),
+		"secret": secret,
+	})
+}
+
+func deleteTokenHandler(c *fiber.Ctx) error {
+	id := strings.TrimSpace(utils.CopyString(c.Params("id")))
+
+	err := tokens.Revoke(id)
+	if errors.Is(err, tokens.ErrTokenNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"status": "fail",
+			"token":  id,
+			"error":  err.Error(),
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"status": "fail",
+			"token":  id,
+			"error":  err.Error(),
+		})
+	}
+	cluster.Replicate(&cluster.Message{
+		Kind:      cluster.KindRevokeToken,
+		Machine:   id,
+		Timestamp: time.Now(),
+	})
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// tokenCmd manages the machine tokens file, a running server picks the changes up
+func tokenCmd() *cobra.Command {
+	var path string
+
+	tokenCmd := &cobra.Command{
+		Use:   "token",
+		Short: "Manage the machine tokens",
+		Run: func(cmd *cobra.Command, args []string) {
+			cmd.Help()
+		},
+	}
+	tokenCmd.PersistentFlags().StringVarP(&path, "path", "p", tokens.DefaultPath, "Tokens file, must be the tokens.path of the server")
+
+	var name string
+	var machines []string
+	createCmd := &cobra.Command{
+		Use:   "create",
+		Short: "Create a token bound to machine names or globs",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := tokens.Open(path)
+			if err != nil {
+				return err
+			}
+			token, secret, err := store.Create(name, machines)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Token %s created for %s\n", token.ID, strings.Join(token.Machines, ", "))
+			fmt.Printf("[WILL ONLY BE OUTPUT ONCE] Secret: %s\n", secret)
+			return nil
+		},
+	}
+	createCmd.Flags().StringVarP(&name, "name", "n", "", "Description of the token")
+	createCmd.Flags().StringSliceVarP(&machines, "machine", "m", nil, "Machine name or glob the token is bound to, repeatable or comma separated")
+	createCmd.MarkFlagRequired("machine")
+	tokenCmd.AddCommand(createCmd)
+
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the tokens",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := tokens.Open(path)
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tNAME\tMACHINES\tCREATED")
+			for _, token := range store.List() {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", token.ID, token.Name, strings.Join(token.Machines, ","), token.CreatedAt.Format(time.RFC3339))
+			}
+			return w.Flush()
+		},
+	}
+	tokenCmd.AddCommand(listCmd)
+
+	revokeCmd := &cobra.Command{
+		Use:   "revoke [id]",
+		Short: "Revoke a token",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			store, err := tokens.Open(path)
+			if err != nil {
+				return err
+			}
+			if err := store.Revoke(args[0]); err != nil {
+				return err
+			}
+			fmt.Printf("Token %s revoked\n", args[0])
+			return nil
+		},
+	}
+	tokenCmd.AddCommand(revokeCmd)
+
+	return tokenCmd
+}
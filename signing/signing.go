@@ -0,0 +1,101 @@
+// Package signing signs the agent reports with HMAC-SHA256 so that the server can reject forged and replayed ones.
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers carrying the signature of a report
+const (
+	HeaderTimestamp = "X-DeepSentinel-Timestamp"
+	HeaderSequence  = "X-DeepSentinel-Sequence"
+	HeaderSignature = "X-DeepSentinel-Signature"
+)
+
+var (
+	// ErrUnsigned is returned for a report without signature when they are required, or when its machine signed before
+	ErrUnsigned = errors.New("report isn't signed")
+	// ErrInvalidSignature is returned for a report whose signature doesn't match
+	ErrInvalidSignature = errors.New("invalid report signature")
+	// ErrStale is returned for a report signed too long ago or in the future
+	ErrStale = errors.New("report timestamp is outside the allowed clock skew")
+	// ErrReplayed is returned for a report whose sequence isn't above the last accepted one
+	ErrReplayed = errors.New("report sequence is duplicated or out of order")
+)
+
+// Sign returns the signature of a report of machine, formatted as the HeaderSignature value
+func Sign(secret, machine string, timestamp int64, sequence uint64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d\n%d\n%s\n", timestamp, sequence, machine)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify parses the header values of a report of machine and checks its signature
+func Verify(secret, machine, timestamp, sequence, signature string, body []byte) (int64, uint64, error) {
+	parsedTimestamp, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid %s header", HeaderTimestamp)
+	}
+	parsedSequence, err := strconv.ParseUint(sequence, 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid %s header", HeaderSequence)
+	}
+	expected := Sign(secret, machine, parsedTimestamp, parsedSequence, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return 0, 0, ErrInvalidSignature
+	}
+	return parsedTimestamp, parsedSequence, nil
+}
+
+// Tracker remembers the last sequence accepted for each machine
+type Tracker struct {
+	sync.Mutex
+	maxSkew time.Duration
+	last    map[string]uint64
+}
+
+// NewTracker creates a tracker accepting the reports signed within maxSkew of the server clock
+func NewTracker(maxSkew time.Duration) *Tracker {
+	return &Tracker{maxSkew: maxSkew, last: make(map[string]uint64)}
+}
+
+// Accept checks the timestamp and the sequence of a verified report of machine then records its sequence
+func (t *Tracker) Accept(machine string, timestamp int64, sequence uint64, now time.Time) error {
+	skew := now.Sub(time.Unix(timestamp, 0))
+	if skew > t.maxSkew || skew < -t.maxSkew {
+		return ErrStale
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	if sequence <= t.last[machine] {
+		return ErrReplayed
+	}
+	t.last[machine] = sequence
+	return nil
+}
+
+// Signed returns true once a signed report of machine was accepted, by this server or a peer
+func (t *Tracker) Signed(machine string) bool {
+	t.Lock()
+	defer t.Unlock()
+	_, ok := t.last[machine]
+	return ok
+}
+
+// Observe records the sequence of a report of machine accepted by another server
+func (t *Tracker) Observe(machine string, sequence uint64) {
+	t.Lock()
+	defer t.Unlock()
+	if sequence > t.last[machine] {
+		t.last[machine] = sequence
+	}
+}
@@ -0,0 +1,54 @@
+package signing
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigning(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"machineStatus":"pass"}`)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	signature := Sign("secret", "machine1", now.Unix(), 1, body)
+
+	// Test case 1: a signed report is verified
+	parsedTimestamp, sequence, err := Verify("secret", "machine1", timestamp, "1", signature, body)
+	assert.Nil(t, err)
+	assert.Equal(t, now.Unix(), parsedTimestamp)
+	assert.Equal(t, uint64(1), sequence)
+
+	// Test case 2: the signature covers the secret, the machine, the sequence and the body
+	_, _, err = Verify("other", "machine1", timestamp, "1", signature, body)
+	assert.Equal(t, ErrInvalidSignature, err)
+	_, _, err = Verify("secret", "machine2", timestamp, "1", signature, body)
+	assert.Equal(t, ErrInvalidSignature, err)
+	_, _, err = Verify("secret", "machine1", timestamp, "2", signature, body)
+	assert.Equal(t, ErrInvalidSignature, err)
+	_, _, err = Verify("secret", "machine1", timestamp, "1", signature, []byte(`{"machineStatus":"fail"}`))
+	assert.Equal(t, ErrInvalidSignature, err)
+	_, _, err = Verify("secret", "machine1", "yesterday", "1", signature, body)
+	assert.NotNil(t, err)
+
+	// Test case 3: stale, duplicated and out of order reports are rejected
+	tracker := NewTracker(30 * time.Second)
+	assert.Nil(t, tracker.Accept("machine1", now.Unix(), 5, now))
+	assert.Equal(t, ErrReplayed, tracker.Accept("machine1", now.Unix(), 5, now))
+	assert.Equal(t, ErrReplayed, tracker.Accept("machine1", now.Unix(), 4, now))
+	assert.Equal(t, ErrStale, tracker.Accept("machine1", now.Add(-time.Minute).Unix(), 6, now))
+	assert.Equal(t, ErrStale, tracker.Accept("machine1", now.Add(time.Minute).Unix(), 6, now))
+	assert.Nil(t, tracker.Accept("machine2", now.Unix(), 1, now))
+
+	// Test case 4: sequences accepted by a peer are taken into account
+	tracker.Observe("machine1", 10)
+	assert.Equal(t, ErrReplayed, tracker.Accept("machine1", now.Unix(), 8, now))
+	assert.Nil(t, tracker.Accept("machine1", now.Unix(), 11, now))
+
+	// Test case 5: machines are known to sign once a signed report was accepted
+	assert.True(t, tracker.Signed("machine1"))
+	assert.False(t, tracker.Signed("machine3"))
+	tracker.Observe("machine3", 1)
+	assert.True(t, tracker.Signed("machine3"))
+}
@@ -0,0 +1,157 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const jsonStoreExtension = ".json"
+
+// approvalsFilename is the file of the approved machines, it has no extension so that it's never loaded as a probe
+const approvalsFilename = "approvals"
+
+// JSONStore persists each probe as a JSON snapshot file in a directory
+type JSONStore struct {
+	sync.Mutex
+	path string
+}
+
+// NewJSONStore creates the snapshot directory if needed and returns a JSONStore using it
+func NewJSONStore(path string) (*JSONStore, error) {
+	if path == "" {
+		return nil, fmt.Errorf("state path is required")
+	}
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	return &JSONStore{path: path}, nil
+}
+
+// Load reads every probe snapshot in the directory
+// A snapshot that can't be read or parsed is logged and skipped so that it doesn't lose the others
+func (s *JSONStore) Load() ([]*ProbeState, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	entries, err := os.ReadDir(s.path)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]*ProbeState, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), jsonStoreExtension) {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(s.path, entry.Name()))
+		if err != nil {
+			log.WithField("file", entry.Name()).WithError(err).Error("Failed to read probe snapshot, skipping")
+			continue
+		}
+
+		state := &ProbeState{}
+		if err := json.Unmarshal(content, state); err != nil {
+			log.WithField("file", entry.Name()).WithError(err).Error("Failed to parse probe snapshot, skipping")
+			continue
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// Save writes the probe snapshot, replacing the previous one atomically
+func (s *JSONStore) Save(state *ProbeState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	return s.write(s.filename(state.Name), content)
+}
+
+// LoadApprovals reads the approved machines, none when they were never saved
+func (s *JSONStore) LoadApprovals() ([]string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	content, err := os.ReadFile(filepath.Join(s.path, approvalsFilename))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var machines []string
+	if err := json.Unmarshal(content, &machines); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", approvalsFilename, err)
+	}
+	return machines, nil
+}
+
+// SaveApprovals writes the approved machines, replacing the previous ones atomically
+func (s *JSONStore) SaveApprovals(machines []string) error {
+	content, err := json.Marshal(machines)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	return s.write(filepath.Join(s.path, approvalsFilename), content)
+}
+
+// write replaces filename with content through a synced temporary file, caller must hold the lock
+func (s *JSONStore) write(filename string, content []byte) error {
+	tmp, err := os.CreateTemp(s.path, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	// The content must be on disk before the rename, or a crash could leave an empty snapshot in place
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
+
+// Delete removes the probe snapshot
+func (s *JSONStore) Delete(name string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	err := os.Remove(s.filename(name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Close does nothing as snapshots are written synchronously
+func (s *JSONStore) Close() error {
+	return nil
+}
+
+func (s *JSONStore) filename(name string) string {
+	return filepath.Join(s.path, url.PathEscape(name)+jsonStoreExtension)
+}
@@ -0,0 +1,110 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJSONStore(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := New("json", dir)
+	assert.Nil(t, err, "Failed to create JSON store")
+
+	// Test case 1: empty directory
+	states, err := s.Load()
+	assert.Nil(t, err)
+	assert.Empty(t, states)
+
+	// Test case 2: saved states are loaded back
+	lastNormal := time.Now().Add(-time.Minute).Round(0)
+	state := &ProbeState{
+		Name:       "machine/1",
+		Status:     "failed",
+		Counter:    3,
+		LastNormal: lastNormal,
+		Services: map[string]*ServiceState{
+			"nginx": {Status: "fail", Count: 2},
+		},
+	}
+	assert.Nil(t, s.Save(state))
+	assert.Nil(t, s.Save(&ProbeState{Name: "machine2", Status: "normal"}))
+
+	states, err = s.Load()
+	assert.Nil(t, err)
+	assert.Len(t, states, 2)
+	for _, loaded := range states {
+		if loaded.Name == "machine/1" {
+			assert.Equal(t, "failed", loaded.Status)
+			assert.Equal(t, 3, loaded.Counter)
+			assert.True(t, lastNormal.Equal(loaded.LastNormal))
+			assert.Equal(t, &ServiceState{Status: "fail", Count: 2}, loaded.Services["nginx"])
+		}
+	}
+
+	// Test case 3: saving again replaces the snapshot
+	state.Status = "alertedLow"
+	assert.Nil(t, s.Save(state))
+	states, err = s.Load()
+	assert.Nil(t, err)
+	assert.Len(t, states, 2)
+
+	// Test case 4: deleted states are not loaded anymore, deleting twice is fine
+	assert.Nil(t, s.Delete("machine/1"))
+	assert.Nil(t, s.Delete("machine/1"))
+	states, err = s.Load()
+	assert.Nil(t, err)
+	assert.Len(t, states, 1)
+	assert.Equal(t, "machine2", states[0].Name)
+
+	// Test case 5: no temporary file is left behind
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "machine2.json", filepath.Base(entries[0].Name()))
+
+	// Test case 6: a corrupt snapshot is skipped without losing the others
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "machine3.json"), []byte(`{"name": "mach`), 0600))
+	states, err = s.Load()
+	assert.Nil(t, err)
+	assert.Len(t, states, 1)
+	assert.Equal(t, "machine2", states[0].Name)
+}
+
+func TestJSONStoreApprovals(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewJSONStore(dir)
+	assert.Nil(t, err)
+
+	// Test case 1: no approval was saved yet
+	approved, err := s.LoadApprovals()
+	assert.Nil(t, err)
+	assert.Empty(t, approved)
+
+	// Test case 2: saved approvals are loaded back and never as a probe
+	assert.Nil(t, s.SaveApprovals([]string{"db-1", "db-2"}))
+	assert.Nil(t, s.SaveApprovals([]string{"db-2"}))
+	approved, err = s.LoadApprovals()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"db-2"}, approved)
+	states, err := s.Load()
+	assert.Nil(t, err)
+	assert.Empty(t, states)
+}
+
+func TestNew(t *testing.T) {
+	s, err := New("", "")
+	assert.Nil(t, err)
+	assert.Nil(t, s)
+
+	_, err = New("json", "")
+	assert.EqualError(t, err, "state path is required")
+
+	_, err = New("bolt", "/tmp")
+	assert.EqualError(t, err, "'bolt' is an unknown state backend")
+}
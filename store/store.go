@@ -0,0 +1,68 @@
+// Package store provides the backends persisting the server probes state.
+package store
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/equals215/deepsentinel/config"
+)
+
+// Store is the interface for state backends
+type Store interface {
+	Load() ([]*ProbeState, error)
+	Save(state *ProbeState) error
+	Delete(name string) error
+	// LoadApprovals returns the machines approved to register
+	LoadApprovals() ([]string, error)
+	// SaveApprovals replaces the machines approved to register
+	SaveApprovals(machines []string) error
+	Close() error
+}
+
+// ProbeState is the persisted state of a probe
+type ProbeState struct {
+	Name       string                   `json:"name"`
+	Status     string                   `json:"status"`
+	Counter    int                      `json:"counter"`
+	LastNormal time.Time                `json:"lastNormal"`
+	LastReport time.Time                `json:"lastReport"`
+	Services   map[string]*ServiceState `json:"services,omitempty"`
+	// Thresholds are the thresholds last declared by the agent
+	Thresholds *config.ThresholdsConfig `json:"thresholds,omitempty"`
+	// Labels are the labels last declared by the agent
+	Labels   map[string]string `json:"labels,omitempty"`
+	Silences []*SilenceState   `json:"silences,omitempty"`
+}
+
+// ServiceState is the persisted state of a service of a probe
+type ServiceState struct {
+	Status   string `json:"status"`
+	Count    int    `json:"count"`
+	Flapping bool   `json:"flapping,omitempty"`
+}
+
+// SilenceState is a persisted silence of a probe, an empty Service silences the whole machine
+type SilenceState struct {
+	Service   string    `json:"service,omitempty"`
+	Until     time.Time `json:"until,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// New returns the store for the given backend
+// A nil store is returned when no backend is configured
+func New(backend, path string) (Store, error) {
+	switch backend {
+	case "", "none":
+		return nil, nil
+	case "json":
+		jsonStore, err := NewJSONStore(path)
+		if err != nil {
+			return nil, err
+		}
+		return jsonStore, nil
+	default:
+		return nil, fmt.Errorf("'%s' is an unknown state backend", backend)
+	}
+}
@@ -0,0 +1,176 @@
+// Package tlsconfig builds the TLS configurations of the server and the agent from certificate files reloaded once rotated.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Files holds a certificate with its key and a CA bundle, each of them optional
+// They are reloaded when one of the files changed on disk, a file that can't be loaded keeps the previous ones
+type Files struct {
+	sync.Mutex
+	certFile string
+	keyFile  string
+	caFile   string
+	modTimes [3]time.Time
+	cert     *tls.Certificate
+	pool     *x509.CertPool
+}
+
+// Load loads the certificate in certFile and keyFile and the CA bundle in caFile
+// certFile and keyFile must be both set or both empty
+func Load(certFile, keyFile, caFile string) (*Files, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("certificate and key files must be set together")
+	}
+	f := &Files{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if _, err := f.reload(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// reload loads the files again when they changed and returns true if they did, caller must hold the lock
+func (f *Files) reload() (bool, error) {
+	var modTimes [3]time.Time
+	for i, file := range []string{f.certFile, f.keyFile, f.caFile} {
+		if file == "" {
+			continue
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return false, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	if modTimes == f.modTimes {
+		return false, nil
+	}
+
+	var cert *tls.Certificate
+	if f.certFile != "" {
+		loaded, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+		if err != nil {
+			return false, fmt.Errorf("failed to load certificate: %w", err)
+		}
+		cert = &loaded
+	}
+	var pool *x509.CertPool
+	if f.caFile != "" {
+		content, err := os.ReadFile(f.caFile)
+		if err != nil {
+			return false, err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(content) {
+			return false, fmt.Errorf("no certificate found in %s", f.caFile)
+		}
+	}
+	f.cert = cert
+	f.pool = pool
+	f.modTimes = modTimes
+	return true, nil
+}
+
+// current returns the certificate and the CA pool, reloaded if they changed
+func (f *Files) current() (*tls.Certificate, *x509.CertPool) {
+	f.Lock()
+	defer f.Unlock()
+
+	reloaded, err := f.reload()
+	if err != nil {
+		log.WithError(err).Warn("Failed to reload TLS files, keeping the previous ones")
+	} else if reloaded {
+		log.WithField("certificate", f.certFile).Info("TLS files reloaded")
+	}
+	return f.cert, f.pool
+}
+
+// Server returns the server TLS configuration, each handshake uses the current files
+// Client certificates are verified against the CA bundle when there is one, and required if requireClientCert is set
+func Server(f *Files, requireClientCert bool) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := f.current()
+			if cert == nil {
+				return nil, errors.New("no server certificate")
+			}
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+			}
+			if pool != nil {
+				config.ClientCAs = pool
+				config.ClientAuth = tls.VerifyClientCertIfGiven
+				if requireClientCert {
+					config.ClientAuth = tls.RequireAndVerifyClientCert
+				}
+			}
+			return config, nil
+		},
+	}
+}
+
+// Client returns the client TLS configuration
+// The server is verified against the CA bundle, or the system roots when there is none
+// The CA bundle and the certificate presented to the servers asking for one are reloaded once rotated
+func Client(f *Files) *tls.Config {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := f.current()
+			if cert == nil {
+				return &tls.Certificate{}, nil
+			}
+			return cert, nil
+		},
+	}
+	if f.caFile != "" {
+		// The built-in verification only knows the pool given upfront, the server is verified against the current one instead
+		config.InsecureSkipVerify = true
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			_, pool := f.current()
+			return verifyServer(state, pool)
+		}
+	}
+	return config
+}
+
+// verifyServer verifies the certificate chain and the name of the server of state against pool
+func verifyServer(state tls.ConnectionState, pool *x509.CertPool) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("no server certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range state.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		DNSName:       state.ServerName,
+		Roots:         pool,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+// Identities returns the common name and the DNS names of the verified client certificate of state
+func Identities(state *tls.ConnectionState) []string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	leaf := state.VerifiedChains[0][0]
+	identities := make([]string, 0, len(leaf.DNSNames)+1)
+	if leaf.Subject.CommonName != "" {
+		identities = append(identities, leaf.Subject.CommonName)
+	}
+	return append(identities, leaf.DNSNames...)
+}
@@ -0,0 +1,156 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCert writes a certificate signed by parent, self-signed when parent is nil, and returns it with its key
+func writeCert(t *testing.T, dir, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.Nil(t, err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, name+"-key.pem"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return cert, key
+}
+
+func leafTemplate(serial int64, commonName string, dnsNames []string, usage x509.ExtKeyUsage) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey := writeCert(t, dir, "ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "deepsentinel-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	writeCert(t, dir, "server", leafTemplate(2, "server-1", nil, x509.ExtKeyUsageServerAuth), ca, caKey)
+	writeCert(t, dir, "agent", leafTemplate(3, "machine1", []string{"machine1.example.com"}, x509.ExtKeyUsageClientAuth), ca, caKey)
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	serverFiles, err := Load(path("server.pem"), path("server-key.pem"), path("ca.pem"))
+	assert.Nil(t, err)
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", Server(serverFiles, true))
+	assert.Nil(t, err)
+	defer listener.Close()
+	identities := make(chan []string, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			tlsConn := conn.(*tls.Conn)
+			if tlsConn.Handshake() == nil {
+				state := tlsConn.ConnectionState()
+				identities <- Identities(&state)
+				io.WriteString(conn, "ok")
+			}
+			conn.Close()
+		}
+	}()
+
+	// Test case 1: the client certificate names are the identities of the agent
+	agentFiles, err := Load(path("agent.pem"), path("agent-key.pem"), path("ca.pem"))
+	assert.Nil(t, err)
+	conn, err := tls.Dial("tcp", listener.Addr().String(), Client(agentFiles))
+	assert.Nil(t, err)
+	_, err = io.ReadAll(conn)
+	assert.Nil(t, err)
+	conn.Close()
+	assert.Equal(t, []string{"machine1", "machine1.example.com"}, <-identities)
+
+	// Test case 2: a client without certificate is rejected when it is required
+	noCertFiles, err := Load("", "", path("ca.pem"))
+	assert.Nil(t, err)
+	conn, err = tls.Dial("tcp", listener.Addr().String(), Client(noCertFiles))
+	if err == nil {
+		_, err = io.ReadAll(conn)
+		conn.Close()
+	}
+	assert.NotNil(t, err)
+
+	// Test case 3: a rotated certificate is used by the next handshakes
+	writeCert(t, dir, "server", leafTemplate(4, "server-2", nil, x509.ExtKeyUsageServerAuth), ca, caKey)
+	future := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(path("server.pem"), future, future))
+	conn, err = tls.Dial("tcp", listener.Addr().String(), Client(agentFiles))
+	assert.Nil(t, err)
+	assert.Equal(t, "server-2", conn.ConnectionState().PeerCertificates[0].Subject.CommonName)
+	_, err = io.ReadAll(conn)
+	assert.Nil(t, err)
+	conn.Close()
+	<-identities
+
+	// Test case 4: the certificate and its key must be set together
+	_, err = Load(path("agent.pem"), "", "")
+	assert.NotNil(t, err)
+
+	// Test case 5: a rotated CA bundle is used to verify the server by the next handshakes
+	caContent, err := os.ReadFile(path("ca.pem"))
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(path("client-ca.pem"), caContent, 0600))
+	clientFiles, err := Load(path("agent.pem"), path("agent-key.pem"), path("client-ca.pem"))
+	assert.Nil(t, err)
+	clientConfig := Client(clientFiles)
+	conn, err = tls.Dial("tcp", listener.Addr().String(), clientConfig)
+	assert.Nil(t, err)
+	_, err = io.ReadAll(conn)
+	assert.Nil(t, err)
+	conn.Close()
+	<-identities
+	writeCert(t, dir, "client-ca", &x509.Certificate{
+		SerialNumber:          big.NewInt(5),
+		Subject:               pkix.Name{CommonName: "other-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	assert.Nil(t, os.Chtimes(path("client-ca.pem"), future, future))
+	_, err = tls.Dial("tcp", listener.Addr().String(), clientConfig)
+	assert.NotNil(t, err)
+
+	// Test case 6: without CA bundle the server is verified against the system roots, a private CA isn't trusted
+	rootsFiles, err := Load(path("agent.pem"), path("agent-key.pem"), "")
+	assert.Nil(t, err)
+	_, err = tls.Dial("tcp", listener.Addr().String(), Client(rootsFiles))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,331 @@
+// Package tokens manages the credentials of the agents, each bound to the machine names it may act as.
+package tokens
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultPath is the tokens file used when none is configured
+const DefaultPath = "/etc/deepsentinel/tokens.json"
+
+// secretPrefix tells the machine tokens apart from the global token
+const secretPrefix = "ds_"
+
+var (
+	// ErrTokenNotFound is returned when revoking an unknown token
+	ErrTokenNotFound = errors.New("token not found")
+	// ErrNoMachines is returned when creating a token bound to no machine
+	ErrNoMachines = errors.New("a token must be bound to at least one machine")
+)
+
+// Token is a credential bound to machine names or globs, only the hash of its secret is stored
+type Token struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name,omitempty"`
+	Machines  []string  `json:"machines"`
+	Hash      string    `json:"hash"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Allows returns true when the token is bound to machine
+func (t *Token) Allows(machine string) bool {
+	for _, pattern := range t.Machines {
+		if matched, _ := path.Match(pattern, machine); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Store holds the tokens persisted in a JSON file
+// The file is reloaded when it changed on disk so that tokens managed by the CLI apply to a running server
+type Store struct {
+	sync.Mutex
+	path    string
+	tokens  map[string]*Token
+	modTime time.Time
+}
+
+var store *Store
+
+// Init opens the store used by the package functions
+func Init(path string) error {
+	newStore, err := Open(path)
+	if err != nil {
+		return err
+	}
+	store = newStore
+	return nil
+}
+
+// Lookup returns the token of secret from the store set by Init
+func Lookup(secret string) (*Token, bool) {
+	if store == nil {
+		return nil, false
+	}
+	return store.Lookup(secret)
+}
+
+// List returns the tokens of the store set by Init
+func List() []*Token {
+	if store == nil {
+		return []*Token{}
+	}
+	return store.List()
+}
+
+// Create creates a token in the store set by Init
+func Create(name string, machines []string) (*Token, string, error) {
+	if store == nil {
+		return nil, "", fmt.Errorf("tokens store isn't initialized")
+	}
+	return store.Create(name, machines)
+}
+
+// Add adds a token created by another server to the store set by Init
+func Add(token *Token) error {
+	if store == nil {
+		return fmt.Errorf("tokens store isn't initialized")
+	}
+	return store.Add(token)
+}
+
+// Revoke revokes a token of the store set by Init
+func Revoke(id string) error {
+	if store == nil {
+		return ErrTokenNotFound
+	}
+	return store.Revoke(id)
+}
+
+// Open loads the tokens file at path, a missing file holds no token
+func Open(path string) (*Store, error) {
+	if path == "" {
+		return nil, fmt.Errorf("tokens path is required")
+	}
+	s := &Store{path: path, tokens: make(map[string]*Token)}
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload reads the tokens file when it changed since the last read, caller must hold the lock
+func (s *Store) reload() error {
+	info, err := os.Stat(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		s.tokens = make(map[string]*Token)
+		s.modTime = time.Time{}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(s.modTime) {
+		return nil
+	}
+
+	content, err := os.ReadFile(s.path)
+	if err != nil {
+		return err
+	}
+	var tokens []*Token
+	if err := json.Unmarshal(content, &tokens); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", s.path, err)
+	}
+	s.tokens = make(map[string]*Token, len(tokens))
+	for _, token := range tokens {
+		s.tokens[token.ID] = token
+	}
+	s.modTime = info.ModTime()
+	return nil
+}
+
+// save writes the tokens file atomically, caller must hold the lock
+func (s *Store) save() error {
+	content, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".tokens-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.modTime = info.ModTime()
+	return nil
+}
+
+// sorted returns the tokens from the oldest to the newest, caller must hold the lock
+func (s *Store) sorted() []*Token {
+	tokens := make([]*Token, 0, len(s.tokens))
+	for _, token := range s.tokens {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].ID < tokens[j].ID
+		}
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+	return tokens
+}
+
+// Lookup returns the token of secret
+func (s *Store) Lookup(secret string) (*Token, bool) {
+	if !strings.HasPrefix(secret, secretPrefix) {
+		return nil, false
+	}
+	hash := hashSecret(secret)
+
+	s.Lock()
+	defer s.Unlock()
+	// A file that can't be read keeps the tokens loaded last
+	s.reload()
+	for _, token := range s.tokens {
+		if token.Hash == hash {
+			tokenCopy := *token
+			return &tokenCopy, true
+		}
+	}
+	return nil, false
+}
+
+// List returns the tokens from the oldest to the newest
+func (s *Store) List() []*Token {
+	s.Lock()
+	defer s.Unlock()
+	s.reload()
+
+	tokens := s.sorted()
+	for i, token := range tokens {
+		tokenCopy := *token
+		tokens[i] = &tokenCopy
+	}
+	return tokens
+}
+
+// Create creates a token bound to machines and returns it with its secret, which isn't stored
+func (s *Store) Create(name string, machines []string) (*Token, string, error) {
+	if len(machines) == 0 {
+		return nil, "", ErrNoMachines
+	}
+	for _, pattern := range machines {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, "", fmt.Errorf("invalid machine '%s': %v", pattern, err)
+		}
+	}
+
+	secret, err := randomHex(24)
+	if err != nil {
+		return nil, "", err
+	}
+	secret = secretPrefix + secret
+
+	s.Lock()
+	defer s.Unlock()
+	if err := s.reload(); err != nil {
+		return nil, "", err
+	}
+
+	var id string
+	for id == "" || s.tokens[id] != nil {
+		if id, err = randomHex(4); err != nil {
+			return nil, "", err
+		}
+	}
+	token := &Token{
+		ID:        id,
+		Name:      name,
+		Machines:  append([]string{}, machines...),
+		Hash:      hashSecret(secret),
+		CreatedAt: time.Now().UTC(),
+	}
+	s.tokens[id] = token
+	if err := s.save(); err != nil {
+		delete(s.tokens, id)
+		return nil, "", err
+	}
+	tokenCopy := *token
+	return &tokenCopy, secret, nil
+}
+
+// Add adds a token created elsewhere, replacing the token with the same ID
+func (s *Store) Add(token *Token) error {
+	if token.ID == "" || token.Hash == "" {
+		return fmt.Errorf("token ID and hash are required")
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+	tokenCopy := *token
+	s.tokens[token.ID] = &tokenCopy
+	return s.save()
+}
+
+// Revoke deletes the token id, its secret is rejected right away
+func (s *Store) Revoke(id string) error {
+	s.Lock()
+	defer s.Unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return ErrTokenNotFound
+	}
+	delete(s.tokens, id)
+	if err := s.save(); err != nil {
+		s.tokens[id] = token
+		return err
+	}
+	return nil
+}
+
+func hashSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func randomHex(size int) (string, error) {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,57 @@
+package tokens
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	s, err := Open(path)
+	assert.Nil(t, err)
+	assert.Empty(t, s.List())
+
+	// Test case 1: a token is found by its secret and only allows its machines
+	token, secret, err := s.Create("web servers", []string{"web-*", "db-1"})
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(secret, secretPrefix))
+	found, ok := s.Lookup(secret)
+	assert.True(t, ok)
+	assert.Equal(t, token.ID, found.ID)
+	assert.True(t, found.Allows("web-3"))
+	assert.True(t, found.Allows("db-1"))
+	assert.False(t, found.Allows("db-2"))
+	_, ok = s.Lookup(secret + "x")
+	assert.False(t, ok)
+
+	// Test case 2: only the hash of the secret is stored
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(content), secret)
+	assert.Contains(t, string(content), token.Hash)
+
+	// Test case 3: a token needs at least one valid machine
+	_, _, err = s.Create("none", nil)
+	assert.Equal(t, ErrNoMachines, err)
+	_, _, err = s.Create("invalid", []string{"web-["})
+	assert.NotNil(t, err)
+
+	// Test case 4: tokens changed by another store are reloaded
+	other, err := Open(path)
+	assert.Nil(t, err)
+	_, otherSecret, err := other.Create("agent", []string{"agent-1"})
+	assert.Nil(t, err)
+	_, ok = s.Lookup(otherSecret)
+	assert.True(t, ok)
+	assert.Len(t, s.List(), 2)
+
+	// Test case 5: a revoked token is rejected
+	assert.Nil(t, other.Revoke(token.ID))
+	_, ok = s.Lookup(secret)
+	assert.False(t, ok)
+	assert.Equal(t, ErrTokenNotFound, s.Revoke(token.ID))
+}